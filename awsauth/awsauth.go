@@ -0,0 +1,201 @@
+// Package awsauth configures the credentials that connectors use to authenticate with AWS.
+package awsauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Method is a way of obtaining AWS credentials.
+type Method string
+
+const (
+	// MethodStatic uses the configured access key ID and secret access key.
+	MethodStatic Method = "static"
+	// MethodDefault uses the default credential provider chain of the AWS SDK, which looks for
+	// credentials in environment variables, shared config and credentials files (using the
+	// configured profile, if any), web identity tokens given by environment variables, and the ECS
+	// and EC2 instance roles, in that order.
+	MethodDefault Method = "default"
+	// MethodWebIdentity assumes the configured role with the web identity token in the configured
+	// file, such as one which is projected into a Kubernetes pod.
+	MethodWebIdentity Method = "webIdentity"
+	// MethodAnonymous doesn't sign requests at all, which only works for public resources.
+	MethodAnonymous Method = "anonymous"
+)
+
+// defaultRoleSessionName is used for assumed roles when no session name is configured.
+const defaultRoleSessionName = "estuary-connector"
+
+// stsDefaultRegion is the region of STS requests when no region is configured, which is that of the
+// global STS endpoint.
+const stsDefaultRegion = "us-east-1"
+
+// Config is the AWS authentication configuration of a connector. It's meant to be embedded in the
+// endpoint configuration of the connector, so that its fields are top-level properties of it.
+//
+// If no method is given, then static credentials are used if an access key ID is given, and the
+// fallback method of the connector is used otherwise. Any of the methods besides `anonymous` and
+// `webIdentity` may be combined with a role ARN, in which case those credentials are used to assume
+// the role.
+type Config struct {
+	AuthMethod           Method `json:"awsAuthMethod,omitempty" jsonschema:"title=AWS Authentication Method,enum=static,enum=default,enum=webIdentity,enum=anonymous" jsonschema_description:"How to authenticate with AWS: with an access key (static), with the default credential chain of the AWS SDK (default), with a web identity token (webIdentity), or not at all (anonymous). If empty, an access key is used if one is given, and otherwise the connector falls back to its own default."`
+	AWSAccessKeyID       string `json:"awsAccessKeyId,omitempty" jsonschema:"title=AWS Access Key ID" jsonschema_description:"Part of the AWS credentials of the static method."`
+	AWSSecretAccessKey   string `json:"awsSecretAccessKey,omitempty" jsonschema:"title=AWS Secret Access Key" jsonschema_description:"Part of the AWS credentials of the static method."`
+	Profile              string `json:"awsProfile,omitempty" jsonschema:"title=AWS Profile" jsonschema_description:"The profile of the shared AWS config and credentials files to use with the default method."`
+	RoleARN              string `json:"awsRoleArn,omitempty" jsonschema:"title=AWS Role ARN" jsonschema_description:"The ARN of an IAM role to assume, which is required by the webIdentity method and optional for the static and default methods."`
+	ExternalID           string `json:"awsExternalId,omitempty" jsonschema:"title=AWS External ID" jsonschema_description:"The external ID that the trust policy of the role requires, if any."`
+	RoleSessionName      string `json:"awsRoleSessionName,omitempty" jsonschema:"title=AWS Role Session Name" jsonschema_description:"The session name of the assumed role, which identifies the connector in AWS CloudTrail logs."`
+	WebIdentityTokenFile string `json:"awsWebIdentityTokenFile,omitempty" jsonschema:"title=AWS Web Identity Token File" jsonschema_description:"The path of the file containing the web identity token of the webIdentity method."`
+}
+
+// Validate returns an error if the config is not well-formed.
+func (c *Config) Validate() error {
+	if c.AWSAccessKeyID == "" && c.AWSSecretAccessKey != "" {
+		return fmt.Errorf("missing awsAccessKeyId")
+	}
+	if c.AWSAccessKeyID != "" && c.AWSSecretAccessKey == "" {
+		return fmt.Errorf("missing awsSecretAccessKey")
+	}
+	switch c.AuthMethod {
+	case "":
+	case MethodStatic:
+		if c.AWSAccessKeyID == "" {
+			return fmt.Errorf("missing awsAccessKeyId, which the %s awsAuthMethod requires", c.AuthMethod)
+		}
+	case MethodDefault, MethodWebIdentity, MethodAnonymous:
+		if c.AWSAccessKeyID != "" {
+			return fmt.Errorf("awsAccessKeyId can't be given with the %s awsAuthMethod", c.AuthMethod)
+		}
+	default:
+		return fmt.Errorf("invalid awsAuthMethod %q", c.AuthMethod)
+	}
+
+	if c.Profile != "" && c.AuthMethod != MethodDefault {
+		return fmt.Errorf("awsProfile can only be given with the %s awsAuthMethod", MethodDefault)
+	}
+	if c.AuthMethod == MethodWebIdentity {
+		if c.RoleARN == "" {
+			return fmt.Errorf("missing awsRoleArn, which the %s awsAuthMethod requires", c.AuthMethod)
+		} else if c.WebIdentityTokenFile == "" {
+			return fmt.Errorf("missing awsWebIdentityTokenFile, which the %s awsAuthMethod requires", c.AuthMethod)
+		}
+	} else if c.WebIdentityTokenFile != "" {
+		return fmt.Errorf("awsWebIdentityTokenFile can only be given with the %s awsAuthMethod", MethodWebIdentity)
+	}
+	if c.AuthMethod == MethodAnonymous && c.RoleARN != "" {
+		return fmt.Errorf("awsRoleArn can't be given with the %s awsAuthMethod", c.AuthMethod)
+	}
+	if c.RoleARN == "" && (c.ExternalID != "" || c.RoleSessionName != "") {
+		return fmt.Errorf("awsExternalId and awsRoleSessionName can only be given with awsRoleArn")
+	}
+	return nil
+}
+
+// ResolvedMethod returns the method that's used to obtain credentials, given the fallback method
+// of the connector.
+func (c *Config) ResolvedMethod(fallback Method) Method {
+	if c.AuthMethod != "" {
+		return c.AuthMethod
+	} else if c.AWSAccessKeyID != "" {
+		return MethodStatic
+	}
+	return fallback
+}
+
+// NewSession returns an AWS session with the given AWS configuration, which authenticates using the
+// configured method, or the fallback method if none is configured and no access key is given. The
+// given AWS configuration isn't modified.
+func (c *Config) NewSession(awsConfig *aws.Config, fallback Method) (*session.Session, error) {
+	var method = c.ResolvedMethod(fallback)
+	var creds *credentials.Credentials
+	switch method {
+	case MethodStatic:
+		creds = credentials.NewStaticCredentials(c.AWSAccessKeyID, c.AWSSecretAccessKey, "")
+	case MethodAnonymous:
+		creds = credentials.AnonymousCredentials
+	case MethodDefault:
+		// The session resolves the default credential chain when it has no credentials.
+	case MethodWebIdentity:
+		// The web identity token is exchanged for credentials below.
+	default:
+		return nil, fmt.Errorf("invalid awsAuthMethod %q", method)
+	}
+
+	if c.RoleARN != "" {
+		// Roles are assumed through STS, which must use its own endpoint rather than any custom
+		// endpoint of the service that the connector uses. Requests to assume a role with a web
+		// identity aren't signed.
+		var stsRegion = aws.StringValue(awsConfig.Region)
+		if stsRegion == "" {
+			stsRegion = stsDefaultRegion
+		}
+		var stsConfig = aws.NewConfig().
+			WithRegion(stsRegion).
+			WithCredentialsChainVerboseErrors(true)
+		if method == MethodWebIdentity {
+			stsConfig = stsConfig.WithCredentials(credentials.AnonymousCredentials)
+		} else if creds != nil {
+			stsConfig = stsConfig.WithCredentials(creds)
+		}
+		var stsSession, err = c.session(stsConfig, method)
+		if err != nil {
+			return nil, fmt.Errorf("creating aws session for assuming role: %w", err)
+		}
+
+		var sessionName = c.RoleSessionName
+		if sessionName == "" {
+			sessionName = defaultRoleSessionName
+		}
+		if method == MethodWebIdentity {
+			creds = stscreds.NewWebIdentityCredentials(stsSession, c.RoleARN, sessionName, c.WebIdentityTokenFile)
+		} else {
+			creds = stscreds.NewCredentials(stsSession, c.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+				p.RoleSessionName = sessionName
+				if c.ExternalID != "" {
+					p.ExternalID = aws.String(c.ExternalID)
+				}
+			})
+		}
+	}
+
+	var sessionConfig = awsConfig.Copy().WithCredentialsChainVerboseErrors(true)
+	if creds != nil {
+		sessionConfig = sessionConfig.WithCredentials(creds)
+	}
+	var awsSession, err = c.session(sessionConfig, method)
+	if err != nil {
+		return nil, fmt.Errorf("creating aws session: %w", err)
+	}
+	return awsSession, nil
+}
+
+// session returns a session with the given AWS configuration. With the default method, shared
+// config files are loaded so that profiles and their settings are honored.
+func (c *Config) session(awsConfig *aws.Config, method Method) (*session.Session, error) {
+	var opts = session.Options{Config: *awsConfig}
+	if method == MethodDefault {
+		opts.Profile = c.Profile
+		opts.SharedConfigState = session.SharedConfigEnable
+	}
+	return session.NewSessionWithOptions(opts)
+}
+
+// SchemaProperties returns the JSON schema properties of the Config, separated by commas, for
+// inclusion in the "properties" of the JSON schema of an endpoint configuration. Connectors whose
+// schemas are generated from their config structs get the same properties by embedding the Config.
+func SchemaProperties() string {
+	var reflector = jsonschema.Reflector{ExpandedStruct: true, DoNotReference: true}
+	var properties, err = json.Marshal(reflector.Reflect(&Config{}).Properties)
+	if err != nil {
+		panic(fmt.Sprintf("generating aws auth schema: %v", err))
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(properties), "{"), "}")
+}
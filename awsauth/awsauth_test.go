@@ -0,0 +1,80 @@
+package awsauth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	for _, valid := range []Config{
+		{},
+		{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret"},
+		{AuthMethod: MethodStatic, AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", RoleARN: "arn", ExternalID: "ext"},
+		{AuthMethod: MethodDefault, Profile: "prod", RoleARN: "arn", RoleSessionName: "capture"},
+		{AuthMethod: MethodWebIdentity, RoleARN: "arn", WebIdentityTokenFile: "/var/run/token"},
+		{AuthMethod: MethodAnonymous},
+	} {
+		require.NoError(t, valid.Validate(), "validating %#v", valid)
+	}
+
+	for _, invalid := range []Config{
+		{AWSAccessKeyID: "id"},
+		{AWSSecretAccessKey: "secret"},
+		{AuthMethod: "instance"},
+		{AuthMethod: MethodStatic},
+		{AuthMethod: MethodDefault, AWSAccessKeyID: "id", AWSSecretAccessKey: "secret"},
+		{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", Profile: "prod"},
+		{AuthMethod: MethodWebIdentity, RoleARN: "arn"},
+		{AuthMethod: MethodWebIdentity, WebIdentityTokenFile: "/var/run/token"},
+		{AuthMethod: MethodDefault, WebIdentityTokenFile: "/var/run/token"},
+		{AuthMethod: MethodAnonymous, RoleARN: "arn"},
+		{ExternalID: "ext"},
+	} {
+		require.Error(t, invalid.Validate(), "validating %#v", invalid)
+	}
+}
+
+func TestResolvedMethod(t *testing.T) {
+	require.Equal(t, MethodAnonymous, (&Config{}).ResolvedMethod(MethodAnonymous))
+	require.Equal(t, MethodDefault, (&Config{}).ResolvedMethod(MethodDefault))
+	require.Equal(t, MethodStatic, (&Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret"}).ResolvedMethod(MethodAnonymous))
+	require.Equal(t, MethodWebIdentity, (&Config{AuthMethod: MethodWebIdentity}).ResolvedMethod(MethodAnonymous))
+}
+
+func TestNewSession(t *testing.T) {
+	var awsConfig = aws.NewConfig().WithRegion("us-west-2").WithEndpoint("http://localhost:4566")
+
+	var config = Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret"}
+	var sess, err = config.NewSession(awsConfig, MethodAnonymous)
+	require.NoError(t, err)
+	creds, err := sess.Config.Credentials.Get()
+	require.NoError(t, err)
+	require.Equal(t, "id", creds.AccessKeyID)
+	require.Equal(t, "secret", creds.SecretAccessKey)
+	require.Equal(t, "http://localhost:4566", aws.StringValue(sess.Config.Endpoint))
+
+	config = Config{}
+	sess, err = config.NewSession(awsConfig, MethodAnonymous)
+	require.NoError(t, err)
+	require.Equal(t, credentials.AnonymousCredentials, sess.Config.Credentials)
+
+	// Assuming a role doesn't modify the given configuration, which continues to use the endpoint.
+	config = Config{AuthMethod: MethodWebIdentity, RoleARN: "arn:aws:iam::123456789012:role/capture", WebIdentityTokenFile: "/var/run/token"}
+	sess, err = config.NewSession(awsConfig, MethodAnonymous)
+	require.NoError(t, err)
+	require.NotEqual(t, credentials.AnonymousCredentials, sess.Config.Credentials)
+	require.Nil(t, awsConfig.Credentials)
+	require.Equal(t, "http://localhost:4566", aws.StringValue(sess.Config.Endpoint))
+}
+
+func TestSchemaProperties(t *testing.T) {
+	var properties map[string]map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte("{"+SchemaProperties()+"}"), &properties))
+	require.Len(t, properties, 8)
+	require.Equal(t, []interface{}{"static", "default", "webIdentity", "anonymous"}, properties["awsAuthMethod"]["enum"])
+	require.Equal(t, "AWS Access Key ID", properties["awsAccessKeyId"]["title"])
+}
@@ -15,6 +15,7 @@ require (
 	github.com/brianvoe/gofakeit/v6 v6.9.0
 	github.com/estuary/flow v0.1.1-0.20211014150201-5fb28e9026f9
 	github.com/estuary/protocols v0.0.0-20211129055338-9259b2dca80a
+	github.com/go-mysql-org/go-mysql v1.3.0
 	github.com/gogo/protobuf v1.3.2
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/google/uuid v1.3.0
@@ -10,6 +10,7 @@ import (
 
 	"github.com/alecthomas/jsonschema"
 	"github.com/benbjohnson/clock"
+	"github.com/estuary/connectors/awsauth"
 	boilerplate "github.com/estuary/connectors/materialize-boilerplate"
 	pf "github.com/estuary/protocols/flow"
 	pm "github.com/estuary/protocols/materialize"
@@ -18,11 +19,10 @@ import (
 )
 
 type config struct {
-	AWSAccessKeyID     string `json:"awsAccessKeyId"`
-	AWSSecretAccessKey string `json:"awsSecretAccessKey"`
-	Bucket             string `json:"bucket"`
-	Endpoint           string `json:"endpoint,omitempty" jsonschema:"oneof_required=endpoint"`
-	Region             string `json:"region,omitempty" jsonschema:"oneof_required=region"`
+	awsauth.Config
+	Bucket   string `json:"bucket"`
+	Endpoint string `json:"endpoint,omitempty" jsonschema:"oneof_required=endpoint"`
+	Region   string `json:"region,omitempty" jsonschema:"oneof_required=region"`
 	// The driver batches materialization results to local files first,
 	// and uploads the local files to cloud (S3) on a schedule specified by
 	// UploadIntervalInSeconds, which is the mimimal wait time (in seconds) between two
@@ -38,11 +38,8 @@ func (c config) Validate() error {
 	if c.Bucket == "" {
 		return fmt.Errorf("missing bucket")
 	}
-	if c.AWSAccessKeyID == "" && c.AWSSecretAccessKey != "" {
-		return fmt.Errorf("missing awsAccessKeyID")
-	}
-	if c.AWSAccessKeyID != "" && c.AWSSecretAccessKey == "" {
-		return fmt.Errorf("missing awsSecretAccessKey")
+	if err := c.Config.Validate(); err != nil {
+		return err
 	}
 	if c.UploadIntervalInSeconds < 0 {
 		return fmt.Errorf("UploadIntervalInSeconds should be non-negative")
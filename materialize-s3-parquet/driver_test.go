@@ -9,6 +9,7 @@ import (
 
 	"github.com/benbjohnson/clock"
 	"github.com/bradleyjkemp/cupaloy"
+	"github.com/estuary/connectors/awsauth"
 	pf "github.com/estuary/protocols/flow"
 	pm "github.com/estuary/protocols/materialize"
 	"github.com/stretchr/testify/require"
@@ -17,8 +18,10 @@ import (
 
 func TestConfig(t *testing.T) {
 	var validConfig = config{
-		AWSAccessKeyID:          "testKey",
-		AWSSecretAccessKey:      "testSecret",
+		Config: awsauth.Config{
+			AWSAccessKeyID:     "testKey",
+			AWSSecretAccessKey: "testSecret",
+		},
 		Bucket:                  "testBucket",
 		Endpoint:                "",
 		Region:                  "us-east-1",
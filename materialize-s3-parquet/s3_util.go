@@ -5,10 +5,9 @@ import (
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/estuary/connectors/awsauth"
 )
 
 // Uploader is the interface of the object responsible for uploading local files to the cloud.
@@ -46,14 +45,6 @@ func (u *S3Uploader) Upload(key, localFileName string, contentType string) error
 func NewS3Uploader(cfg config) (*S3Uploader, error) {
 	var c = aws.NewConfig()
 
-	if cfg.AWSSecretAccessKey != "" {
-		var creds = credentials.NewStaticCredentials(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, "")
-		c = c.WithCredentials(creds)
-	} else {
-		c = c.WithCredentials(credentials.AnonymousCredentials)
-	}
-	c = c.WithCredentialsChainVerboseErrors(true)
-
 	if cfg.Region != "" {
 		c = c.WithRegion(cfg.Region)
 	}
@@ -62,9 +53,9 @@ func NewS3Uploader(cfg config) (*S3Uploader, error) {
 		c = c.WithEndpoint(cfg.Endpoint)
 	}
 
-	awsSession, err := session.NewSession(c)
+	awsSession, err := cfg.NewSession(c, awsauth.MethodAnonymous)
 	if err != nil {
-		return nil, fmt.Errorf("creating aws session: %w", err)
+		return nil, err
 	}
 
 	return &S3Uploader{
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/estuary/protocols/airbyte"
@@ -21,12 +22,16 @@ import (
 // The `wg` is expected to have been incremented once _prior_ to calling this function. It will be
 // further incremented and decremented behind the scenes, but will be decremented back down to the
 // prior value when the read is finished.
-func readStream(ctx context.Context, shardRange airbyte.Range, client *kinesis.Kinesis, stream string, state map[string]string, resultsCh chan<- readResult, stopAt *time.Time, wg *sync.WaitGroup) {
+func readStream(ctx context.Context, shardRange airbyte.Range, client *kinesis.Kinesis, stream string, opts *readOptions, state map[string]string, resultsCh chan<- readResult, stopAt *time.Time, wg *sync.WaitGroup) {
 
+	var config = opts.streamConfig(stream)
 	var kc = &streamReader{
 		client:         client,
 		ctx:            ctx,
 		stream:         stream,
+		opts:           opts,
+		config:         config,
+		decoder:        newRecordDecoder(config),
 		shardRange:     shardRange,
 		dataCh:         resultsCh,
 		readingShards:  make(map[string]bool),
@@ -54,11 +59,55 @@ func readStream(ctx context.Context, shardRange airbyte.Range, client *kinesis.K
 	}
 }
 
+// readOptions controls how the records of kinesis streams are read.
+type readOptions struct {
+	// The shard iterator type used for kinesis shards that have no previous state, which is
+	// either TRIM_HORIZON or AT_TIMESTAMP.
+	startIteratorType string
+	// The arrival time from which to read, for the AT_TIMESTAMP iterator type.
+	startTime time.Time
+	// Whether to add a `_meta` property to each document.
+	includeMetadata bool
+	// The configuration of each stream which has any.
+	streams map[string]*StreamConfig
+}
+
+// streamConfig returns the configuration of the named stream, or the default configuration if it
+// has none.
+func (o *readOptions) streamConfig(stream string) *StreamConfig {
+	if config, ok := o.streams[stream]; ok {
+		return config
+	}
+	return &defaultStreamConfig
+}
+
+// newReadOptions returns the read options of the given config. The LATEST start position is
+// translated into reading from the time at which the capture started, so that it's applied
+// consistently to shards that are created later on. Those are read only once their parents have
+// been read completely, and reading them from their own latest record could skip over records
+// added in the meantime.
+func newReadOptions(config *Config, started time.Time) *readOptions {
+	var opts = &readOptions{
+		startIteratorType: config.StartPosition,
+		startTime:         config.startTime,
+		includeMetadata:   config.IncludeMetadata,
+		streams:           config.Streams,
+	}
+	if opts.startIteratorType == kinesis.ShardIteratorTypeLatest {
+		opts.startIteratorType = kinesis.ShardIteratorTypeAtTimestamp
+		opts.startTime = started
+	}
+	return opts
+}
+
 // Represents an ongoing read of a kinesis stream.
 type streamReader struct {
 	client             *kinesis.Kinesis
 	ctx                context.Context
 	stream             string
+	opts               *readOptions
+	config             *StreamConfig
+	decoder            *recordDecoder
 	shardRange         airbyte.Range
 	dataCh             chan<- readResult
 	stopAt             *time.Time
@@ -382,10 +431,19 @@ func (r *shardReader) readShardIterator(iteratorID string) error {
 			r.updateRecordLimit(getRecordsResp)
 
 			var lastSequenceID = *getRecordsResp.Records[len(getRecordsResp.Records)-1].SequenceNumber
+			var records, err = r.extractRecords(getRecordsResp)
+			if err != nil {
+				// Unlike errors from kinesis, this won't be resolved by retrying, so the failure
+				// is reported back to the main thread instead of being returned.
+				select {
+				case r.parent.dataCh <- readResult{source: r.source, err: err}:
+				case <-r.parent.ctx.Done():
+				}
+				return nil
+			}
 			var msg = readResult{
 				source:         r.source,
-				records:        r.extractRecords(getRecordsResp),
-				err:            err,
+				records:        records,
 				sequenceNumber: lastSequenceID,
 			}
 			select {
@@ -436,23 +494,108 @@ func (r *shardReader) readShardIterator(iteratorID string) error {
 }
 
 // Extracts the records from a response, filtering the records if necessary due to claiming partial
-// ownership over the kinesis shard.
-func (r *shardReader) extractRecords(resp *kinesis.GetRecordsOutput) []json.RawMessage {
-	if r.rangeOverlap == airbyte.PartialRangeOverlap {
-		var result []json.RawMessage
-		for _, rec := range resp.Records {
-			var keyHash = hashPartitionKey(*rec.PartitionKey)
-			if isRecordWithinRange(r.parent.shardRange, r.kinesisShardRange, keyHash) {
-				result = append(result, json.RawMessage(rec.Data))
-			}
+// ownership over the kinesis shard. Records that were aggregated by the Kinesis Producer Library are
+// de-aggregated, and each of their sub-records is filtered individually by its own hash key, since
+// that's what determined the shard it was written to. Checkpoints are only ever taken after
+// complete kinesis records, so the sub-records of an aggregated record are never split across
+// them, and sub-records that are read again after resuming are identified by their sub-sequence
+// numbers.
+func (r *shardReader) extractRecords(resp *kinesis.GetRecordsOutput) ([]json.RawMessage, error) {
+	var result = make([]json.RawMessage, 0, len(resp.Records))
+	for _, rec := range resp.Records {
+		var docs, err = r.recordDocuments(rec)
+		if err != nil {
+			return nil, fmt.Errorf("kinesis record %s: %w", aws.StringValue(rec.SequenceNumber), err)
 		}
-		return result
-	} else {
-		var result = make([]json.RawMessage, len(resp.Records))
-		for i, rec := range resp.Records {
-			result[i] = json.RawMessage(rec.Data)
+		result = append(result, docs...)
+	}
+	return result, nil
+}
+
+// recordDocuments returns the documents of a kinesis record that fall within this capture shard's
+// range.
+func (r *shardReader) recordDocuments(rec *kinesis.Record) ([]json.RawMessage, error) {
+	var subRecords, err = deaggregate(rec.Data)
+	if err != nil {
+		return r.undecodable(rec, rec.Data, err)
+	} else if subRecords == nil {
+		if !r.ownsKeyHash(hashPartitionKey(aws.StringValue(rec.PartitionKey))) {
+			return nil, nil
+		}
+		return r.documents(rec, &subRecord{partitionKey: aws.StringValue(rec.PartitionKey), data: rec.Data}, 0)
+	}
+
+	var result []json.RawMessage
+	for i := range subRecords {
+		var keyHash, err = subRecords[i].keyHash()
+		if err != nil {
+			return nil, fmt.Errorf("sub-record %d: %w", i, err)
+		} else if !r.ownsKeyHash(keyHash) {
+			continue
 		}
-		return result
+		docs, err := r.documents(rec, &subRecords[i], i)
+		if err != nil {
+			return nil, fmt.Errorf("sub-record %d: %w", i, err)
+		}
+		result = append(result, docs...)
+	}
+	return result, nil
+}
+
+// ownsKeyHash returns whether records with the given key hash should be captured by this capture
+// shard, which is always the case unless it only partially overlaps the kinesis shard.
+func (r *shardReader) ownsKeyHash(keyHash uint32) bool {
+	if r.rangeOverlap != airbyte.PartialRangeOverlap {
+		return true
+	}
+	return isRecordWithinRange(r.parent.shardRange, r.kinesisShardRange, keyHash)
+}
+
+// documents decodes the documents of a user record, which is either a kinesis record or a sub-record
+// of one with the given sub-sequence number, with metadata added if so configured.
+func (r *shardReader) documents(rec *kinesis.Record, sub *subRecord, subSequenceNumber int) ([]json.RawMessage, error) {
+	var docs, err = r.parent.decoder.decode(sub.data)
+	if err != nil {
+		return r.undecodable(rec, sub.data, err)
+	}
+	if !r.parent.opts.includeMetadata {
+		return docs, nil
+	}
+	for i := range docs {
+		if docs[i], err = withMetadata(docs[i], &recordMeta{
+			Stream:                      r.source.stream,
+			ShardID:                     r.source.shardID,
+			PartitionKey:                sub.partitionKey,
+			ExplicitHashKey:             sub.explicitHashKey,
+			SequenceNumber:              aws.StringValue(rec.SequenceNumber),
+			SubSequenceNumber:           subSequenceNumber,
+			Index:                       i,
+			ApproximateArrivalTimestamp: rec.ApproximateArrivalTimestamp,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// undecodable handles data of a kinesis record that couldn't be decoded, according to the error
+// policy of the stream. It returns the documents to capture in its place, if any.
+func (r *shardReader) undecodable(rec *kinesis.Record, data []byte, decodeErr error) ([]json.RawMessage, error) {
+	switch r.parent.config.ErrorPolicy {
+	case errorPolicySkip:
+		r.logEntry.WithFields(log.Fields{
+			"sequenceNumber": aws.StringValue(rec.SequenceNumber),
+			"error":          decodeErr,
+		}).Warn("skipping kinesis record that couldn't be decoded")
+		return nil, nil
+	case errorPolicyCapture:
+		var doc, err = errorDocument(data, decodeErr)
+		if err != nil {
+			return nil, err
+		}
+		return []json.RawMessage{doc}, nil
+	default:
+		return nil, decodeErr
 	}
 }
 
@@ -486,6 +629,16 @@ func (r *shardReader) updateRecordLimit(resp *kinesis.GetRecordsOutput) {
 }
 
 func (r *shardReader) getShardIterator() (string, error) {
+	shardIterResp, err := r.parent.client.GetShardIteratorWithContext(r.parent.ctx, r.shardIteratorInput())
+	if err != nil {
+		return "", err
+	}
+	return *shardIterResp.ShardIterator, nil
+}
+
+// shardIteratorInput returns the request for a shard iterator which resumes after the last record
+// that was read, or starts from the configured position if no records have been read yet.
+func (r *shardReader) shardIteratorInput() *kinesis.GetShardIteratorInput {
 	var shardIterReq = kinesis.GetShardIteratorInput{
 		StreamName: &r.parent.stream,
 		ShardId:    &r.source.shardID,
@@ -493,20 +646,19 @@ func (r *shardReader) getShardIterator() (string, error) {
 	if r.lastSequenceID != "" {
 		shardIterReq.StartingSequenceNumber = &r.lastSequenceID
 		shardIterReq.ShardIteratorType = &START_AFTER_SEQ
+	} else if r.parent.opts.startIteratorType == START_AT_TIMESTAMP {
+		shardIterReq.ShardIteratorType = &START_AT_TIMESTAMP
+		shardIterReq.Timestamp = &r.parent.opts.startTime
 	} else {
 		shardIterReq.ShardIteratorType = &START_AT_BEGINNING
 	}
-
-	shardIterResp, err := r.parent.client.GetShardIteratorWithContext(r.parent.ctx, &shardIterReq)
-	if err != nil {
-		return "", err
-	}
-	return *shardIterResp.ShardIterator, nil
+	return &shardIterReq
 }
 
 var (
 	START_AFTER_SEQ    = "AFTER_SEQUENCE_NUMBER"
 	START_AT_BEGINNING = "TRIM_HORIZON"
+	START_AT_TIMESTAMP = "AT_TIMESTAMP"
 )
 
 // isContextCanceled returns true if the error is due to a context cancelation.
@@ -94,14 +94,14 @@ func TestKinesisCaptureWithShardOverlap(t *testing.T) {
 		End:   math.MaxUint32 / 2,
 	}
 	waitGroup.Add(1)
-	go readStream(ctx, shard1Range, client, stream, nil, dataCh, nil, waitGroup)
+	go readStream(ctx, shard1Range, client, stream, newReadOptions(&conf, time.Now()), nil, dataCh, nil, waitGroup)
 
 	var shard2Range = airbyte.Range{
 		Begin: math.MaxUint32 / 2,
 		End:   math.MaxUint32,
 	}
 	waitGroup.Add(1)
-	go readStream(ctx, shard2Range, client, stream, nil, dataCh, nil, waitGroup)
+	go readStream(ctx, shard2Range, client, stream, newReadOptions(&conf, time.Now()), nil, dataCh, nil, waitGroup)
 
 	var partitionKeys = []string{"furst", "sekund", "thuurd", "phorth"}
 	var sequencNumbers = make(map[string]string)
@@ -3,46 +3,77 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/estuary/connectors/awsauth"
 	log "github.com/sirupsen/logrus"
 )
 
 // Config represents the fully merged endpoint configuration for Kinesis.
 // It matches the `KinesisConfig` struct in `crates/sources/src/specs.rs`
 type Config struct {
-	Endpoint           string `json:"endpoint"`
-	Region             string `json:"region"`
-	AWSAccessKeyID     string `json:"awsAccessKeyId"`
-	AWSSecretAccessKey string `json:"awsSecretAccessKey"`
+	awsauth.Config
+	Endpoint string `json:"endpoint"`
+	Region   string `json:"region"`
+	// StartPosition is where reading begins in kinesis shards for which there's no previous
+	// state: TRIM_HORIZON (the oldest record), LATEST, or AT_TIMESTAMP.
+	StartPosition string `json:"startPosition"`
+	// StartTimestamp is the RFC3339 time from which the AT_TIMESTAMP position reads.
+	StartTimestamp string `json:"startTimestamp"`
+	// IncludeMetadata adds a `_meta` property to each document, which identifies the kinesis
+	// record it came from.
+	IncludeMetadata bool `json:"includeMetadata"`
+	// Streams configures how the records of particular streams are decoded. Records of other
+	// streams are JSON documents.
+	Streams map[string]*StreamConfig `json:"streams"`
+
+	startTime time.Time // The parsed StartTimestamp
 }
 
 func (c *Config) Validate() error {
 	if c.Region == "" {
 		return fmt.Errorf("missing region")
 	}
-	if c.AWSAccessKeyID == "" {
-		return fmt.Errorf("missing awsAccessKeyId")
+	if err := c.Config.Validate(); err != nil {
+		return err
+	}
+	switch c.StartPosition {
+	case "":
+		c.StartPosition = kinesis.ShardIteratorTypeTrimHorizon
+	case kinesis.ShardIteratorTypeTrimHorizon, kinesis.ShardIteratorTypeLatest:
+	case kinesis.ShardIteratorTypeAtTimestamp:
+		var t, err = time.Parse(time.RFC3339, c.StartTimestamp)
+		if err != nil {
+			return fmt.Errorf("invalid startTimestamp %q: %w", c.StartTimestamp, err)
+		}
+		c.startTime = t
+	default:
+		return fmt.Errorf("invalid startPosition %q", c.StartPosition)
 	}
-	if c.AWSSecretAccessKey == "" {
-		return fmt.Errorf("missing awsSecretAccessKey")
+	for name, stream := range c.Streams {
+		if stream == nil {
+			return fmt.Errorf("stream %q: missing configuration", name)
+		} else if err := stream.Validate(); err != nil {
+			return fmt.Errorf("stream %q: %w", name, err)
+		}
 	}
 	return nil
 }
 
+// authFallback is how the connector authenticates with AWS if no method or access key is given.
+const authFallback = awsauth.MethodDefault
+
 var configJSONSchema = `{
 	"$schema": "http://json-schema.org/draft-07/schema#",
 	"title":   "Kinesis Source Spec",
 	"type":    "object",
 	"required": [
-		"region",
-		"awsAccessKeyId",
-		"awsSecretAccessKey"
+		"region"
 	],
 	"properties": {
+		` + awsauth.SchemaProperties() + `,
 		"region": {
 			"type":        "string",
 			"title":       "AWS Region",
@@ -54,17 +85,57 @@ var configJSONSchema = `{
 			"title":       "AWS Endpoint",
 			"description": "The AWS endpoint URI to connect to, useful if you're capturing from a kinesis-compatible API that isn't provided by AWS"
 		},
-		"awsAccessKeyId": {
+		"startPosition": {
 			"type":        "string",
-			"title":       "AWS Access Key ID",
-			"description": "Part of the AWS credentials that will be used to connect to Kinesis",
-			"default":     "example-aws-access-key-id"
+			"title":       "Start Position",
+			"description": "Where to begin reading kinesis shards which haven't been read before: from the oldest record (TRIM_HORIZON), from records added after the capture starts (LATEST), or from records added after startTimestamp (AT_TIMESTAMP)",
+			"enum":        ["TRIM_HORIZON", "LATEST", "AT_TIMESTAMP"],
+			"default":     "TRIM_HORIZON"
 		},
-		"awsSecretAccessKey": {
+		"startTimestamp": {
 			"type":        "string",
-			"title":       "AWS Secret Access Key",
-			"description": "Part of the AWS credentials that will be used to connect to Kinesis",
-			"default":     "example-aws-secret-access-key"
+			"format":      "date-time",
+			"title":       "Start Timestamp",
+			"description": "The time from which to begin reading when startPosition is AT_TIMESTAMP"
+		},
+		"includeMetadata": {
+			"type":        "boolean",
+			"title":       "Include Metadata",
+			"description": "Add a _meta property to each document, with the stream, shard ID, partition key, sequence number, and approximate arrival timestamp of the kinesis record it came from",
+			"default":     false
+		},
+		"streams": {
+			"type":        "object",
+			"title":       "Stream Formats",
+			"description": "How the records of particular streams are decoded, by stream name. Records of other streams are JSON documents",
+			"additionalProperties": {
+				"type": "object",
+				"properties": {
+					"format": {
+						"type":        "string",
+						"description": "The format of record data, which may contain multiple JSON documents or lines of separated values",
+						"enum":        ["json", "csv", "tsv"],
+						"default":     "json"
+					},
+					"compression": {
+						"type":        "string",
+						"description": "The compression of record data. With 'auto', gzipped data is detected and decompressed",
+						"enum":        ["auto", "none", "gzip", "zlib"],
+						"default":     "auto"
+					},
+					"headers": {
+						"type":        "array",
+						"items":       {"type": "string"},
+						"description": "The column names of csv and tsv data. If empty, the first line of each record is its header"
+					},
+					"errorPolicy": {
+						"type":        "string",
+						"description": "What happens to records whose data can't be decoded: the capture fails ('fail'), the record is skipped ('skip'), or it's captured as a document with '_error' and '_raw' (base64) properties ('capture')",
+						"enum":        ["fail", "skip", "capture"],
+						"default":     "fail"
+					}
+				}
+			}
 		}
 	}
 }`
@@ -75,8 +146,7 @@ func connect(config *Config) (*kinesis.Kinesis, error) {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	var creds = credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, "")
-	var c = aws.NewConfig().WithCredentials(creds)
+	var c = aws.NewConfig()
 	if config.Region != "" {
 		c = c.WithRegion(config.Region)
 	}
@@ -84,9 +154,9 @@ func connect(config *Config) (*kinesis.Kinesis, error) {
 		c = c.WithEndpoint(config.Endpoint)
 	}
 
-	awsSession, err := session.NewSession(c)
+	awsSession, err := config.NewSession(c, authFallback)
 	if err != nil {
-		return nil, fmt.Errorf("creating aws config: %w", err)
+		return nil, err
 	}
 	return kinesis.New(awsSession), nil
 }
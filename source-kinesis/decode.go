@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// The supported formats of the data of kinesis records.
+const (
+	formatJSON = "json" // One or more whitespace-separated JSON documents.
+	formatCSV  = "csv"  // One or more lines of comma-separated values.
+	formatTSV  = "tsv"  // One or more lines of tab-separated values.
+)
+
+// The supported compression of the data of kinesis records.
+const (
+	compressionAuto = "auto" // Gzip compressed data is detected and decompressed, and other data is used as-is.
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+)
+
+// The policies for records whose data can't be decoded.
+const (
+	errorPolicyFail    = "fail"    // The capture fails.
+	errorPolicySkip    = "skip"    // The record is logged and skipped.
+	errorPolicyCapture = "capture" // The record is captured as an error document.
+)
+
+// The properties of the error documents of records that couldn't be decoded.
+const (
+	errorProperty = "_error"
+	rawProperty   = "_raw"
+)
+
+// StreamConfig configures how the records of a kinesis stream are decoded into documents.
+type StreamConfig struct {
+	Format      string `json:"format"`
+	Compression string `json:"compression"`
+	// Headers are the names of the columns of the csv and tsv formats. If empty, the first line of
+	// each record is its header.
+	Headers     []string `json:"headers"`
+	ErrorPolicy string   `json:"errorPolicy"`
+}
+
+// defaultStreamConfig is used for streams without any configuration.
+var defaultStreamConfig = StreamConfig{
+	Format:      formatJSON,
+	Compression: compressionAuto,
+	ErrorPolicy: errorPolicyFail,
+}
+
+func (c *StreamConfig) Validate() error {
+	switch c.Format {
+	case "":
+		c.Format = formatJSON
+	case formatJSON, formatCSV, formatTSV:
+	default:
+		return fmt.Errorf("invalid format %q (Avro and other formats aren't supported)", c.Format)
+	}
+	switch c.Compression {
+	case "":
+		c.Compression = compressionAuto
+	case compressionAuto, compressionNone, compressionGzip, compressionZlib:
+	default:
+		return fmt.Errorf("invalid compression %q", c.Compression)
+	}
+	switch c.ErrorPolicy {
+	case "":
+		c.ErrorPolicy = errorPolicyFail
+	case errorPolicyFail, errorPolicySkip, errorPolicyCapture:
+	default:
+		return fmt.Errorf("invalid errorPolicy %q", c.ErrorPolicy)
+	}
+	if len(c.Headers) > 0 && c.Format == formatJSON {
+		return fmt.Errorf("headers can only be given for the csv and tsv formats")
+	}
+	var seen = make(map[string]bool)
+	for _, header := range c.Headers {
+		if seen[header] {
+			return fmt.Errorf("duplicate header %q", header)
+		}
+		seen[header] = true
+	}
+	return nil
+}
+
+// recordDecoder decodes the data of kinesis records into JSON documents.
+type recordDecoder struct {
+	config *StreamConfig
+}
+
+func newRecordDecoder(config *StreamConfig) *recordDecoder {
+	return &recordDecoder{config: config}
+}
+
+// gzipMagic begins every gzip compressed stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decode returns the documents encoded in the data of a kinesis record, of which there may be any
+// number.
+func (d *recordDecoder) decode(data []byte) ([]json.RawMessage, error) {
+	var decompressed, err = d.decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	switch d.config.Format {
+	case formatCSV:
+		return decodeSeparatedValues(decompressed, ',', d.config.Headers)
+	case formatTSV:
+		return decodeSeparatedValues(decompressed, '\t', d.config.Headers)
+	default:
+		return decodeJSON(decompressed)
+	}
+}
+
+func (d *recordDecoder) decompress(data []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch d.config.Compression {
+	case compressionGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case compressionZlib:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	case compressionAuto:
+		if !bytes.HasPrefix(data, gzipMagic) {
+			return data, nil
+		}
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s data: %w", d.config.Compression, err)
+	}
+	defer r.Close()
+	if data, err = ioutil.ReadAll(r); err != nil {
+		return nil, fmt.Errorf("decompressing %s data: %w", d.config.Compression, err)
+	}
+	return data, nil
+}
+
+func decodeJSON(data []byte) ([]json.RawMessage, error) {
+	var docs []json.RawMessage
+	var dec = json.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding JSON: %w", err)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// decodeSeparatedValues decodes each line of data into a document whose properties are named by
+// the headers, which are read from the first line if none are given.
+func decodeSeparatedValues(data []byte, separator rune, headers []string) ([]json.RawMessage, error) {
+	var r = csv.NewReader(bytes.NewReader(data))
+	r.Comma = separator
+	r.LazyQuotes = separator == '\t'
+	r.ReuseRecord = true
+	if len(headers) > 0 {
+		r.FieldsPerRecord = len(headers)
+	}
+
+	var docs []json.RawMessage
+	for {
+		var row, err = r.Read()
+		if err == io.EOF {
+			return docs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding separated values: %w", err)
+		}
+		if len(headers) == 0 {
+			headers = append([]string(nil), row...)
+			continue
+		}
+		var doc = make(map[string]string, len(headers))
+		for i, header := range headers {
+			doc[header] = row[i]
+		}
+		bs, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, bs)
+	}
+}
+
+// errorDocument returns the document which is captured in place of a record that couldn't be
+// decoded, with the error and the raw data of the record.
+func errorDocument(data []byte, decodeErr error) (json.RawMessage, error) {
+	return json.Marshal(map[string]interface{}{
+		errorProperty: decodeErr.Error(),
+		rawProperty:   data,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/estuary/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeStrings(t *testing.T, config StreamConfig, data []byte) ([]string, error) {
+	require.NoError(t, config.Validate())
+	var docs, err = newRecordDecoder(&config).decode(data)
+	var result []string
+	for _, doc := range docs {
+		result = append(result, string(doc))
+	}
+	return result, err
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var docs, err = decodeStrings(t, StreamConfig{}, []byte(`{"a": 1} {"a": 2}
+{"a": 3}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3}`}, docs)
+
+	docs, err = decodeStrings(t, StreamConfig{}, []byte(`{"a": 1} {"a": `))
+	require.Error(t, err)
+	require.Nil(t, docs)
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	var gzipped, zlibbed bytes.Buffer
+	var gw = gzip.NewWriter(&gzipped)
+	var zw = zlib.NewWriter(&zlibbed)
+	for _, w := range []interface {
+		Write([]byte) (int, error)
+		Close() error
+	}{gw, zw} {
+		_, err := w.Write([]byte(`{"a": 1}`))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+
+	// Gzipped data is detected automatically by default.
+	var docs, err = decodeStrings(t, StreamConfig{}, gzipped.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a": 1}`}, docs)
+
+	docs, err = decodeStrings(t, StreamConfig{Compression: compressionGzip}, gzipped.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a": 1}`}, docs)
+
+	docs, err = decodeStrings(t, StreamConfig{Compression: compressionZlib}, zlibbed.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a": 1}`}, docs)
+
+	_, err = decodeStrings(t, StreamConfig{Compression: compressionGzip}, []byte(`{"a": 1}`))
+	require.Error(t, err)
+	_, err = decodeStrings(t, StreamConfig{Compression: compressionNone}, gzipped.Bytes())
+	require.Error(t, err)
+}
+
+func TestDecodeSeparatedValues(t *testing.T) {
+	var docs, err = decodeStrings(t, StreamConfig{Format: formatCSV}, []byte("a,b\n1,\"two, too\"\n3,4\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a":"1","b":"two, too"}`, `{"a":"3","b":"4"}`}, docs)
+
+	docs, err = decodeStrings(t, StreamConfig{Format: formatCSV, Headers: []string{"x", "y"}}, []byte("1,2\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"x":"1","y":"2"}`}, docs)
+
+	docs, err = decodeStrings(t, StreamConfig{Format: formatTSV}, []byte("a\tb\n1\t2 \"in\"\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a":"1","b":"2 \"in\""}`}, docs)
+
+	_, err = decodeStrings(t, StreamConfig{Format: formatCSV, Headers: []string{"x", "y"}}, []byte("1,2,3\n"))
+	require.Error(t, err)
+}
+
+func TestStreamConfigValidate(t *testing.T) {
+	for _, invalid := range []StreamConfig{
+		{Format: "avro"},
+		{Compression: "snappy"},
+		{ErrorPolicy: "ignore"},
+		{Format: formatJSON, Headers: []string{"a"}},
+		{Format: formatCSV, Headers: []string{"a", "a"}},
+	} {
+		require.Error(t, invalid.Validate(), "expected an error validating %#v", invalid)
+	}
+	var config StreamConfig
+	require.NoError(t, config.Validate())
+	require.Equal(t, defaultStreamConfig, config)
+}
+
+func TestDecodeErrorPolicies(t *testing.T) {
+	var resp = &kinesis.GetRecordsOutput{Records: []*kinesis.Record{
+		{Data: []byte(`{"a": 1}`), PartitionKey: aws.String("k"), SequenceNumber: aws.String("1")},
+		{Data: []byte(`not json`), PartitionKey: aws.String("k"), SequenceNumber: aws.String("2")},
+		{Data: []byte(`{"a": 2} {"a": 3}`), PartitionKey: aws.String("k"), SequenceNumber: aws.String("3")},
+	}}
+	var extract = func(policy string, includeMetadata bool) ([]string, error) {
+		var config = &StreamConfig{ErrorPolicy: policy}
+		require.NoError(t, config.Validate())
+		var reader = &shardReader{
+			rangeOverlap: airbyte.FullRangeOverlap,
+			parent: &streamReader{
+				stream:  "events",
+				opts:    &readOptions{includeMetadata: includeMetadata},
+				config:  config,
+				decoder: newRecordDecoder(config),
+			},
+			source:   &recordSource{stream: "events", shardID: "shardId-000000000001"},
+			logEntry: log.WithField("stream", "events"),
+		}
+		var docs, err = reader.extractRecords(resp)
+		var result []string
+		for _, doc := range docs {
+			result = append(result, string(doc))
+		}
+		return result, err
+	}
+
+	var _, err = extract(errorPolicyFail, false)
+	require.Error(t, err)
+
+	docs, err := extract(errorPolicySkip, false)
+	require.NoError(t, err)
+	require.Equal(t, []string{`{"a": 1}`, `{"a": 2}`, `{"a": 3}`}, docs)
+
+	docs, err = extract(errorPolicyCapture, false)
+	require.NoError(t, err)
+	require.Len(t, docs, 4)
+	var errDoc map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(docs[1]), &errDoc))
+	require.Contains(t, errDoc[errorProperty], "decoding JSON")
+	require.Equal(t, "bm90IGpzb24=", errDoc[rawProperty])
+
+	// Each document decoded from a record is identified by its index.
+	docs, err = extract(errorPolicySkip, true)
+	require.NoError(t, err)
+	require.Len(t, docs, 3)
+	for i, expect := range []string{`"sequenceNumber":"1","subSequenceNumber":0,"index":0`, `"sequenceNumber":"3","subSequenceNumber":0,"index":0`, `"sequenceNumber":"3","subSequenceNumber":0,"index":1`} {
+		require.Contains(t, docs[i], expect)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// The bounds on sampling the records of each stream during discovery, from which their schemas are
+// inferred.
+const (
+	sampleWindow      = time.Hour       // How far back from now records are sampled.
+	sampleTimeout     = 5 * time.Second // The time budget for sampling each stream.
+	sampleMaxDocs     = 1000            // The maximum number of documents sampled from each stream.
+	sampleConcurrency = 4               // The number of streams which are sampled at once.
+)
+
+// sampleStreams samples the documents of each of the named streams, which are returned in the
+// same order. Streams which can't be sampled are logged, and have no documents.
+func sampleStreams(ctx context.Context, client *kinesis.Kinesis, streams []string, opts *readOptions) [][]json.RawMessage {
+	var samples = make([][]json.RawMessage, len(streams))
+	var semaphore = make(chan struct{}, sampleConcurrency)
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(i int, stream string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			var docs, err = sampleStream(ctx, client, stream, opts.streamConfig(stream))
+			if err != nil {
+				log.WithFields(log.Fields{
+					"stream": stream,
+					"error":  err,
+				}).Warn("failed to sample kinesis stream, so its schema won't be inferred")
+				return
+			}
+			log.WithFields(log.Fields{
+				"stream":        stream,
+				"documentCount": len(docs),
+			}).Debug("sampled kinesis stream")
+			samples[i] = docs
+		}(i, stream)
+	}
+	wg.Wait()
+	return samples
+}
+
+// sampleStream returns documents of the records that were added to a stream within the sample
+// window, which are spread across all of its shards. It stops early once the time budget is spent,
+// returning whatever documents were read by then. Records that can't be decoded are skipped.
+func sampleStream(ctx context.Context, client *kinesis.Kinesis, stream string, config *StreamConfig) ([]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, sampleTimeout)
+	defer cancel()
+
+	var since = time.Now().Add(-sampleWindow)
+	var shardIDs, err = listShardsSince(ctx, client, stream, since)
+	if err != nil {
+		return nil, fmt.Errorf("listing shards: %w", err)
+	}
+
+	var decoder = newRecordDecoder(config)
+	var docs []json.RawMessage
+	for i, shardID := range shardIDs {
+		// Divide what's left of the document budget evenly among the remaining shards.
+		var quota = (sampleMaxDocs - len(docs)) / (len(shardIDs) - i)
+		if quota < 1 {
+			quota = 1
+		}
+		var shardDocs, err = sampleShard(ctx, client, stream, shardID, since, decoder, quota)
+		docs = append(docs, shardDocs...)
+		if ctx.Err() != nil {
+			break // The time budget has been spent.
+		} else if err != nil {
+			return nil, fmt.Errorf("reading shard %s: %w", shardID, err)
+		} else if len(docs) >= sampleMaxDocs {
+			break
+		}
+	}
+	return docs, nil
+}
+
+// listShardsSince returns the IDs of the shards of a stream which were open at any time since the
+// given time.
+func listShardsSince(ctx context.Context, client *kinesis.Kinesis, stream string, since time.Time) ([]string, error) {
+	var shardIDs []string
+	var input = &kinesis.ListShardsInput{
+		StreamName: &stream,
+		ShardFilter: &kinesis.ShardFilter{
+			Type:      aws.String(kinesis.ShardFilterTypeFromTimestamp),
+			Timestamp: &since,
+		},
+	}
+	for {
+		var resp, err = client.ListShardsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range resp.Shards {
+			shardIDs = append(shardIDs, *shard.ShardId)
+		}
+		if aws.StringValue(resp.NextToken) == "" {
+			return shardIDs, nil
+		}
+		input = &kinesis.ListShardsInput{NextToken: resp.NextToken}
+	}
+}
+
+// sampleShard returns up to `limit` documents of the records that were added to a shard since the
+// given time. It stops at the end of the shard or once it's caught up to the tip of it.
+func sampleShard(ctx context.Context, client *kinesis.Kinesis, stream, shardID string, since time.Time, decoder *recordDecoder, limit int) ([]json.RawMessage, error) {
+	var iterResp, err = client.GetShardIteratorWithContext(ctx, &kinesis.GetShardIteratorInput{
+		StreamName:        &stream,
+		ShardId:           &shardID,
+		ShardIteratorType: &START_AT_TIMESTAMP,
+		Timestamp:         &since,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []json.RawMessage
+	var limiter = rate.NewLimiter(rate.Every(time.Second), 5)
+	var shardIter = iterResp.ShardIterator
+	for aws.StringValue(shardIter) != "" && len(docs) < limit {
+		if err := limiter.Wait(ctx); err != nil {
+			return docs, err
+		}
+		var recordLimit = int64(limit - len(docs))
+		var resp, err = client.GetRecordsWithContext(ctx, &kinesis.GetRecordsInput{
+			ShardIterator: shardIter,
+			Limit:         &recordLimit,
+		})
+		if err != nil {
+			return docs, err
+		}
+		for _, rec := range resp.Records {
+			docs = append(docs, sampleDocuments(decoder, rec.Data)...)
+		}
+		if len(resp.Records) == 0 && aws.Int64Value(resp.MillisBehindLatest) == 0 {
+			break
+		}
+		shardIter = resp.NextShardIterator
+	}
+	if len(docs) > limit {
+		docs = docs[:limit]
+	}
+	return docs, nil
+}
+
+// sampleDocuments returns the documents of the data of a kinesis record, de-aggregating it if
+// necessary. Data that can't be decoded has no documents.
+func sampleDocuments(decoder *recordDecoder, data []byte) []json.RawMessage {
+	var subRecords, err = deaggregate(data)
+	if err != nil {
+		return nil
+	} else if subRecords == nil {
+		subRecords = []subRecord{{data: data}}
+	}
+	var result []json.RawMessage
+	for _, sub := range subRecords {
+		if docs, err := decoder.decode(sub.data); err == nil {
+			result = append(result, docs...)
+		}
+	}
+	return result
+}
+
+// schemaInference accumulates the types of the values at a location within sampled documents, and
+// those of any properties and items they have.
+type schemaInference struct {
+	types      map[string]bool
+	properties map[string]*schemaInference
+	counts     map[string]int // The number of object values that have each property.
+	items      *schemaInference
+}
+
+func newSchemaInference() *schemaInference {
+	return &schemaInference{types: make(map[string]bool)}
+}
+
+// add accumulates a value that was decoded with json.Decoder.UseNumber.
+func (s *schemaInference) add(value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		s.types["null"] = true
+	case bool:
+		s.types["boolean"] = true
+	case string:
+		s.types["string"] = true
+	case json.Number:
+		if strings.ContainsAny(v.String(), ".eE") {
+			s.types["number"] = true
+		} else {
+			s.types["integer"] = true
+		}
+	case []interface{}:
+		s.types["array"] = true
+		if s.items == nil {
+			s.items = newSchemaInference()
+		}
+		for _, item := range v {
+			s.items.add(item)
+		}
+	case map[string]interface{}:
+		s.types["object"] = true
+		if s.properties == nil {
+			s.properties = make(map[string]*schemaInference)
+			s.counts = make(map[string]int)
+		}
+		for name, property := range v {
+			if s.properties[name] == nil {
+				s.properties[name] = newSchemaInference()
+			}
+			s.properties[name].add(property)
+			s.counts[name]++
+		}
+	}
+}
+
+// schema returns the inferred JSON schema. It's deliberately loose, since it describes a sample of
+// a stream which later documents must also validate against: integers are only described as numbers,
+// and properties aren't required even if every sampled object had them.
+func (s *schemaInference) schema() map[string]interface{} {
+	var types []string
+	for t := range s.types {
+		// Integers are also numbers, and a later document may well have a fractional value.
+		if t == "integer" && s.types["number"] {
+			continue
+		} else if t == "integer" {
+			t = "number"
+		}
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	var schema = make(map[string]interface{})
+	if len(types) == 1 {
+		schema["type"] = types[0]
+	} else if len(types) > 1 {
+		schema["type"] = types
+	}
+	if len(s.properties) > 0 {
+		var properties = make(map[string]interface{}, len(s.properties))
+		for name, property := range s.properties {
+			properties[name] = property.schema()
+		}
+		schema["properties"] = properties
+	}
+	if s.items != nil && len(s.items.types) > 0 {
+		schema["items"] = s.items.schema()
+	}
+	return schema
+}
+
+// inferSchema returns a JSON schema of the sampled documents, or nil if there are none. It also
+// returns a candidate key for them, if there's a top-level property which looks like an ID and has
+// a unique string or integer value in every document, which is then the only required property.
+func inferSchema(docs []json.RawMessage) (map[string]interface{}, [][]string) {
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	var inference = newSchemaInference()
+	var values = make(map[string]map[string]bool)
+	var allObjects = true
+	for _, doc := range docs {
+		var dec = json.NewDecoder(bytes.NewReader(doc))
+		dec.UseNumber()
+		var value interface{}
+		if err := dec.Decode(&value); err != nil && err != io.EOF {
+			continue
+		}
+		inference.add(value)
+
+		var fields, ok = value.(map[string]interface{})
+		if !ok {
+			allObjects = false
+			continue
+		}
+		for name, field := range fields {
+			switch field.(type) {
+			case string, json.Number:
+				if values[name] == nil {
+					values[name] = make(map[string]bool)
+				}
+				values[name][fmt.Sprintf("%T:%v", field, field)] = true
+			}
+		}
+	}
+	var schema = inference.schema()
+	if !allObjects {
+		return schema, nil
+	}
+
+	var candidates []string
+	for name, property := range inference.properties {
+		if !looksLikeID(name) || inference.counts[name] != len(docs) || len(values[name]) != len(docs) {
+			continue
+		} else if len(property.types) != 1 || !(property.types["string"] || property.types["integer"]) {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+	if len(candidates) == 0 {
+		return schema, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		var iExact, jExact = strings.EqualFold(candidates[i], "id"), strings.EqualFold(candidates[j], "id")
+		if iExact != jExact {
+			return iExact
+		}
+		return candidates[i] < candidates[j]
+	})
+	// Only the key is required, as every document must have it.
+	schema["required"] = []string{candidates[0]}
+	return schema, [][]string{{candidates[0]}}
+}
+
+// looksLikeID returns whether a property name suggests that its values are identifiers, like `id`,
+// `event_id`, or `eventId`.
+func looksLikeID(name string) bool {
+	var lower = strings.ToLower(name)
+	return lower == "id" || strings.HasSuffix(lower, "_id") || strings.HasSuffix(lower, "-id") || strings.HasSuffix(name, "Id") || strings.HasSuffix(name, "ID")
+}
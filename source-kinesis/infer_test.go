@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rawDocs(docs ...string) []json.RawMessage {
+	var result []json.RawMessage
+	for _, doc := range docs {
+		result = append(result, json.RawMessage(doc))
+	}
+	return result
+}
+
+func TestInferSchema(t *testing.T) {
+	var schema, key = inferSchema(rawDocs(
+		`{"id": 1, "eventId": "a", "kind": "x", "amount": 1, "tags": ["a"], "nested": {"a": true, "b": null}}`,
+		`{"id": 2, "eventId": "b", "kind": "x", "amount": 1.5, "tags": [], "nested": {"a": false}}`,
+		`{"id": 3, "eventId": "c", "kind": "y", "amount": 2, "extra": "z", "nested": {"a": true}}`,
+	))
+	require.Equal(t, [][]string{{"id"}}, key)
+
+	var actual, err = json.Marshal(schema)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"type": "object",
+		"required": ["id"],
+		"properties": {
+			"id": {"type": "number"},
+			"eventId": {"type": "string"},
+			"kind": {"type": "string"},
+			"amount": {"type": "number"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"extra": {"type": "string"},
+			"nested": {
+				"type": "object",
+				"properties": {"a": {"type": "boolean"}, "b": {"type": "null"}}
+			}
+		}
+	}`, string(actual))
+
+	// Properties which aren't unique, aren't always present, or don't look like IDs aren't keys.
+	_, key = inferSchema(rawDocs(`{"id": 1, "user_id": "a"}`, `{"id": 1, "user_id": "b"}`))
+	require.Equal(t, [][]string{{"user_id"}}, key)
+	_, key = inferSchema(rawDocs(`{"id": 1, "name": "a"}`, `{"name": "b"}`))
+	require.Nil(t, key)
+	_, key = inferSchema(rawDocs(`{"id": 1}`, `{"id": "1"}`))
+	require.Nil(t, key)
+	_, key = inferSchema(rawDocs(`{"id": 1}`, `[1]`))
+	require.Nil(t, key)
+
+	schema, key = inferSchema(nil)
+	require.Nil(t, schema)
+	require.Nil(t, key)
+}
+
+func TestInferredSchemaValidatesUnsampled(t *testing.T) {
+	var schema, key = inferSchema(rawDocs(
+		`{"id": 1, "count": 2, "kind": "x", "nested": {"a": 1}}`,
+		`{"id": 2, "count": 3, "kind": "y", "nested": {"a": 2}}`,
+	))
+	require.Equal(t, [][]string{{"id"}}, key)
+
+	// A later document may have fractional numbers, and lack properties which every sampled
+	// document happened to have, other than the key.
+	require.NoError(t, validate(schema, `{"id": 3.5, "count": 0.25, "nested": {}}`))
+	require.NoError(t, validate(schema, `{"id": 4}`))
+	require.Error(t, validate(schema, `{"count": 1}`))
+	require.Error(t, validate(schema, `{"id": 5, "kind": 1}`))
+}
+
+// validate checks a document against the subset of JSON schema which inferSchema produces.
+func validate(schema map[string]interface{}, doc string) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(doc), &value); err != nil {
+		return err
+	}
+	var roundTripped map[string]interface{}
+	if bs, err := json.Marshal(schema); err != nil {
+		return err
+	} else if err := json.Unmarshal(bs, &roundTripped); err != nil {
+		return err
+	}
+	return validateValue(roundTripped, value, "")
+}
+
+func validateValue(schema map[string]interface{}, value interface{}, path string) error {
+	var actual string
+	switch value.(type) {
+	case nil:
+		actual = "null"
+	case bool:
+		actual = "boolean"
+	case string:
+		actual = "string"
+	case float64:
+		actual = "number"
+	case []interface{}:
+		actual = "array"
+	case map[string]interface{}:
+		actual = "object"
+	}
+	var types []interface{}
+	switch t := schema["type"].(type) {
+	case string:
+		types = []interface{}{t}
+	case []interface{}:
+		types = t
+	}
+	var typeOK = len(types) == 0
+	for _, t := range types {
+		typeOK = typeOK || t == actual
+	}
+	if !typeOK {
+		return fmt.Errorf("%s: %s isn't of type %v", path, actual, schema["type"])
+	}
+
+	if fields, ok := value.(map[string]interface{}); ok {
+		var required, _ = schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := fields[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		var properties, _ = schema["properties"].(map[string]interface{})
+		for name, field := range fields {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				if err := validateValue(property, field, path+"/"+name); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if items, ok := value.([]interface{}); ok {
+		var itemSchema, _ = schema["items"].(map[string]interface{})
+		for i, item := range items {
+			if err := validateValue(itemSchema, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestDiscoveredSchema(t *testing.T) {
+	require.JSONEq(t, `{"type":"object"}`, string(discoveredSchema(false, &defaultStreamConfig, nil)))
+
+	var inferred, _ = inferSchema(rawDocs(`{"id": 1}`))
+	require.JSONEq(t, `{
+		"type": "object",
+		"required": ["id"],
+		"properties": {"id": {"type": "number"}}
+	}`, string(discoveredSchema(false, &defaultStreamConfig, inferred)))
+
+	// Documents of records which couldn't be decoded don't have any of the inferred properties.
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal(discoveredSchema(true, &StreamConfig{ErrorPolicy: errorPolicyCapture}, inferred), &schema))
+	require.Equal(t, []interface{}{metaProperty}, schema["required"])
+	require.Len(t, schema["properties"], 4)
+}
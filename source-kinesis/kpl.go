@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+)
+
+// kplMagic begins the data of each kinesis record that was aggregated by the Kinesis Producer
+// Library. It's followed by a protobuf encoded AggregatedRecord and then the MD5 sum of that
+// protobuf message. See:
+// https://github.com/awslabs/amazon-kinesis-producer/blob/master/aggregation-format.md
+var kplMagic = []byte{0xf3, 0x89, 0x9a, 0xc2}
+
+// subRecord is a user record that was aggregated into a kinesis record by the KPL.
+type subRecord struct {
+	partitionKey string
+	// The explicit hash key of the sub-record as a decimal string, or empty if it has none.
+	explicitHashKey string
+	data            []byte
+}
+
+// keyHash returns the hash of the sub-record in the uint32 key space, which is its explicit hash
+// key if it has one, and otherwise the hash of its partition key.
+func (s *subRecord) keyHash() (uint32, error) {
+	if s.explicitHashKey != "" {
+		return parseHashKey(s.explicitHashKey)
+	}
+	return hashPartitionKey(s.partitionKey), nil
+}
+
+// deaggregate returns the sub-records of a KPL aggregated record, in order of their sub-sequence
+// numbers. It returns nil if the data isn't that of an aggregated record. Like the Kinesis Client
+// Library, data that begins with the magic bytes but doesn't end with a matching checksum is
+// considered not to be aggregated.
+func deaggregate(data []byte) ([]subRecord, error) {
+	if len(data) < len(kplMagic)+md5.Size || !bytes.HasPrefix(data, kplMagic) {
+		return nil, nil
+	}
+	var message = data[len(kplMagic) : len(data)-md5.Size]
+	if sum := md5.Sum(message); !bytes.Equal(sum[:], data[len(data)-md5.Size:]) {
+		return nil, nil
+	}
+	var subRecords, err = parseAggregatedRecord(message)
+	if err != nil {
+		return nil, fmt.Errorf("parsing KPL aggregated record: %w", err)
+	} else if len(subRecords) == 0 {
+		return nil, fmt.Errorf("parsing KPL aggregated record: no records")
+	}
+	return subRecords, nil
+}
+
+// parseAggregatedRecord parses the protobuf message:
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records = 3;
+//	}
+//
+// The data of the returned sub-records aliases the given message.
+func parseAggregatedRecord(message []byte) ([]subRecord, error) {
+	var partitionKeys, explicitHashKeys []string
+	var records [][]byte
+	var err = readProtoFields(message, func(field uint64, value []byte) error {
+		switch field {
+		case 1:
+			partitionKeys = append(partitionKeys, string(value))
+		case 2:
+			explicitHashKeys = append(explicitHashKeys, string(value))
+		case 3:
+			records = append(records, value)
+		}
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var subRecords = make([]subRecord, len(records))
+	for i, record := range records {
+		if subRecords[i], err = parseSubRecord(record, partitionKeys, explicitHashKeys); err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+	}
+	return subRecords, nil
+}
+
+// parseSubRecord parses the protobuf message:
+//
+//	message Record {
+//	  required uint64 partition_key_index = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes data = 3;
+//	  repeated Tag tags = 4;
+//	}
+//
+// The keys of the record are looked up in the tables of its AggregatedRecord.
+func parseSubRecord(message []byte, partitionKeys, explicitHashKeys []string) (subRecord, error) {
+	var rec subRecord
+	var hasPartitionKey, hasData bool
+	var err = readProtoFields(message, func(field uint64, value []byte) error {
+		if field == 3 {
+			rec.data, hasData = value, true
+		}
+		return nil
+	}, func(field uint64, value uint64) error {
+		switch field {
+		case 1:
+			if value >= uint64(len(partitionKeys)) {
+				return fmt.Errorf("partition key index %d is out of range", value)
+			}
+			rec.partitionKey, hasPartitionKey = partitionKeys[value], true
+		case 2:
+			if value >= uint64(len(explicitHashKeys)) {
+				return fmt.Errorf("explicit hash key index %d is out of range", value)
+			}
+			rec.explicitHashKey = explicitHashKeys[value]
+		}
+		return nil
+	})
+	if err != nil {
+		return rec, err
+	} else if !hasPartitionKey {
+		return rec, fmt.Errorf("missing partition key index")
+	} else if !hasData {
+		return rec, fmt.Errorf("missing data")
+	}
+	return rec, nil
+}
+
+// The protobuf wire types, which are encoded in the low bits of each field tag.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// readProtoFields calls onBytes with each length-delimited field of the protobuf message, and
+// onVarint with each varint field. Either may be nil if fields of that type aren't wanted, and
+// fixed-width fields are skipped.
+func readProtoFields(message []byte, onBytes func(field uint64, value []byte) error, onVarint func(field uint64, value uint64) error) error {
+	for len(message) > 0 {
+		var tag, n = binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("invalid field tag")
+		}
+		message = message[n:]
+		var field = tag >> 3
+
+		switch tag & 7 {
+		case wireVarint:
+			var value, n = binary.Uvarint(message)
+			if n <= 0 {
+				return fmt.Errorf("field %d: invalid varint", field)
+			}
+			message = message[n:]
+			if onVarint != nil {
+				if err := onVarint(field, value); err != nil {
+					return err
+				}
+			}
+		case wireBytes:
+			var length, n = binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return fmt.Errorf("field %d: invalid length", field)
+			}
+			var value = message[n : n+int(length)]
+			message = message[n+int(length):]
+			if onBytes != nil {
+				if err := onBytes(field, value); err != nil {
+					return err
+				}
+			}
+		case wireFixed64:
+			if len(message) < 8 {
+				return fmt.Errorf("field %d: truncated fixed64", field)
+			}
+			message = message[8:]
+		case wireFixed32:
+			if len(message) < 4 {
+				return fmt.Errorf("field %d: truncated fixed32", field)
+			}
+			message = message[4:]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", field, tag&7)
+		}
+	}
+	return nil
+}
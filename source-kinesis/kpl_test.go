@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/estuary/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// protoField appends a protobuf field with the given tag, which is either a varint or
+// length-delimited depending on the type of the value.
+func protoField(buf []byte, field uint64, value interface{}) []byte {
+	var putVarint = func(buf []byte, x uint64) []byte {
+		var tmp [binary.MaxVarintLen64]byte
+		return append(buf, tmp[:binary.PutUvarint(tmp[:], x)]...)
+	}
+	switch v := value.(type) {
+	case uint64:
+		return putVarint(putVarint(buf, field<<3|wireVarint), v)
+	case string:
+		return append(putVarint(putVarint(buf, field<<3|wireBytes), uint64(len(v))), v...)
+	case []byte:
+		return append(putVarint(putVarint(buf, field<<3|wireBytes), uint64(len(v))), v...)
+	default:
+		panic("unsupported field type")
+	}
+}
+
+// aggregate encodes the given sub-records into the data of a KPL aggregated record.
+func aggregate(subRecords ...subRecord) []byte {
+	var message []byte
+	var partitionKeys, hashKeys = make(map[string]uint64), make(map[string]uint64)
+	var records [][]byte
+	for _, sub := range subRecords {
+		if _, ok := partitionKeys[sub.partitionKey]; !ok {
+			partitionKeys[sub.partitionKey] = uint64(len(partitionKeys))
+			message = protoField(message, 1, sub.partitionKey)
+		}
+		var record = protoField(nil, 1, partitionKeys[sub.partitionKey])
+		if sub.explicitHashKey != "" {
+			if _, ok := hashKeys[sub.explicitHashKey]; !ok {
+				hashKeys[sub.explicitHashKey] = uint64(len(hashKeys))
+				message = protoField(message, 2, sub.explicitHashKey)
+			}
+			record = protoField(record, 2, hashKeys[sub.explicitHashKey])
+		}
+		records = append(records, protoField(record, 3, sub.data))
+	}
+	for _, record := range records {
+		message = protoField(message, 3, record)
+	}
+	var sum = md5.Sum(message)
+	return append(append(append([]byte(nil), kplMagic...), message...), sum[:]...)
+}
+
+func TestDeaggregate(t *testing.T) {
+	var expected = []subRecord{
+		{partitionKey: "a", data: []byte(`{"n": 0}`)},
+		{partitionKey: "b", explicitHashKey: "170141183460469231731687303715884105728", data: []byte(`{"n": 1}`)},
+		{partitionKey: "a", data: []byte(`{"n": 2}`)},
+	}
+	var data = aggregate(expected...)
+	var actual, err = deaggregate(data)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	var keyHash uint32
+	keyHash, err = actual[1].keyHash()
+	require.NoError(t, err)
+	require.Equal(t, uint32(0x80000000), keyHash)
+	keyHash, err = actual[0].keyHash()
+	require.NoError(t, err)
+	require.Equal(t, hashPartitionKey("a"), keyHash)
+
+	// Records that aren't aggregated, or whose checksums don't match, aren't de-aggregated.
+	actual, err = deaggregate([]byte(`{"n": 0}`))
+	require.NoError(t, err)
+	require.Nil(t, actual)
+	data[len(data)-1] ^= 0xff
+	actual, err = deaggregate(data)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+
+	// Aggregated records with a valid checksum must be well formed.
+	var message = protoField(protoField(nil, 1, "a"), 3, protoField(protoField(nil, 1, uint64(1)), 3, "x"))
+	var sum = md5.Sum(message)
+	_, err = deaggregate(append(append(append([]byte(nil), kplMagic...), message...), sum[:]...))
+	require.Error(t, err)
+}
+
+func TestExtractAggregatedRecords(t *testing.T) {
+	var config = &defaultStreamConfig
+	var reader = &shardReader{
+		rangeOverlap:      airbyte.PartialRangeOverlap,
+		kinesisShardRange: airbyte.NewFullRange(),
+		parent: &streamReader{
+			stream:     "events",
+			opts:       &readOptions{includeMetadata: true},
+			config:     config,
+			decoder:    newRecordDecoder(config),
+			shardRange: airbyte.Range{Begin: 0, End: 0x7fffffff},
+		},
+		source:   &recordSource{stream: "events", shardID: "shardId-000000000001"},
+		logEntry: log.WithField("stream", "events"),
+	}
+
+	// Find partition keys that hash into and out of the capture shard's range.
+	var ownedKey, otherKey string
+	for i := 0; ownedKey == "" || otherKey == ""; i++ {
+		var key = string(rune('a' + i))
+		if hashPartitionKey(key) <= 0x7fffffff {
+			ownedKey = key
+		} else {
+			otherKey = key
+		}
+	}
+
+	var resp = &kinesis.GetRecordsOutput{Records: []*kinesis.Record{
+		{
+			PartitionKey:   aws.String(otherKey),
+			SequenceNumber: aws.String("1"),
+			Data: aggregate(
+				subRecord{partitionKey: otherKey, data: []byte(`{"n": 0}`)},
+				subRecord{partitionKey: ownedKey, data: []byte(`{"n": 1}`)},
+				subRecord{partitionKey: otherKey, explicitHashKey: "0", data: []byte(`{"n": 2} {"n": 3}`)},
+			),
+		},
+		{PartitionKey: aws.String(ownedKey), SequenceNumber: aws.String("2"), Data: []byte(`{"n": 4}`)},
+		{PartitionKey: aws.String(otherKey), SequenceNumber: aws.String("3"), Data: []byte(`{"n": 5}`)},
+	}}
+	var docs, err = reader.extractRecords(resp)
+	require.NoError(t, err)
+
+	type meta struct {
+		PartitionKey      string `json:"partitionKey"`
+		SequenceNumber    string `json:"sequenceNumber"`
+		SubSequenceNumber int    `json:"subSequenceNumber"`
+		Index             int    `json:"index"`
+	}
+	var actual []meta
+	for _, doc := range docs {
+		var parsed struct {
+			Meta meta `json:"_meta"`
+		}
+		require.NoError(t, json.Unmarshal(doc, &parsed))
+		actual = append(actual, parsed.Meta)
+	}
+	require.Equal(t, []meta{
+		{PartitionKey: ownedKey, SequenceNumber: "1", SubSequenceNumber: 1, Index: 0},
+		{PartitionKey: otherKey, SequenceNumber: "1", SubSequenceNumber: 2, Index: 0},
+		{PartitionKey: otherKey, SequenceNumber: "1", SubSequenceNumber: 2, Index: 1},
+		{PartitionKey: ownedKey, SequenceNumber: "2", SubSequenceNumber: 0, Index: 0},
+	}, actual)
+}
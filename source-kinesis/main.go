@@ -59,8 +59,8 @@ func doDiscover(args airbyte.DiscoverCmd) error {
 	return encoder.Encode(catalog)
 }
 
-func discoverCatalog(config airbyte.ConfigFile) (*airbyte.Catalog, error) {
-	var _, client, err = parseConfigAndConnect(config)
+func discoverCatalog(configFile airbyte.ConfigFile) (*airbyte.Catalog, error) {
+	var config, client, err = parseConfigAndConnect(configFile)
 	if err != nil {
 		return nil, err
 	}
@@ -70,15 +70,23 @@ func discoverCatalog(config airbyte.ConfigFile) (*airbyte.Catalog, error) {
 		return nil, err
 	}
 
+	var opts = newReadOptions(&config, time.Now().UTC())
+	var samples = sampleStreams(ctx, client, streamNames, opts)
 	var catalog = &airbyte.Catalog{
 		Streams: make([]airbyte.Stream, len(streamNames)),
 	}
 	for i, name := range streamNames {
+		var inferred, key = inferSchema(samples[i])
 		catalog.Streams[i] = airbyte.Stream{
-			Name:                name,
-			JSONSchema:          json.RawMessage(`{"type":"object"}`),
-			SupportedSyncModes:  []airbyte.SyncMode{airbyte.SyncModeIncremental},
-			SourceDefinedCursor: true,
+			Name:                    name,
+			JSONSchema:              discoveredSchema(config.IncludeMetadata, opts.streamConfig(name), inferred),
+			SupportedSyncModes:      []airbyte.SyncMode{airbyte.SyncModeIncremental},
+			SourceDefinedCursor:     true,
+			SourceDefinedPrimaryKey: key,
+		}
+		// With metadata, each document is uniquely identified by the record it came from.
+		if config.IncludeMetadata {
+			catalog.Streams[i].SourceDefinedPrimaryKey = [][]string{{metaProperty, "shardId"}, {metaProperty, "sequenceNumber"}, {metaProperty, "subSequenceNumber"}, {metaProperty, "index"}}
 		}
 	}
 	return catalog, nil
@@ -109,10 +117,11 @@ func doRead(args airbyte.ReadCmd) error {
 }
 
 func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output io.Writer) error {
-	var _, client, err = parseConfigAndConnect(args.ConfigFile)
+	var config, client, err = parseConfigAndConnect(args.ConfigFile)
 	if err != nil {
 		return err
 	}
+	var opts = newReadOptions(&config, time.Now().UTC())
 	var catalog airbyte.ConfiguredCatalog
 	if err = args.CatalogFile.Parse(&catalog); err != nil {
 		return fmt.Errorf("parsing configured catalog: %w", err)
@@ -152,7 +161,7 @@ func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output io.Writer)
 			return fmt.Errorf("invalid state for stream %s: %w", stream.Stream.Name, err)
 		}
 		waitGroup.Add(1)
-		go readStream(ctx, shardRange, client, stream.Stream.Name, streamState, dataCh, stopAt, waitGroup)
+		go readStream(ctx, shardRange, client, stream.Stream.Name, opts, streamState, dataCh, stopAt, waitGroup)
 	}
 
 	go closeChannelWhenDone(dataCh, waitGroup)
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// metaProperty is the name of the document property which holds the recordMeta of each record,
+// when metadata is included.
+const metaProperty = "_meta"
+
+// recordMeta identifies the kinesis record that a document came from. The combination of shard ID,
+// sequence number, sub-sequence number, and index is unique within a stream, so it can be used to
+// deduplicate documents which are captured more than once.
+type recordMeta struct {
+	Stream          string `json:"stream"`
+	ShardID         string `json:"shardId"`
+	PartitionKey    string `json:"partitionKey"`
+	ExplicitHashKey string `json:"explicitHashKey,omitempty"`
+	SequenceNumber  string `json:"sequenceNumber"`
+	// The position of the user record within a KPL aggregated record, or zero if the record wasn't
+	// aggregated.
+	SubSequenceNumber           int        `json:"subSequenceNumber"`
+	Index                       int        `json:"index"`
+	ApproximateArrivalTimestamp *time.Time `json:"approximateArrivalTimestamp,omitempty"`
+}
+
+// metaSchema is the JSON schema of the `_meta` property.
+const metaSchema = `{
+	"type": "object",
+	"description": "Identifies the kinesis record that this document came from",
+	"properties": {
+		"stream": {"type": "string", "description": "The name of the kinesis stream"},
+		"shardId": {"type": "string", "description": "The ID of the kinesis shard"},
+		"partitionKey": {"type": "string", "description": "The partition key of the record"},
+		"explicitHashKey": {"type": "string", "description": "The explicit hash key of the record, if it was aggregated by the Kinesis Producer Library and has one"},
+		"sequenceNumber": {"type": "string", "description": "The sequence number of the record, which is unique within its shard"},
+		"subSequenceNumber": {"type": "integer", "description": "The position of the record within a record aggregated by the Kinesis Producer Library, or 0 if it wasn't aggregated"},
+		"index": {"type": "integer", "description": "The position of this document among those decoded from the record"},
+		"approximateArrivalTimestamp": {"type": "string", "format": "date-time", "description": "When the record was added to the stream"}
+	},
+	"required": ["stream", "shardId", "partitionKey", "sequenceNumber", "subSequenceNumber", "index"]
+}`
+
+// withMetadata returns the document with a `_meta` property added to it, replacing any existing
+// property of that name. The document must be a JSON object.
+func withMetadata(doc []byte, meta *recordMeta) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("record is not a JSON object, so metadata can't be added to it")
+	}
+	var metaJSON, err = json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("encoding record metadata: %w", err)
+	}
+	fields[metaProperty] = metaJSON
+	return json.Marshal(fields)
+}
+
+// discoveredSchema returns the JSON schema of the documents of a stream, given the schema that was
+// inferred from a sample of them, if any.
+func discoveredSchema(includeMetadata bool, config *StreamConfig, inferred map[string]interface{}) json.RawMessage {
+	var schema = map[string]interface{}{"type": "object"}
+	for k, v := range inferred {
+		schema[k] = v
+	}
+	var properties, _ = schema["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	var required, _ = schema["required"].([]string)
+
+	if config.ErrorPolicy == errorPolicyCapture {
+		// Error documents have none of the properties of the documents that could be decoded.
+		required = nil
+		properties[errorProperty] = json.RawMessage(`{"type":"string","description":"Why the data of the record couldn't be decoded, for documents which stand in for such records"}`)
+		properties[rawProperty] = json.RawMessage(`{"type":"string","contentEncoding":"base64","description":"The raw data of a record which couldn't be decoded"}`)
+	}
+	if includeMetadata {
+		required = append(required, metaProperty)
+		properties[metaProperty] = json.RawMessage(metaSchema)
+	}
+
+	if len(properties) > 0 {
+		schema["properties"] = properties
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	} else {
+		delete(schema, "required")
+	}
+	var bs, err = json.Marshal(schema)
+	if err != nil {
+		panic(fmt.Sprintf("encoding discovered schema: %v", err))
+	}
+	return bs
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMetadata(t *testing.T) {
+	var arrival = time.Date(2021, 11, 29, 12, 0, 0, 0, time.UTC)
+	var meta = &recordMeta{
+		Stream:                      "events",
+		ShardID:                     "shardId-000000000001",
+		PartitionKey:                "key",
+		SequenceNumber:              "49590338271490256608559692538361571095921575989136588898",
+		SubSequenceNumber:           3,
+		Index:                       2,
+		ApproximateArrivalTimestamp: &arrival,
+	}
+
+	var doc, err = withMetadata([]byte(`{"a": 1, "_meta": "replaced"}`), meta)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"a": 1, "_meta": {
+		"stream": "events",
+		"shardId": "shardId-000000000001",
+		"partitionKey": "key",
+		"sequenceNumber": "49590338271490256608559692538361571095921575989136588898",
+		"subSequenceNumber": 3,
+		"index": 2,
+		"approximateArrivalTimestamp": "2021-11-29T12:00:00Z"
+	}}`, string(doc))
+
+	for _, invalid := range []string{`[1, 2]`, `"str"`, `null`, `{"a":`} {
+		_, err = withMetadata([]byte(invalid), meta)
+		require.Error(t, err, "expected an error adding metadata to %s", invalid)
+	}
+	require.True(t, json.Valid(discoveredSchema(true, &defaultStreamConfig, nil)))
+	require.True(t, json.Valid(discoveredSchema(true, &StreamConfig{ErrorPolicy: errorPolicyCapture}, nil)))
+	require.True(t, json.Valid(discoveredSchema(false, &StreamConfig{ErrorPolicy: errorPolicyCapture}, nil)))
+}
+
+func TestShardIteratorInput(t *testing.T) {
+	var started = time.Date(2021, 11, 29, 12, 0, 0, 0, time.UTC)
+	var newReader = func(config Config, lastSequenceID string) *shardReader {
+		config.Region, config.AWSAccessKeyID, config.AWSSecretAccessKey = "local", "x", "x"
+		require.NoError(t, config.Validate())
+		return &shardReader{
+			parent:         &streamReader{stream: "events", opts: newReadOptions(&config, started)},
+			source:         &recordSource{stream: "events", shardID: "shardId-000000000001"},
+			lastSequenceID: lastSequenceID,
+		}
+	}
+
+	var input = newReader(Config{}, "").shardIteratorInput()
+	require.Equal(t, kinesis.ShardIteratorTypeTrimHorizon, aws.StringValue(input.ShardIteratorType))
+
+	input = newReader(Config{StartPosition: "LATEST"}, "").shardIteratorInput()
+	require.Equal(t, kinesis.ShardIteratorTypeAtTimestamp, aws.StringValue(input.ShardIteratorType))
+	require.Equal(t, started, aws.TimeValue(input.Timestamp))
+
+	input = newReader(Config{StartPosition: "AT_TIMESTAMP", StartTimestamp: "2021-01-02T03:04:05Z"}, "").shardIteratorInput()
+	require.Equal(t, kinesis.ShardIteratorTypeAtTimestamp, aws.StringValue(input.ShardIteratorType))
+	require.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), aws.TimeValue(input.Timestamp))
+
+	// Previous state always takes precedence over the configured start position.
+	input = newReader(Config{StartPosition: "LATEST"}, "1234").shardIteratorInput()
+	require.Equal(t, kinesis.ShardIteratorTypeAfterSequenceNumber, aws.StringValue(input.ShardIteratorType))
+	require.Equal(t, "1234", aws.StringValue(input.StartingSequenceNumber))
+	require.Nil(t, input.Timestamp)
+
+	var config = Config{Region: "local", StartPosition: "AT_TIMESTAMP"}
+	require.Error(t, config.Validate(), "expected an error for AT_TIMESTAMP without a timestamp")
+	config.StartPosition = "EARLIEST"
+	require.Error(t, config.Validate(), "expected an error for an invalid start position")
+}
@@ -21,21 +21,26 @@ func hashPartitionKey(key string) uint32 {
 // comparison with the Flow shard range.
 func parseKinesisShardRange(begin, end string) (airbyte.Range, error) {
 	var r = airbyte.Range{}
-	var begin128, ok = new(big.Int).SetString(begin, 10)
-	if !ok {
-		return r, fmt.Errorf("failed to parse kinesis shard range begin: '%s'", begin)
+	var err error
+	if r.Begin, err = parseHashKey(begin); err != nil {
+		return r, fmt.Errorf("failed to parse kinesis shard range begin: %w", err)
 	}
-	r.Begin = uint32(begin128.Rsh(begin128, 96).Uint64())
-
-	end128, ok := new(big.Int).SetString(end, 10)
-	if !ok {
-		return r, fmt.Errorf("failed to parse kinesis shard range end: '%s'", end)
+	if r.End, err = parseHashKey(end); err != nil {
+		return r, fmt.Errorf("failed to parse kinesis shard range end: %w", err)
 	}
-	r.End = uint32(end128.Rsh(end128, 96).Uint64())
-
 	return r, nil
 }
 
+// Parses a 128 bit kinesis hash key, given as a decimal string, and translates it into the uint32
+// hashed key space by truncating the lower 96 bits.
+func parseHashKey(key string) (uint32, error) {
+	var key128, ok = new(big.Int).SetString(key, 10)
+	if !ok || key128.Sign() < 0 || key128.BitLen() > 128 {
+		return 0, fmt.Errorf("invalid hash key: '%s'", key)
+	}
+	return uint32(key128.Rsh(key128, 96).Uint64()), nil
+}
+
 // Determines whether a record with the given `partitionKeyHash` should be processed by a Flow shard
 // with the given `flowRange`. The `partitionKeyHash` is expected to have been computed using
 // `hashPartitionKey`. Under normal circumstances, the `partitionKeyHash` will be within the
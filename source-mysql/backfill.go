@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/sirupsen/logrus"
+)
+
+// backfillChunkSize controls how many rows will be read from the database in a
+// single query. In normal use it acts like a constant, it's just a variable here
+// so that it can be lowered in tests to exercise chunking behavior more easily.
+var backfillChunkSize = 4096
+
+// ScanTableChunk fetches a chunk of rows from the specified table, resuming after the
+// provided `resumeKey` if non-nil, and restricted to rows matching `filter` if non-empty.
+func (db *mysqlDatabase) ScanTableChunk(ctx context.Context, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*sqlcapture.ChangeEvent, error) {
+	logrus.WithFields(logrus.Fields{
+		"streamID":   streamID,
+		"keyColumns": keyColumns,
+		"resumeKey":  resumeKey,
+		"filter":     filter,
+	}).Debug("scanning table chunk")
+
+	// Split "flow.foo" tableID into "flow" database and "foo" table name
+	var parts = strings.SplitN(streamID, ".", 2)
+	var schemaName, tableName = parts[0], parts[1]
+
+	// The columns of the table are queried at the start of each backfill, and
+	// are used to translate the values of the resulting rows.
+	var columns, ok = db.columns[streamID]
+	if !ok || resumeKey == nil {
+		var err error
+		if columns, err = getColumns(db.conn, streamID); err != nil {
+			return nil, fmt.Errorf("error querying columns of table %q: %w", streamID, err)
+		}
+		db.columns[streamID] = columns
+	}
+
+	// Build and execute a query to fetch the next `backfillChunkSize` rows from the database
+	var query = buildScanQuery(resumeKey == nil, keyColumns, schemaName, tableName, filter)
+	logrus.WithFields(logrus.Fields{"query": query, "args": resumeKey}).Debug("executing query")
+	var results, err = db.conn.Execute(query, resumeKey...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query %q: %w", query, err)
+	}
+	defer results.Close()
+
+	// Process the results into `ChangeEvent` structs and return them
+	var columnsByName = make(map[string]*columnInfo)
+	for idx := range columns {
+		columnsByName[columns[idx].Name] = &columns[idx]
+	}
+	var events []*sqlcapture.ChangeEvent
+	for _, row := range results.Values {
+		var fields = make(map[string]interface{})
+		for idx, field := range results.Fields {
+			var name = string(field.Name)
+			var col, ok = columnsByName[name]
+			if !ok {
+				return nil, fmt.Errorf("table %q: unknown column %q", streamID, name)
+			}
+			var val, err = translateValue(col, row[idx].Value())
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = val
+		}
+		events = append(events, &sqlcapture.ChangeEvent{
+			Type:      "Insert",
+			Namespace: schemaName,
+			Table:     tableName,
+			Fields:    fields,
+		})
+	}
+	return events, nil
+}
+
+func buildScanQuery(start bool, keyColumns []string, schemaName, tableName, filter string) string {
+	// Construct strings like `(foo, bar, baz)` and `(?, ?, ?)` for use in the query
+	var pkey, args string
+	for idx, colName := range keyColumns {
+		if idx > 0 {
+			pkey += ", "
+			args += ", "
+		}
+		pkey += colName
+		args += "?"
+	}
+
+	// Construct the query itself
+	var query = new(strings.Builder)
+	fmt.Fprintf(query, "SELECT * FROM %s.%s", schemaName, tableName)
+	var conditions []string
+	if filter != "" {
+		conditions = append(conditions, "("+filter+")")
+	}
+	if !start {
+		conditions = append(conditions, fmt.Sprintf("(%s) > (%s)", pkey, args))
+	}
+	if len(conditions) > 0 {
+		fmt.Fprintf(query, " WHERE %s", strings.Join(conditions, " AND "))
+	}
+	fmt.Fprintf(query, " ORDER BY %s", pkey)
+	fmt.Fprintf(query, " LIMIT %d;", backfillChunkSize)
+	return query.String()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/sirupsen/logrus"
+)
+
+// mysqlDatabase implements sqlcapture.Database for MySQL.
+type mysqlDatabase struct {
+	config *Config      // The configuration read from `config.json`
+	conn   *client.Conn // The DB connection used for table scanning and watermark writes
+
+	columns          map[string][]columnInfo // The columns of each table, as of the most recent scan
+	watermarksExists bool                    // True once the watermarks table has been created
+}
+
+// RunCapture is the top level of the database capture process. It is responsible for opening
+// DB connections, after which the generic capture process takes over.
+func RunCapture(ctx context.Context, config *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState, dest sqlcapture.MessageOutput) error {
+	logrus.WithFields(logrus.Fields{
+		"address":  config.Address,
+		"serverID": config.ServerID,
+	}).Info("starting capture")
+
+	var conn, err = connectMySQL(config)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database for table scan: %w", err)
+	}
+	defer conn.Close()
+
+	var db = &mysqlDatabase{
+		config:  config,
+		conn:    conn,
+		columns: make(map[string][]columnInfo),
+	}
+	return sqlcapture.RunCapture(ctx, db, &sqlcapture.Options{
+		BackfillMethod:      sqlcapture.BackfillMethodWatermarks,
+		SchemaChangePolicy:  config.SchemaChangePolicy,
+		SchemaChangesStream: config.SchemaChangesStream,
+	}, catalog, state, dest)
+}
+
+// connectMySQL opens a new connection to the database. The session time zone is
+// set to UTC so that TIMESTAMP values are read in the same way as from the binlog.
+func connectMySQL(config *Config) (*client.Conn, error) {
+	logrus.WithField("address", config.Address).Debug("connecting to database")
+	var conn, err = client.Connect(config.Address, config.User, config.Password, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	if _, err := conn.Execute("SET time_zone = '+00:00';"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error setting session time zone: %w", err)
+	}
+	return conn, nil
+}
+
+// WatermarksTable returns the stream ID of the watermarks table.
+func (db *mysqlDatabase) WatermarksTable() string {
+	return db.config.WatermarksTable
+}
+
+// WriteWatermark writes the provided string into the 'watermarks' table.
+func (db *mysqlDatabase) WriteWatermark(ctx context.Context, watermark string) error {
+	// Table creation is a DDL statement which appears in the binlog even if the
+	// table already exists, so it's only performed once.
+	if !db.watermarksExists {
+		var query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot INTEGER PRIMARY KEY, watermark TEXT);", db.config.WatermarksTable)
+		if _, err := db.conn.Execute(query); err != nil {
+			return fmt.Errorf("error creating watermarks table: %w", err)
+		}
+		db.watermarksExists = true
+	}
+
+	var query = fmt.Sprintf("REPLACE INTO %s (slot, watermark) VALUES (?, ?);", db.config.WatermarksTable)
+	if _, err := db.conn.Execute(query, db.config.ServerID, watermark); err != nil {
+		return fmt.Errorf("error upserting new watermark for server ID %d: %w", db.config.ServerID, err)
+	}
+	return nil
+}
+
+// DiscoverPrimaryKeys returns the primary key columns of each table.
+func (db *mysqlDatabase) DiscoverPrimaryKeys(ctx context.Context) (map[string][]string, error) {
+	var keys, err = getPrimaryKeys(db.conn)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ColumnJSONSchema returns the JSON schema of a non-null value of the named
+// MySQL type, or nil if the type is unknown.
+func (db *mysqlDatabase) ColumnJSONSchema(typeName string) json.RawMessage {
+	if jsonType, ok := mysqlTypeToJSON[typeName]; ok {
+		return json.RawMessage(jsonType)
+	}
+	return nil
+}
+
+// TranslateRecordField returns the provided value unmodified, since values are
+// translated into their JSON-encodable representation as they're read.
+func (db *mysqlDatabase) TranslateRecordField(val interface{}) (interface{}, error) {
+	return val, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+)
+
+// TestSimpleCapture initializes a DB table with a few rows, then runs a capture
+// which should emit all rows during table-scanning, start replication, and then
+// shut down due to a lack of further events.
+func TestSimpleCapture(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	// Add data, perform capture, verify result
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestReplicationInserts runs two captures, where the first will perform the
+// initial table scan and the second capture will use replication to receive
+// additional inserts performed after the first capture.
+func TestReplicationInserts(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, tableName, [][]interface{}{{1002, "some"}, {1000, "more"}, {1001, "rows"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestReplicationDeletes runs two captures, where the first will perform the
+// initial table scan and the second capture will use replication to receive
+// additional inserts and deletions performed after the first capture. Deletions
+// include the full previous contents of the row.
+func TestReplicationDeletes(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, tableName, [][]interface{}{{1002, "some"}, {1000, "more"}, {1001, "rows"}})
+	dbQuery(ctx, t, fmt.Sprintf("DELETE FROM %s WHERE id = 1 OR id = 1002;", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestReplicationUpdates runs two captures, where the first will perform the
+// initial table scan and the second capture will use replication to receive
+// additional inserts and row updates performed after the first capture.
+func TestReplicationUpdates(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, tableName, [][]interface{}{{1002, "some"}, {1000, "more"}, {1001, "rows"}})
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'updated' WHERE id = 1 OR id = 1002;", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// TestTranslateValue checks that column values are translated consistently,
+// using examples of the representations obtained from both query results and
+// the binlog.
+func TestTranslateValue(t *testing.T) {
+	for idx, tc := range []struct {
+		DataType   string
+		ColumnType string
+		Input      interface{}
+		Expect     interface{}
+	}{
+		{"int", "int", int32(-12), int64(-12)},
+		{"int", "int unsigned", int32(-1), int64(4294967295)},
+		{"tinyint", "tinyint unsigned", int8(-56), int64(200)},
+		{"bigint", "bigint unsigned", int64(-1), uint64(18446744073709551615)},
+		{"bigint", "bigint unsigned", uint64(18446744073709551615), uint64(18446744073709551615)},
+		{"year", "year", int(2021), int64(2021)},
+		{"float", "float", float32(1.5), float64(1.5)},
+		{"bit", "bit(12)", []byte{0x01, 0x02}, int64(258)},
+		{"enum", "enum('a','b''c','D')", int64(2), "b'c"},
+		{"enum", "enum('a','b''c','D')", int64(3), "D"},
+		{"enum", "enum('a','b''c','D')", []byte("D"), "D"},
+		{"set", "set('x','y','z')", int64(5), "x,z"},
+		{"set", "set('x','y','z')", []byte("x,z"), "x,z"},
+		{"decimal", "decimal(10,2)", []byte("12.50"), "12.50"},
+		{"datetime", "datetime", "2021-11-30 12:34:56", "2021-11-30T12:34:56Z"},
+		{"timestamp", "timestamp(3)", []byte("2021-11-30 12:34:56.789"), "2021-11-30T12:34:56.789Z"},
+		{"varchar", "varchar(32)", []byte("hello"), "hello"},
+		{"varbinary", "varbinary(32)", "hello", []byte("hello")},
+		{"json", "json", []byte(`{"a":1}`), json.RawMessage(`{"a":1}`)},
+		{"text", "text", nil, nil},
+	} {
+		var col = &columnInfo{Name: "col", DataType: tc.DataType, ColumnType: tc.ColumnType}
+		var result, err = translateValue(col, tc.Input)
+		if err != nil {
+			t.Errorf("case %d: error translating %#v: %v", idx, tc.Input, err)
+			continue
+		}
+		if fmt.Sprintf("%#v", result) != fmt.Sprintf("%#v", tc.Expect) {
+			t.Errorf("case %d: translating %#v: got %#v, expected %#v", idx, tc.Input, result, tc.Expect)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// translateValue converts a column value, as obtained from either a backfill query
+// or the binlog, into a consistent representation. The two sources represent many
+// types differently (for instance enums are strings in query results but indices
+// in the binlog, and unsigned integers are read from the binlog as signed), and it's
+// important for correctness that row keys from both sources compare correctly.
+func translateValue(col *columnInfo, val interface{}) (interface{}, error) {
+	if val == nil {
+		return nil, nil
+	}
+	switch col.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "bigint", "year":
+		return translateInteger(col, val)
+	case "bit":
+		if bs, ok := val.([]byte); ok {
+			var padded = make([]byte, 8)
+			copy(padded[8-len(bs):], bs)
+			return int64(binary.BigEndian.Uint64(padded)), nil
+		}
+		return val, nil
+	case "float", "double":
+		if x, ok := val.(float32); ok {
+			return float64(x), nil
+		}
+		return val, nil
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		switch x := val.(type) {
+		case string:
+			return []byte(x), nil
+		case []byte:
+			return append([]byte(nil), x...), nil
+		}
+		return val, nil
+	case "enum":
+		if idx, ok := val.(int64); ok {
+			var options = col.enumValues()
+			if idx == 0 {
+				return "", nil // The "error value" of an invalid enum
+			} else if idx < 0 || int(idx) > len(options) {
+				return nil, fmt.Errorf("enum column %q: index %d out of range", col.Name, idx)
+			}
+			return options[idx-1], nil
+		}
+	case "set":
+		if bits, ok := val.(int64); ok {
+			var options = col.enumValues()
+			var members []string
+			for idx, option := range options {
+				if bits&(1<<idx) != 0 {
+					members = append(members, option)
+				}
+			}
+			return strings.Join(members, ","), nil
+		}
+	case "decimal":
+		// The binlog decodes decimals into a numeric type, while query results
+		// are strings. Both are represented as strings to preserve precision.
+		return stringValue(val), nil
+	case "datetime", "timestamp":
+		// Timestamps are read in UTC, while datetimes have no time zone at all
+		// and are likewise treated as UTC.
+		var str = stringValue(val)
+		if len(str) >= len("2006-01-02 15:04:05") && str[10] == ' ' {
+			str = str[:10] + "T" + str[11:] + "Z"
+		}
+		return str, nil
+	case "json":
+		var str = stringValue(val)
+		if str == "" {
+			return nil, nil
+		}
+		return json.RawMessage(str), nil
+	}
+	if bs, ok := val.([]byte); ok {
+		return string(bs), nil
+	}
+	return val, nil
+}
+
+// translateInteger converts an integer value into an int64, or a uint64 in the
+// case of unsigned BIGINT values which don't fit in an int64. The binlog encodes
+// all integers as signed values of the column's width, so negative values of an
+// unsigned column are converted back.
+func translateInteger(col *columnInfo, val interface{}) (interface{}, error) {
+	var x int64
+	switch v := val.(type) {
+	case int8:
+		x = int64(v)
+	case int16:
+		x = int64(v)
+	case int32:
+		x = int64(v)
+	case int64:
+		x = v
+	case int:
+		x = int64(v)
+	case uint64:
+		if v > 1<<63-1 {
+			return v, nil
+		}
+		x = int64(v)
+	default:
+		return nil, fmt.Errorf("integer column %q: unexpected value %#v", col.Name, val)
+	}
+	if x < 0 && col.isUnsigned() {
+		switch col.DataType {
+		case "tinyint":
+			x += 1 << 8
+		case "smallint":
+			x += 1 << 16
+		case "mediumint":
+			x += 1 << 24
+		case "int":
+			x += 1 << 32
+		case "bigint":
+			return uint64(x), nil
+		}
+	}
+	return x, nil
+}
+
+func stringValue(val interface{}) string {
+	switch x := val.(type) {
+	case []byte:
+		return string(x)
+	case string:
+		return x
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// isUnsigned returns true if the column is of an unsigned integer type.
+func (col *columnInfo) isUnsigned() bool {
+	return strings.Contains(strings.ToLower(col.ColumnType), "unsigned")
+}
+
+// enumValues parses the list of options from the type of an ENUM or SET
+// column, such as "enum('a','b”c')".
+func (col *columnInfo) enumValues() []string {
+	var start, end = strings.Index(col.ColumnType, "("), strings.LastIndex(col.ColumnType, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+	var options []string
+	var current = new(strings.Builder)
+	var quoted = false
+	var body = col.ColumnType[start+1 : end]
+	for idx := 0; idx < len(body); idx++ {
+		var ch = body[idx]
+		switch {
+		case ch == '\'' && quoted && idx+1 < len(body) && body[idx+1] == '\'':
+			current.WriteByte('\'') // An escaped quote
+			idx++
+		case ch == '\'' && quoted:
+			options = append(options, current.String())
+			current.Reset()
+			quoted = false
+		case ch == '\'':
+			quoted = true
+		case quoted:
+			current.WriteByte(ch)
+		}
+	}
+	return options
+}
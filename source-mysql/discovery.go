@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/sirupsen/logrus"
+)
+
+// metadataSchema is the JSON schema of the `_meta` property of every record,
+// which is populated by the capture.
+const metadataSchema = `{"type":"object","description":"Metadata describing the source and nature of this change",` +
+	`"properties":{` +
+	`"op":{"type":"string","enum":["Backfill","Insert","Update","Delete"],"description":"The operation which produced this record"},` +
+	`"schema":{"type":"string","description":"The database containing the source table"},` +
+	`"table":{"type":"string","description":"The name of the source table"},` +
+	`"cursor":{"type":"string","description":"The binlog position of the change, for replicated changes"},` +
+	`"ts":{"type":"string","format":"date-time","description":"The time at which the change was made, for replicated changes"},` +
+	`"before":{"type":"object","description":"The previous values of the row, for updates"}` +
+	`},"required":["op","schema","table"]}`
+
+// DiscoverCatalog queries the database and generates an Airbyte Catalog
+// describing the available tables and their columns.
+func DiscoverCatalog(ctx context.Context, config Config) (*airbyte.Catalog, error) {
+	var conn, err = connectMySQL(&config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tables, err := getDatabaseTables(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog = new(airbyte.Catalog)
+	for _, table := range tables {
+		logrus.WithFields(logrus.Fields{
+			"table":      table.Name,
+			"namespace":  table.Schema,
+			"primaryKey": table.PrimaryKey,
+		}).Debug("discovered table")
+
+		// Unlike PostgreSQL there's no row locator column we can use to capture
+		// tables without a primary key, so such tables are omitted.
+		if len(table.PrimaryKey) == 0 {
+			logrus.WithField("table", table.Schema+"."+table.Name).Warn("omitting table without a primary key")
+			continue
+		}
+
+		var fields = make(map[string]json.RawMessage)
+		for _, column := range table.Columns {
+			var jsonType, ok = mysqlTypeToJSON[column.DataType]
+			if !ok {
+				return nil, fmt.Errorf("cannot translate MySQL column type %q to JSON schema", column.DataType)
+			}
+			if column.IsNullable && jsonType != "{}" {
+				jsonType = fmt.Sprintf(`{"anyOf":[%s,{"type":"null"}]}`, jsonType)
+			}
+			fields[column.Name] = json.RawMessage(jsonType)
+		}
+		fields[sqlcapture.MetadataProperty] = json.RawMessage(metadataSchema)
+
+		var schema, err = json.Marshal(map[string]interface{}{
+			"type":       "object",
+			"required":   table.PrimaryKey,
+			"properties": fields,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling schema JSON: %w", err)
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"table":     table.Name,
+			"namespace": table.Schema,
+			"columns":   table.Columns,
+			"schema":    string(schema),
+		}).Debug("translated table schema")
+
+		var sourceDefinedPrimaryKey [][]string
+		for _, colName := range table.PrimaryKey {
+			sourceDefinedPrimaryKey = append(sourceDefinedPrimaryKey, []string{colName})
+		}
+
+		catalog.Streams = append(catalog.Streams, airbyte.Stream{
+			Name:                    table.Name,
+			Namespace:               table.Schema,
+			JSONSchema:              json.RawMessage(schema),
+			SupportedSyncModes:      airbyte.AllSyncModes,
+			SourceDefinedCursor:     true,
+			SourceDefinedPrimaryKey: sourceDefinedPrimaryKey,
+		})
+	}
+	if config.SchemaChangesStream != "" {
+		catalog.Streams = append(catalog.Streams, sqlcapture.SchemaChangesStream(config.SchemaChangesStream))
+	}
+	return catalog, err
+}
+
+var mysqlTypeToJSON = map[string]string{
+	"tinyint":   `{"type":"integer"}`,
+	"smallint":  `{"type":"integer"}`,
+	"mediumint": `{"type":"integer"}`,
+	"int":       `{"type":"integer"}`,
+	"bigint":    `{"type":"integer"}`,
+	"year":      `{"type":"integer"}`,
+	"bit":       `{"type":"integer"}`,
+
+	"float":   `{"type":"number"}`,
+	"double":  `{"type":"number"}`,
+	"decimal": `{"type":"string"}`, // Represented as a string to preserve precision
+
+	"char":       `{"type":"string"}`,
+	"varchar":    `{"type":"string"}`,
+	"tinytext":   `{"type":"string"}`,
+	"text":       `{"type":"string"}`,
+	"mediumtext": `{"type":"string"}`,
+	"longtext":   `{"type":"string"}`,
+	"enum":       `{"type":"string"}`,
+	"set":        `{"type":"string"}`,
+
+	"binary":     `{"type":"string","contentEncoding":"base64"}`,
+	"varbinary":  `{"type":"string","contentEncoding":"base64"}`,
+	"tinyblob":   `{"type":"string","contentEncoding":"base64"}`,
+	"blob":       `{"type":"string","contentEncoding":"base64"}`,
+	"mediumblob": `{"type":"string","contentEncoding":"base64"}`,
+	"longblob":   `{"type":"string","contentEncoding":"base64"}`,
+
+	"date":      `{"type":"string","format":"date"}`,
+	"datetime":  `{"type":"string","format":"date-time"}`,
+	"timestamp": `{"type":"string","format":"date-time"}`,
+	"time":      `{"type":"string"}`,
+
+	"json": `{}`,
+}
+
+// tableInfo represents all relevant knowledge about a MySQL table.
+type tableInfo struct {
+	Name       string       // The MySQL table name.
+	Schema     string       // The MySQL database which contains the table.
+	Columns    []columnInfo // Information about each column of the table.
+	PrimaryKey []string     // An ordered list of the column names which together form the table's primary key.
+}
+
+// columnInfo represents a specific column of a specific table in MySQL,
+// along with some information about its type.
+type columnInfo struct {
+	Name        string // The name of the column.
+	Index       int    // The ordinal position of this column in a row.
+	TableName   string // The name of the table to which this column belongs.
+	TableSchema string // The database of the table to which this column belongs.
+	IsNullable  bool   // True if the column can contain nulls.
+	DataType    string // The MySQL type name of this column, such as "int".
+	ColumnType  string // The full MySQL type of this column, such as "int(10) unsigned".
+}
+
+// getDatabaseTables queries the database to produce a list of all tables
+// (with the exception of some internal system databases) with information
+// about their column types and primary key.
+func getDatabaseTables(conn *client.Conn) ([]tableInfo, error) {
+	// Get lists of all columns and primary keys in the database
+	var columns, err = getColumns(conn, "")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list database columns: %w", err)
+	}
+	primaryKeys, err := getPrimaryKeys(conn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list database primary keys: %w", err)
+	}
+
+	// Aggregate column and primary key information into TableInfo structs
+	// using a map from fully-qualified "<schema>.<name>" table names to
+	// the corresponding TableInfo.
+	var tableMap = make(map[string]*tableInfo)
+	for _, column := range columns {
+		var id = column.TableSchema + "." + column.TableName
+		if _, ok := tableMap[id]; !ok {
+			tableMap[id] = &tableInfo{Schema: column.TableSchema, Name: column.TableName}
+		}
+		tableMap[id].Columns = append(tableMap[id].Columns, column)
+	}
+	for id, key := range primaryKeys {
+		// The `getColumns()` query implements the "exclude system schemas" logic,
+		// so here we ignore primary key information for tables we don't care about.
+		if _, ok := tableMap[id]; !ok {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"table": id, "key": key}).Debug("queried primary key")
+		tableMap[id].PrimaryKey = key
+	}
+
+	// Now that aggregation is complete, discard map keys and return
+	// just the list of TableInfo structs, sorted for stability.
+	var tables []tableInfo
+	for _, info := range tableMap {
+		tables = append(tables, *info)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Schema+"."+tables[i].Name < tables[j].Schema+"."+tables[j].Name
+	})
+	return tables, nil
+}
+
+const queryDiscoverColumns = `
+  SELECT table_schema, table_name, ordinal_position, column_name, is_nullable, data_type, column_type
+  FROM information_schema.columns
+  WHERE table_schema NOT IN ('information_schema', 'mysql', 'performance_schema', 'sys')
+  %s
+  ORDER BY table_schema, table_name, ordinal_position;`
+
+// getColumns queries the database for information about the columns of all
+// tables, or of a single table when streamID is non-empty.
+func getColumns(conn *client.Conn, streamID string) ([]columnInfo, error) {
+	var query = fmt.Sprintf(queryDiscoverColumns, "")
+	var args []interface{}
+	if streamID != "" {
+		var parts = strings.SplitN(streamID, ".", 2)
+		query = fmt.Sprintf(queryDiscoverColumns, "AND table_schema = ? AND table_name = ?")
+		args = []interface{}{parts[0], parts[1]}
+	}
+	var results, err = conn.Execute(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query %q: %w", query, err)
+	}
+	defer results.Close()
+
+	var columns []columnInfo
+	for _, row := range results.Values {
+		columns = append(columns, columnInfo{
+			TableSchema: string(row[0].AsString()),
+			TableName:   string(row[1].AsString()),
+			Index:       int(row[2].AsInt64()),
+			Name:        string(row[3].AsString()),
+			IsNullable:  string(row[4].AsString()) == "YES",
+			DataType:    strings.ToLower(string(row[5].AsString())),
+			ColumnType:  string(row[6].AsString()),
+		})
+	}
+	return columns, nil
+}
+
+const queryDiscoverPrimaryKeys = `
+  SELECT table_schema, table_name, column_name, seq_in_index
+  FROM information_schema.statistics
+  WHERE index_name = 'PRIMARY'
+    AND table_schema NOT IN ('information_schema', 'mysql', 'performance_schema', 'sys')
+  ORDER BY table_schema, table_name, seq_in_index;`
+
+// getPrimaryKeys queries the database to produce a map from table names to
+// primary keys. Table names are fully qualified as "<schema>.<name>", and
+// primary keys are represented as a list of column names, in the order that
+// they form the table's primary key.
+func getPrimaryKeys(conn *client.Conn) (map[string][]string, error) {
+	var results, err = conn.Execute(queryDiscoverPrimaryKeys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query %q: %w", queryDiscoverPrimaryKeys, err)
+	}
+	defer results.Close()
+
+	var keys = make(map[string][]string)
+	for _, row := range results.Values {
+		var id = string(row[0].AsString()) + "." + string(row[1].AsString())
+		var columnName, columnIndex = string(row[2].AsString()), int(row[3].AsInt64())
+		keys[id] = append(keys[id], columnName)
+		if columnIndex != len(keys[id]) {
+			return nil, fmt.Errorf("primary key column %q appears out of order (expected index %d, in context %q)", columnName, columnIndex, keys[id])
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	TestAddress = flag.String("test_address",
+		"127.0.0.1:3306",
+		"Connect to the specified address in tests")
+	TestUser = flag.String("test_user",
+		"root",
+		"Connect as the specified user in tests")
+	TestPassword = flag.String("test_password",
+		"flow",
+		"Connect with the specified password in tests")
+	TestDatabaseName = flag.String("test_database",
+		"flow",
+		"Create test tables in the specified database")
+	TestServerID = flag.Int("test_server_id",
+		12345,
+		"Replicate from the database using the specified server ID in tests")
+)
+
+var (
+	TestDefaultConfig Config
+	TestDatabase      *client.Conn
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	if testing.Verbose() {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	// Tweak some parameters to make things easier to test on a smaller scale
+	backfillChunkSize = 16
+	replicationBufferSize = 0
+
+	// Initialize test config and database connection
+	TestDefaultConfig.Address = *TestAddress
+	TestDefaultConfig.User = *TestUser
+	TestDefaultConfig.Password = *TestPassword
+	TestDefaultConfig.ServerID = *TestServerID
+	TestDefaultConfig.WatermarksTable = *TestDatabaseName + ".watermarks"
+	if err := TestDefaultConfig.Validate(); err != nil {
+		logrus.WithFields(logrus.Fields{"err": err, "config": TestDefaultConfig}).Fatal("error validating test config")
+	}
+
+	var conn, err = client.Connect(*TestAddress, *TestUser, *TestPassword, *TestDatabaseName)
+	if err != nil {
+		logrus.WithField("err", err).Fatal("error connecting to database")
+	}
+	defer conn.Close()
+	TestDatabase = conn
+
+	os.Exit(m.Run())
+}
+
+// createTestTable is a test helper for creating a new database table and returning the
+// name of the new table. The table is named "test_<testName>", or "test_<testName>_<suffix>"
+// if the suffix is non-empty. Table names are case-sensitive in MySQL, so the name is
+// lowercased for consistency with the stream IDs of the capture.
+func createTestTable(ctx context.Context, t *testing.T, suffix string, tableDef string) string {
+	t.Helper()
+
+	var tableName = "test_" + strings.TrimPrefix(t.Name(), "Test")
+	if suffix != "" {
+		tableName += "_" + suffix
+	}
+	tableName = strings.ReplaceAll(tableName, "/", "_")
+	tableName = strings.ReplaceAll(tableName, "=", "_")
+	tableName = strings.ToLower(tableName)
+
+	logrus.WithFields(logrus.Fields{"table": tableName, "cols": tableDef}).Debug("creating test table")
+	dbQueryInternal(ctx, t, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, tableName))
+	dbQueryInternal(ctx, t, fmt.Sprintf(`CREATE TABLE %s%s;`, tableName, tableDef))
+	t.Cleanup(func() {
+		logrus.WithField("table", tableName).Debug("destroying test table")
+		dbQueryInternal(ctx, t, fmt.Sprintf(`DROP TABLE %s;`, tableName))
+	})
+	return tableName
+}
+
+// shortTestContext is a test helper which creates a time-bounded context for running test logic
+func shortTestContext(t *testing.T) context.Context {
+	return longTestContext(t, 10*time.Second)
+}
+
+// longTestContext is a test helper which creates a time-bounded context for running test logic
+func longTestContext(t *testing.T, timeout time.Duration) context.Context {
+	var ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// testCatalog is a test helper for constructing a ConfiguredCatalog from stream names
+func testCatalog(streams ...string) airbyte.ConfiguredCatalog {
+	var catalog = airbyte.ConfiguredCatalog{}
+	for _, s := range streams {
+		catalog.Streams = append(catalog.Streams, airbyte.ConfiguredStream{
+			Stream: airbyte.Stream{Name: s, Namespace: *TestDatabaseName},
+		})
+	}
+	return catalog
+}
+
+// dbInsert is a test helper for inserting multiple rows into TestDatabase
+// as a single transaction.
+func dbInsert(ctx context.Context, t *testing.T, table string, rows [][]interface{}) {
+	t.Helper()
+
+	if len(rows) < 1 {
+		t.Fatalf("must insert at least one row")
+	}
+	if err := TestDatabase.Begin(); err != nil {
+		t.Fatalf("unable to begin transaction: %v", err)
+	}
+	logrus.WithFields(logrus.Fields{"table": table, "count": len(rows), "first": rows[0]}).Debug("inserting data")
+	var query = fmt.Sprintf(`INSERT INTO %s VALUES %s`, table, argsTuple(len(rows[0])))
+	for _, row := range rows {
+		logrus.WithFields(logrus.Fields{"table": table, "row": row}).Trace("inserting row")
+		if len(row) != len(rows[0]) {
+			t.Fatalf("incorrect number of values in row %q (expected %d)", row, len(rows[0]))
+		}
+		var results, err = TestDatabase.Execute(query, row...)
+		if err != nil {
+			t.Fatalf("unable to execute query: %v", err)
+		}
+		results.Close()
+	}
+	if err := TestDatabase.Commit(); err != nil {
+		t.Fatalf("unable to commit insert transaction: %v", err)
+	}
+}
+
+func argsTuple(argc int) string {
+	var tuple = "(?"
+	for idx := 1; idx < argc; idx++ {
+		tuple += ",?"
+	}
+	return tuple + ")"
+}
+
+// dbQuery is a test helper for executing arbitrary queries against TestDatabase
+func dbQuery(ctx context.Context, t *testing.T, query string, args ...interface{}) {
+	t.Helper()
+	logrus.WithFields(logrus.Fields{"query": query, "args": args}).Debug("executing query")
+	dbQueryInternal(ctx, t, query, args...)
+}
+
+func dbQueryInternal(ctx context.Context, t *testing.T, query string, args ...interface{}) {
+	var results, err = TestDatabase.Execute(query, args...)
+	if err != nil {
+		t.Fatalf("unable to execute query: %v", err)
+	}
+	defer results.Close()
+	for _, row := range results.Values {
+		var vals []interface{}
+		for _, val := range row {
+			vals = append(vals, val.Value())
+		}
+		logrus.WithField("values", vals).Debug("query result row")
+	}
+}
+
+// verifiedCapture is a test helper which performs a database capture and automatically
+// verifies the result against a golden snapshot. It returns a list of all states
+// emitted during the capture, and updates the `state` argument to the final one.
+func verifiedCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState, suffix string) []sqlcapture.PersistentState {
+	t.Helper()
+	var result, states = performCapture(ctx, t, cfg, catalog, state)
+	verifySnapshot(t, suffix, result)
+	return states
+}
+
+// performCapture runs a new capture instance with the specified configuration, catalog,
+// and state. The resulting messages are stored into a buffer, and returned as a string
+// holding all emitted records, plus a list of all state updates. The records string is
+// sanitized of "nondeterministic" data like timestamps and binlog positions which will
+// vary across test runs, and so can be fed directly into verifySnapshot.
+//
+// As a side effect the input state is modified to the final result state.
+func performCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState) (string, []sqlcapture.PersistentState) {
+	t.Helper()
+
+	// Use a JSON round-trip to deep-copy the state, so that the act of running a
+	// capture can't modify the passed-in state argument, and thus we can treat
+	// the sequence of states as having value semantics within tests.
+	var bs, err = json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cleanState = new(sqlcapture.PersistentState)
+	if err := json.Unmarshal(bs, cleanState); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf = new(CaptureOutputBuffer)
+	if err := RunCapture(ctx, cfg, catalog, cleanState, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var result, states = buf.Output()
+	if len(states) > 0 {
+		*state = states[len(states)-1]
+	}
+	return result, states
+}
+
+// sanitizedCursor replaces binlog positions in test output, since they vary
+// across test runs.
+const sanitizedCursor = "binlog.000123:456"
+
+// A CaptureOutputBuffer receives the stream of output messages from a
+// Capture instance, recording State updates in one list and Records
+// in another.
+type CaptureOutputBuffer struct {
+	States    []sqlcapture.PersistentState
+	Snapshot  strings.Builder
+	lastState string
+}
+
+func (buf *CaptureOutputBuffer) Encode(v interface{}) error {
+	var msg, ok = v.(airbyte.Message)
+	if !ok {
+		return fmt.Errorf("output message is not an airbyte.Message: %#v", v)
+	}
+
+	// Accumulate State updates in one list
+	if msg.Type == airbyte.MessageTypeState {
+		return buf.bufferState(msg)
+	}
+	if msg.Type == airbyte.MessageTypeRecord {
+		return buf.bufferRecord(msg)
+	}
+	if msg.Type == airbyte.MessageTypeLog {
+		return nil // Ignore log messages when validating test output
+	}
+	return fmt.Errorf("unhandled message type: %#v", msg.Type)
+}
+
+func (buf *CaptureOutputBuffer) bufferState(msg airbyte.Message) error {
+	// Parse state data and store a copy for later resume testing.
+	var originalState sqlcapture.PersistentState
+	if err := json.Unmarshal(msg.State.Data, &originalState); err != nil {
+		return fmt.Errorf("error unmarshaling to PersistentState: %w", err)
+	}
+
+	// Sanitize state by rewriting the binlog position to a constant, then
+	// encode back into new bytes.
+	var cleanState = sqlcapture.PersistentState{Cursor: sanitizedCursor, Streams: originalState.Streams}
+	var bs, err = json.Marshal(cleanState)
+	if err != nil {
+		return fmt.Errorf("error encoding cleaned state: %w", err)
+	}
+
+	// Suppress identical (after sanitizing) successive state updates. This
+	// improves test stability in the presence of unexpected 'Commit' events
+	// (typically on other tables not subject to the current test).
+	if string(bs) == buf.lastState {
+		return nil
+	}
+	buf.lastState = string(bs)
+
+	// Buffer the original state for later resuming, and append the sanitized
+	// state to the output buffer.
+	buf.States = append(buf.States, originalState)
+	return buf.bufferMessage(airbyte.Message{
+		Type:  airbyte.MessageTypeState,
+		State: &airbyte.State{Data: json.RawMessage(bs)},
+	})
+}
+
+func (buf *CaptureOutputBuffer) bufferRecord(msg airbyte.Message) error {
+	buf.lastState = ""
+
+	// Sanitize the replication metadata of the record, which varies across test runs.
+	// The record is decoded with `UseNumber` so that other values are unaffected.
+	var fields map[string]interface{}
+	var dec = json.NewDecoder(bytes.NewReader(msg.Record.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return fmt.Errorf("error decoding record data: %w", err)
+	}
+	if meta, ok := fields[sqlcapture.MetadataProperty].(map[string]interface{}); ok {
+		if _, ok := meta["cursor"]; ok {
+			meta["cursor"] = sanitizedCursor
+		}
+		if _, ok := meta["ts"]; ok {
+			meta["ts"] = time.Unix(1234, 0).UTC()
+		}
+	}
+	var data, err = json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("error encoding sanitized record data: %w", err)
+	}
+
+	return buf.bufferMessage(airbyte.Message{
+		Type: airbyte.MessageTypeRecord,
+		Record: &airbyte.Record{
+			Namespace: msg.Record.Namespace,
+			Stream:    msg.Record.Stream,
+			EmittedAt: 1234, // Replaced because non-reproducible
+			Data:      json.RawMessage(data),
+		},
+	})
+}
+
+func (buf *CaptureOutputBuffer) bufferMessage(msg airbyte.Message) error {
+	var bs, err = json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error encoding sanitized message: %w", err)
+	}
+	buf.Snapshot.Write(bs)
+	buf.Snapshot.WriteByte('\n')
+	return nil
+}
+
+func (buf *CaptureOutputBuffer) Output() (string, []sqlcapture.PersistentState) {
+	return buf.Snapshot.String(), buf.States
+}
+
+// verifySnapshot loads snapshot content from a file and compares with the
+// actual result of a test. The snapshot filename is derived automatically
+// from the current test name, with an optional suffix in case a single
+// test needs multiple snapshots. In the event of a mismatch, a ".new"
+// file is written for ease of comparison/updating.
+func verifySnapshot(t *testing.T, suffix string, actual string) {
+	t.Helper()
+
+	var snapshotDir = "testdata"
+	var snapshotFile = snapshotDir + "/" + t.Name()
+	if suffix != "" {
+		snapshotFile += "_" + suffix
+	}
+	snapshotFile += ".snapshot"
+
+	var snapBytes, err = os.ReadFile(snapshotFile)
+	// Nonexistent snapshots aren't an error, because when adding a
+	// new test we'd like it to produce a "snapshot.new" file for us
+	// and the empty string won't match the expected result anyway.
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("error reading snapshot %q: %v", snapshotFile, err)
+	}
+
+	if actual == string(snapBytes) {
+		return
+	}
+
+	var newSnapshotFile = snapshotFile + ".new"
+	if err := os.WriteFile(newSnapshotFile, []byte(actual), 0644); err != nil {
+		t.Errorf("error writing new snapshot file %q: %v", newSnapshotFile, err)
+	}
+
+	// Locate the first non-matching line and log it
+	var actualLines = strings.Split(actual, "\n")
+	var snapshotLines = strings.Split(string(snapBytes), "\n")
+	for idx := 0; idx < len(snapshotLines) || idx < len(actualLines); idx++ {
+		var x, y string
+		if idx < len(snapshotLines) {
+			x = snapshotLines[idx]
+		}
+		if idx < len(actualLines) {
+			y = actualLines[idx]
+		}
+		if x != y {
+			t.Errorf("snapshot %q mismatch at line %d", snapshotFile, idx)
+			t.Errorf("old: %s", x)
+			t.Errorf("new: %s", y)
+			return
+		}
+	}
+	t.Errorf("snapshot %q mismatch at undetermined line", snapshotFile)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+)
+
+func main() {
+	airbyte.RunMain(spec, doCheck, doDiscover, doRead)
+}
+
+// Config tells the connector how to connect to the source database and can
+// optionally be used to customize some other parameters such as the server ID.
+type Config struct {
+	Address             string `json:"address"`
+	User                string `json:"user"`
+	Password            string `json:"password"`
+	ServerID            int    `json:"server_id"`
+	WatermarksTable     string `json:"watermarks_table"`
+	SchemaChangePolicy  string `json:"schema_change_policy"`
+	SchemaChangesStream string `json:"schema_changes_stream"`
+}
+
+// Validate checks that the configuration passes some basic sanity checks, and
+// fills in default values when optional parameters are unset.
+func (c *Config) Validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("Database Address must be set")
+	}
+	if c.User == "" {
+		return fmt.Errorf("Database User must be set")
+	}
+	if c.ServerID == 0 {
+		return fmt.Errorf("Server ID must be set")
+	}
+	if c.WatermarksTable == "" {
+		c.WatermarksTable = "flow.watermarks"
+	}
+	switch c.SchemaChangePolicy {
+	case "":
+		c.SchemaChangePolicy = sqlcapture.SchemaChangeLog
+	case sqlcapture.SchemaChangeLog, sqlcapture.SchemaChangeEvent, sqlcapture.SchemaChangeFail, sqlcapture.SchemaChangeBackfill:
+	default:
+		return fmt.Errorf("invalid schema change policy %q", c.SchemaChangePolicy)
+	}
+	if c.SchemaChangePolicy != sqlcapture.SchemaChangeEvent && c.SchemaChangesStream != "" {
+		return fmt.Errorf("schema changes stream can only be set with the %q schema change policy", sqlcapture.SchemaChangeEvent)
+	} else if c.SchemaChangePolicy == sqlcapture.SchemaChangeEvent && c.SchemaChangesStream == "" {
+		c.SchemaChangesStream = "flow.schema_changes"
+	} else if c.SchemaChangesStream != "" && !strings.Contains(c.SchemaChangesStream, ".") {
+		return fmt.Errorf("schema changes stream %q must be of the form <database>.<name>", c.SchemaChangesStream)
+	}
+	return nil
+}
+
+var spec = airbyte.Spec{
+	SupportsIncremental:     true,
+	ConnectionSpecification: json.RawMessage(configSchema),
+}
+
+const configSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"title":   "MySQL Source Spec",
+	"type":    "object",
+	"properties": {
+		"address": {
+			"type":        "string",
+			"title":       "Database Address",
+			"description": "The host:port at which the database can be reached",
+			"default":     "127.0.0.1:3306"
+		},
+		"user": {
+			"type":        "string",
+			"title":       "Database User",
+			"description": "The database user to authenticate as, which needs the REPLICATION SLAVE and REPLICATION CLIENT privileges",
+			"default":     "flow_capture"
+		},
+		"password": {
+			"type":        "string",
+			"title":       "Database Password",
+			"description": "The password for the specified database user"
+		},
+		"server_id": {
+			"type":        "integer",
+			"title":       "Server ID",
+			"description": "The server ID to use when replicating from the database, which must be unique among all replicas of the database"
+		},
+		"watermarks_table": {
+			"type":        "string",
+			"title":       "Watermarks Table",
+			"description": "The name of the table used for watermark writes during backfills. The database containing it must already exist",
+			"default":     "flow.watermarks"
+		},
+		"schema_change_policy": {
+			"type":        "string",
+			"title":       "Schema Change Policy",
+			"description": "What to do when the columns of a captured table change: 'log' a warning and continue, emit a schema change 'event' record and continue, 'fail' the capture, or 'backfill' the table again",
+			"enum":        ["log", "event", "fail", "backfill"],
+			"default":     "log"
+		},
+		"schema_changes_stream": {
+			"type":        "string",
+			"title":       "Schema Changes Stream",
+			"description": "The stream (of the form <database>.<name>) under which the records of the 'event' schema change policy are captured. Only used with that policy",
+			"default":     "flow.schema_changes"
+		}
+	},
+	"required": [ "address", "user", "password", "server_id" ]
+}`
+
+func doCheck(args airbyte.CheckCmd) error {
+	var config Config
+	if err := args.ConfigFile.Parse(&config); err != nil {
+		return err
+	}
+	var result = &airbyte.ConnectionStatus{Status: airbyte.StatusSucceeded}
+	if _, err := DiscoverCatalog(context.Background(), config); err != nil {
+		result.Status = airbyte.StatusFailed
+		result.Message = err.Error()
+	}
+	return airbyte.NewStdoutEncoder().Encode(airbyte.Message{
+		Type:             airbyte.MessageTypeConnectionStatus,
+		ConnectionStatus: result,
+	})
+}
+
+func doDiscover(args airbyte.DiscoverCmd) error {
+	var config Config
+	if err := args.ConfigFile.Parse(&config); err != nil {
+		return err
+	}
+	var catalog, err = DiscoverCatalog(context.Background(), config)
+	if err != nil {
+		return err
+	}
+	return airbyte.NewStdoutEncoder().Encode(airbyte.Message{
+		Type:    airbyte.MessageTypeCatalog,
+		Catalog: catalog,
+	})
+}
+
+func doRead(args airbyte.ReadCmd) error {
+	var ctx = context.Background()
+
+	var state = &sqlcapture.PersistentState{Streams: make(map[string]*sqlcapture.TableState)}
+	if args.StateFile != "" {
+		if err := args.StateFile.Parse(state); err != nil {
+			return fmt.Errorf("unable to parse state file: %w", err)
+		}
+	}
+
+	var config = new(Config)
+	if err := args.ConfigFile.Parse(config); err != nil {
+		return err
+	}
+
+	var catalog = new(airbyte.ConfiguredCatalog)
+	if err := args.CatalogFile.Parse(catalog); err != nil {
+		return fmt.Errorf("unable to parse catalog: %w", err)
+	}
+
+	return RunCapture(ctx, config, catalog, state, json.NewEncoder(os.Stdout))
+}
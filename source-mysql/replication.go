@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/sirupsen/logrus"
+)
+
+// A binlogStream represents the process of receiving MySQL binlog events
+// and translating row changes into a more friendly representation.
+type binlogStream struct {
+	startPos mysql.Position // The binlog position from which replication was started
+	syncer   *replication.BinlogSyncer
+	streamer *replication.BinlogStreamer
+
+	// metadata is a separate connection used to query the columns of tables,
+	// since binlog row events only describe column values by position.
+	metadata *client.Conn
+	columns  map[string][]columnInfo // The current columns of each table, loaded as needed
+
+	currentFile string // The name of the binlog file currently being read
+
+	events chan *sqlcapture.ChangeEvent // The channel to which replication events will be written
+	cancel context.CancelFunc           // Cancel function for the replication goroutine's context
+}
+
+// replicationBufferSize controls how many change events can be buffered in the
+// binlogStream before it stops receiving further events from MySQL. In normal
+// use it's a constant, it's just a variable so that tests are more likely to
+// exercise blocking sends and backpressure.
+var replicationBufferSize = 1024
+
+// StartReplication opens a binlog replication connection to the database, starting
+// from the cursor position if specified or from the current end of the binlog.
+func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor string) (sqlcapture.ReplicationStream, error) {
+	var host, portStr, err = net.SplitHostPort(db.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database address %q: %w", db.config.Address, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database port %q: %w", portStr, err)
+	}
+
+	// If we don't have a valid cursor from a previous capture, replication starts
+	// from the current end of the binlog.
+	var startPos mysql.Position
+	if startCursor != "" {
+		if startPos, err = parseCursor(startCursor); err != nil {
+			return nil, err
+		}
+	} else if startPos, err = db.currentPosition(); err != nil {
+		return nil, err
+	}
+
+	metadata, err := connectMySQL(db.config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database for metadata queries: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"startPos": startPos.String(),
+		"serverID": db.config.ServerID,
+	}).Debug("starting replication")
+
+	var syncer = replication.NewBinlogSyncer(replication.BinlogSyncerConfig{
+		ServerID:                uint32(db.config.ServerID),
+		Flavor:                  "mysql",
+		Host:                    host,
+		Port:                    uint16(port),
+		User:                    db.config.User,
+		Password:                db.config.Password,
+		TimestampStringLocation: time.UTC,
+		UseDecimal:              true,
+	})
+	streamer, err := syncer.StartSync(startPos)
+	if err != nil {
+		syncer.Close()
+		metadata.Close()
+		return nil, fmt.Errorf("unable to start replication: %w", err)
+	}
+
+	var stream = &binlogStream{
+		startPos:    startPos,
+		syncer:      syncer,
+		streamer:    streamer,
+		metadata:    metadata,
+		columns:     make(map[string][]columnInfo),
+		currentFile: startPos.Name,
+		events:      make(chan *sqlcapture.ChangeEvent, replicationBufferSize),
+	}
+
+	var streamCtx, streamCancel = context.WithCancel(ctx)
+	stream.cancel = streamCancel
+	go func() {
+		defer close(stream.events)
+		defer stream.metadata.Close()
+		defer stream.syncer.Close()
+		if err := stream.run(streamCtx); err != nil && !errors.Is(err, context.Canceled) {
+			logrus.WithField("err", err).Fatal("replication stream error")
+		}
+	}()
+	return stream, nil
+}
+
+// currentPosition queries the current end of the binlog.
+func (db *mysqlDatabase) currentPosition() (mysql.Position, error) {
+	var results, err = db.conn.Execute("SHOW MASTER STATUS;")
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("unable to query binlog position: %w", err)
+	}
+	defer results.Close()
+	if len(results.Values) == 0 {
+		return mysql.Position{}, fmt.Errorf("unable to query binlog position: binary logging is not enabled")
+	}
+	var row = results.Values[0]
+	return mysql.Position{
+		Name: string(row[0].AsString()),
+		Pos:  uint32(row[1].AsInt64()),
+	}, nil
+}
+
+// parseCursor parses a binlog position cursor of the form "<file>:<offset>".
+func parseCursor(cursor string) (mysql.Position, error) {
+	var idx = strings.LastIndex(cursor, ":")
+	if idx < 0 {
+		return mysql.Position{}, fmt.Errorf("invalid binlog cursor %q", cursor)
+	}
+	var offset, err = strconv.ParseUint(cursor[idx+1:], 10, 32)
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("invalid binlog cursor %q: %w", cursor, err)
+	}
+	return mysql.Position{Name: cursor[:idx], Pos: uint32(offset)}, nil
+}
+
+// formatCursor is the inverse of parseCursor.
+func formatCursor(pos mysql.Position) string {
+	return fmt.Sprintf("%s:%d", pos.Name, pos.Pos)
+}
+
+// StartCursor returns the binlog position from which replication was started.
+func (s *binlogStream) StartCursor() string {
+	return formatCursor(s.startPos)
+}
+
+func (s *binlogStream) Events() <-chan *sqlcapture.ChangeEvent {
+	return s.events
+}
+
+// run is the main loop of the binlogStream, which receives binlog events and
+// relays the resulting change events to the output channel.
+func (s *binlogStream) run(ctx context.Context) error {
+	for {
+		var event, err = s.streamer.GetEvent(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to receive binlog event: %w", err)
+		}
+		changes, err := s.decodeEvent(event)
+		if err != nil {
+			return fmt.Errorf("error decoding binlog event: %w", err)
+		}
+		for _, change := range changes {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case s.events <- change:
+			}
+		}
+	}
+}
+
+// decodeEvent translates a single binlog event into zero or more change events.
+func (s *binlogStream) decodeEvent(event *replication.BinlogEvent) ([]*sqlcapture.ChangeEvent, error) {
+	var pos = mysql.Position{Name: s.currentFile, Pos: event.Header.LogPos}
+	switch data := event.Event.(type) {
+	case *replication.RotateEvent:
+		// Rotate events tell us the name of the next binlog file. A "fake" rotation
+		// to the starting file is also sent at the start of replication.
+		s.currentFile = string(data.NextLogName)
+		logrus.WithField("file", s.currentFile).Debug("binlog rotated")
+		return nil, nil
+	case *replication.XIDEvent:
+		return []*sqlcapture.ChangeEvent{{Type: "Commit", Cursor: formatCursor(pos)}}, nil
+	case *replication.QueryEvent:
+		// Transactions begin with a "BEGIN" query, and otherwise query events in a
+		// row-based binlog describe DDL statements. These are committed implicitly,
+		// and may change the columns of tables so all cached column information is
+		// discarded.
+		var query = strings.TrimSpace(string(data.Query))
+		if strings.EqualFold(query, "BEGIN") {
+			return nil, nil
+		}
+		logrus.WithFields(logrus.Fields{"schema": string(data.Schema), "query": query}).Debug("query event")
+		s.columns = make(map[string][]columnInfo)
+		return []*sqlcapture.ChangeEvent{{Type: "Commit", Cursor: formatCursor(pos)}}, nil
+	case *replication.RowsEvent:
+		return s.decodeRowsEvent(event.Header, data, pos)
+	}
+	return nil, nil
+}
+
+func (s *binlogStream) decodeRowsEvent(header *replication.EventHeader, data *replication.RowsEvent, pos mysql.Position) ([]*sqlcapture.ChangeEvent, error) {
+	var schemaName, tableName = string(data.Table.Schema), string(data.Table.Table)
+	var streamID = sqlcapture.JoinStreamID(schemaName, tableName)
+
+	// Load the columns of the table if necessary, in which case a Relation event
+	// describing them precedes the changes.
+	var changes []*sqlcapture.ChangeEvent
+	var columns, ok = s.columns[streamID]
+	if !ok {
+		var err error
+		if columns, err = getColumns(s.metadata, schemaName+"."+tableName); err != nil {
+			return nil, fmt.Errorf("error querying columns of table %q: %w", streamID, err)
+		}
+		s.columns[streamID] = columns
+		var types = make(map[string]string)
+		for _, col := range columns {
+			types[col.Name] = col.DataType
+		}
+		changes = append(changes, &sqlcapture.ChangeEvent{
+			Type:      "Relation",
+			Namespace: schemaName,
+			Table:     tableName,
+			Columns:   types,
+		})
+	}
+
+	var source = map[string]interface{}{
+		"cursor": formatCursor(pos),
+		"ts":     time.Unix(int64(header.Timestamp), 0).UTC(),
+	}
+	var newChange = func(eventType string, fields, before map[string]interface{}) *sqlcapture.ChangeEvent {
+		return &sqlcapture.ChangeEvent{
+			Type:      eventType,
+			Cursor:    formatCursor(pos),
+			Source:    source,
+			Namespace: schemaName,
+			Table:     tableName,
+			Fields:    fields,
+			Before:    before,
+		}
+	}
+
+	switch header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, row := range data.Rows {
+			var fields, err = decodeRow(streamID, columns, row)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, newChange("Insert", fields, nil))
+		}
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		// Update events consist of alternating before and after images.
+		for idx := 0; idx+1 < len(data.Rows); idx += 2 {
+			var before, err = decodeRow(streamID, columns, data.Rows[idx])
+			if err != nil {
+				return nil, err
+			}
+			after, err := decodeRow(streamID, columns, data.Rows[idx+1])
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, newChange("Update", after, before))
+		}
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range data.Rows {
+			var fields, err = decodeRow(streamID, columns, row)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, newChange("Delete", fields, fields))
+		}
+	default:
+		return nil, fmt.Errorf("unhandled rows event type %s", header.EventType)
+	}
+	return changes, nil
+}
+
+// decodeRow translates the values of a binlog row image into a map of column
+// names to values. Since the binlog only describes values by position this
+// relies on the columns of the table being unchanged since the row was written,
+// which doesn't hold if DDL is executed while the capture is lagging behind.
+func decodeRow(streamID string, columns []columnInfo, row []interface{}) (map[string]interface{}, error) {
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("table %q: binlog row has %d values but table has %d columns", streamID, len(row), len(columns))
+	}
+	var fields = make(map[string]interface{})
+	for idx, val := range row {
+		var col = &columns[idx]
+		var translated, err = translateValue(col, val)
+		if err != nil {
+			return nil, fmt.Errorf("table %q: %w", streamID, err)
+		}
+		fields[col.Name] = translated
+	}
+	return fields, nil
+}
+
+func (s *binlogStream) Close(ctx context.Context) error {
+	logrus.Debug("replication stream close requested")
+	s.cancel()
+	return nil
+}
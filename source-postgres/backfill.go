@@ -2,125 +2,236 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
-	"github.com/google/uuid"
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/fdb/tuple"
+	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
 )
 
-// TODO(wgd): Define an interface with a `ScanTableChunk(ctx, streamID, keyColumns, resumeKey)` and
-// a `WriteWatermark(ctx, table, slot)` method which serves as the "database backfill" API
-// that we'll have to implement for other databases.
+// queryer is the subset of the pgx.Conn API which is needed to scan table chunks. It
+// is also satisfied by pgx.Tx, so that scans can take place within a transaction.
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// ScanTableChunk fetches a chunk of rows from the specified table, resuming after the
+// provided `resumeKey` if non-nil, and restricted to rows matching `filter` if non-empty.
+func (db *postgresDatabase) ScanTableChunk(ctx context.Context, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*sqlcapture.ChangeEvent, error) {
+	return db.scanTableChunk(ctx, db.connScan, streamID, keyColumns, resumeKey, filter)
+}
 
-// scanTableChunk fetches a chunk of rows from the specified table, resuming from the provided
-// `resumeKey` if non-nil. This is the entrypoint to the database-specific portion of the
-// backfill process.
-func scanTableChunk(ctx context.Context, conn *pgx.Conn, streamID string, keyColumns []string, resumeKey []byte) ([]*changeEvent, error) {
+// scanTableChunk implements ScanTableChunk using the provided connection or transaction.
+// The configured backfill filter of the table, if any, is combined with `filter`.
+func (db *postgresDatabase) scanTableChunk(ctx context.Context, conn queryer, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*sqlcapture.ChangeEvent, error) {
+	filter = combineFilters(db.tables.backfillFilter(streamID), filter)
+	var limit = db.chunkSizes.ChunkSize(streamID)
 	logrus.WithFields(logrus.Fields{
 		"streamID":   streamID,
 		"keyColumns": keyColumns,
-		"resumeKey":  base64.StdEncoding.EncodeToString(resumeKey),
+		"resumeKey":  resumeKey,
+		"filter":     filter,
+		"limit":      limit,
 	}).Debug("scanning table chunk")
 
 	// Split "public.foo" tableID into "public" schema and "foo" table name
 	var parts = strings.SplitN(streamID, ".", 2)
 	var schemaName, tableName = parts[0], parts[1]
 
-	// Build and execute a query to fetch the next `backfillChunkSize` rows from the database
-	var query = buildScanQuery(resumeKey == nil, keyColumns, schemaName, tableName)
+	// Build and execute a query to fetch the next `limit` rows from the database
+	columns, err := db.scanColumns(ctx, conn, streamID, schemaName, tableName, resumeKey == nil)
+	if err != nil {
+		return nil, err
+	}
+	var query = buildScanQuery(resumeKey == nil, keyColumns, columns, schemaName, tableName, filter, limit)
 	var args []interface{}
-	if resumeKey != nil {
-		var err error
-		args, err = unpackTuple(resumeKey)
-		if err != nil {
-			return nil, fmt.Errorf("error unpacking encoded tuple: %w", err)
-		}
-		if len(args) != len(keyColumns) {
-			return nil, fmt.Errorf("expected %d primary-key values but got %d", len(keyColumns), len(args))
+	for _, val := range resumeKey {
+		if tid, ok := val.(tuple.Tuple); ok {
+			var err error
+			if val, err = decodeTID(tid); err != nil {
+				return nil, err
+			}
 		}
+		args = append(args, val)
 	}
 	logrus.WithFields(logrus.Fields{"query": query, "args": args}).Debug("executing query")
+	var startTime = time.Now()
 	rows, err := conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to execute query %q: %w", query, err)
 	}
 	defer rows.Close()
 
-	// Process the results into `changeEvent` structs and return them
+	// Process the results into `ChangeEvent` structs and return them
 	var cols = rows.FieldDescriptions()
-	var events []*changeEvent
+	var events []*sqlcapture.ChangeEvent
+	var totalBytes int
 	for rows.Next() {
 		// Scan the row values and copy into the equivalent map
 		var vals, err = rows.Values()
 		if err != nil {
 			return nil, fmt.Errorf("unable to get row values: %w", err)
 		}
+		for _, raw := range rows.RawValues() {
+			totalBytes += len(raw)
+		}
 		var fields = make(map[string]interface{})
 		for idx := range cols {
 			fields[string(cols[idx].Name)] = vals[idx]
 		}
+		if err := db.tables.transformFields(streamID, fields); err != nil {
+			return nil, fmt.Errorf("table %q: %w", streamID, err)
+		}
 
-		events = append(events, &changeEvent{
+		// Rows of keyless tables are identified by their physical location at
+		// the time of the scan, which also serves as their scan key.
+		var changeID string
+		if tid, ok := fields[ctidColumn].(pgtype.TID); ok {
+			changeID = fmt.Sprintf("B%08X%04X", tid.BlockNumber, tid.OffsetNumber)
+			fields[ctidColumn] = encodeTID(tid)
+		}
+
+		events = append(events, &sqlcapture.ChangeEvent{
 			Type:      "Insert",
+			ChangeID:  changeID,
 			Namespace: schemaName,
 			Table:     tableName,
 			Fields:    fields,
 		})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query results: %w", err)
+	}
+	db.chunkSizes.Observe(streamID, limit, len(events), totalBytes, time.Since(startTime))
 	return events, nil
 }
 
-// writeWatermark writes a new random UUID into the 'watermarks' table and returns the
-// UUID. This is also an entrypoint to the database-specific portion of the backfill.
-func writeWatermark(ctx context.Context, conn *pgx.Conn, table, slot string) (string, error) {
-	// Generate a watermark UUID
-	var wm = uuid.New().String()
+// encodeTID represents a row location as a (block, offset) tuple, so that it
+// sorts correctly when serialized as part of a row key.
+func encodeTID(tid pgtype.TID) tuple.Tuple {
+	return tuple.Tuple{int64(tid.BlockNumber), int64(tid.OffsetNumber)}
+}
 
-	var query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot TEXT PRIMARY KEY, watermark TEXT);", table)
-	rows, err := conn.Query(ctx, query)
+// decodeTID is the inverse of encodeTID.
+func decodeTID(t tuple.Tuple) (pgtype.TID, error) {
+	if len(t) != 2 {
+		return pgtype.TID{}, fmt.Errorf("invalid TID tuple %v", t)
+	}
+	var block, blockOK = t[0].(int64)
+	var offset, offsetOK = t[1].(int64)
+	if !blockOK || !offsetOK {
+		return pgtype.TID{}, fmt.Errorf("invalid TID tuple %v", t)
+	}
+	return pgtype.TID{BlockNumber: uint32(block), OffsetNumber: uint16(offset), Status: pgtype.Present}, nil
+}
+
+// WriteWatermark writes the provided string into the 'watermarks' table.
+func (db *postgresDatabase) WriteWatermark(ctx context.Context, watermark string) error {
+	var query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot TEXT PRIMARY KEY, watermark TEXT);", quoteStreamID(db.config.WatermarksTable))
+	rows, err := db.connScan.Query(ctx, query)
 	if err != nil {
-		return "", fmt.Errorf("error creating watermarks table: %w", err)
+		return fmt.Errorf("error creating watermarks table: %w", err)
 	}
 	rows.Close()
 
-	query = fmt.Sprintf(`INSERT INTO %s (slot, watermark) VALUES ($1,$2) ON CONFLICT (slot) DO UPDATE SET watermark = $2;`, table)
-	rows, err = conn.Query(ctx, query, slot, wm)
+	query = fmt.Sprintf(`INSERT INTO %s (slot, watermark) VALUES ($1,$2) ON CONFLICT (slot) DO UPDATE SET watermark = $2;`, quoteStreamID(db.config.WatermarksTable))
+	rows, err = db.connScan.Query(ctx, query, db.config.SlotName, watermark)
 	if err != nil {
-		return "", fmt.Errorf("error upserting new watermark for slot %q: %w", slot, err)
+		return fmt.Errorf("error upserting new watermark for slot %q: %w", db.config.SlotName, err)
 	}
 	rows.Close()
-
-	logrus.WithField("watermark", wm).Debug("wrote watermark")
-	return wm, nil
+	return nil
 }
 
-// backfillChunkSize controls how many rows will be read from the database in a
-// single query. In normal use it acts like a constant, it's just a variable here
-// so that it can be lowered in tests to exercise chunking behavior more easily.
+// backfillChunkSize is the default number of rows read from a table in its first
+// backfill query, after which the chunk size adapts to the table. In normal use it
+// acts like a constant, it's just a variable here so that it can be lowered in tests
+// to exercise chunking behavior more easily.
 var backfillChunkSize = 4096
 
-func buildScanQuery(start bool, keyColumns []string, schemaName, tableName string) string {
-	// Construct strings like `(foo, bar, baz)` and `($1, $2, $3)` for use in the query
+// ctidColumn is the name of the PostgreSQL system column holding the physical
+// location of a row. Tables without any primary key are backfilled in ctid order,
+// which is represented by using this as the sole key column of the table.
+const ctidColumn = "ctid"
+
+// isKeyless returns true if the specified key columns denote a keyless table,
+// which is backfilled in physical (ctid) order.
+func isKeyless(keyColumns []string) bool {
+	return len(keyColumns) == 1 && keyColumns[0] == ctidColumn
+}
+
+// buildScanQuery constructs a query which reads `limit` rows of the table matching `filter`
+// in key order, starting after the key given as arguments unless `start` is true. Only the
+// named columns are selected, unless `columns` is nil in which case every column is.
+func buildScanQuery(start bool, keyColumns, columns []string, schemaName, tableName, filter string, limit int) string {
+	// Construct strings like `("foo", "bar", "baz")` and `($1, $2, $3)` for use in the query
 	var pkey, args string
 	for idx, colName := range keyColumns {
 		if idx > 0 {
 			pkey += ", "
 			args += ", "
 		}
-		pkey += colName
+		pkey += quoteIdentifier(colName)
 		args += fmt.Sprintf("$%d", idx+1)
 	}
 
-	// Construct the query itself
+	// Construct the query itself. The `ctid` system column is only returned
+	// by `SELECT *` when explicitly requested, as it is for keyless tables.
+	var selected = "*"
+	if columns != nil {
+		selected = quoteIdentifiers(columns)
+	}
 	var query = new(strings.Builder)
-	fmt.Fprintf(query, "SELECT * FROM %s.%s", schemaName, tableName)
+	if isKeyless(keyColumns) {
+		fmt.Fprintf(query, "SELECT %s, %s FROM %s", ctidColumn, selected, quoteTableName(schemaName, tableName))
+	} else {
+		fmt.Fprintf(query, "SELECT %s FROM %s", selected, quoteTableName(schemaName, tableName))
+	}
+	var conditions []string
+	if filter != "" {
+		conditions = append(conditions, filter)
+	}
 	if !start {
-		fmt.Fprintf(query, " WHERE (%s) > (%s)", pkey, args)
+		conditions = append(conditions, fmt.Sprintf("(%s) > (%s)", pkey, args))
+	}
+	if len(conditions) > 0 {
+		fmt.Fprintf(query, " WHERE %s", strings.Join(conditions, " AND "))
 	}
 	fmt.Fprintf(query, " ORDER BY (%s)", pkey)
-	fmt.Fprintf(query, " LIMIT %d;", backfillChunkSize)
+	fmt.Fprintf(query, " LIMIT %d;", limit)
 	return query.String()
 }
+
+// quoteIdentifier quotes a column (or other) name for use in SQL, so that names
+// with mixed case, spaces, or reserved words refer to exactly the named object.
+func quoteIdentifier(name string) string {
+	return pgx.Identifier{name}.Sanitize()
+}
+
+// quoteIdentifiers quotes each of a list of names, and joins them with commas.
+func quoteIdentifiers(names []string) string {
+	var quoted = make([]string, len(names))
+	for idx, name := range names {
+		quoted[idx] = quoteIdentifier(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// quoteTableName quotes a schema-qualified table name for use in SQL.
+func quoteTableName(schemaName, tableName string) string {
+	return pgx.Identifier{schemaName, tableName}.Sanitize()
+}
+
+// quoteStreamID quotes a "<schema>.<table>" stream ID for use in SQL. The schema
+// name may not contain a period, but the table name may.
+func quoteStreamID(streamID string) string {
+	var parts = strings.SplitN(streamID, ".", 2)
+	if len(parts) != 2 {
+		return quoteIdentifier(streamID)
+	}
+	return quoteTableName(parts[0], parts[1])
+}
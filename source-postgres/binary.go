@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// The possible values of the `replication_format` config option, which controls
+// how column values are transferred in the replication stream.
+const (
+	replicationFormatText   = "text"   // Values are sent as text, and parsed by the connector.
+	replicationFormatBinary = "binary" // Values are sent in their binary wire format where possible.
+)
+
+// binaryFormatMinVersion is the first PostgreSQL version (14) whose pgoutput
+// plugin supports the binary transfer of column values.
+const binaryFormatMinVersion = 140000
+
+// queryColumnTypes lists the distinct types of the columns of every table, along
+// with the details needed to decode the values of user-defined types.
+const queryColumnTypes = `
+  SELECT DISTINCT t.oid, t.typname, t.typtype, t.typbasetype
+  FROM pg_catalog.pg_attribute a
+    JOIN pg_catalog.pg_class c ON (a.attrelid = c.oid)
+    JOIN pg_catalog.pg_type t ON (a.atttypid = t.oid)
+  WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN ('r', 'p');`
+
+// binaryReplicationTypes determines whether the values of every column in the database
+// can be decoded from their binary format. If so it returns a map from the OIDs of
+// user-defined column types to the OIDs of the types whose binary decoders can be used
+// for them: the base type of a domain, or text for the labels of an enum. Otherwise, or
+// if the server is too old to send binary values, it returns nil and the replication
+// stream falls back to text.
+func (db *postgresDatabase) binaryReplicationTypes(ctx context.Context) (map[uint32]uint32, error) {
+	var serverVersion int
+	if err := db.connScan.QueryRow(ctx, `SELECT current_setting('server_version_num')::integer;`).Scan(&serverVersion); err != nil {
+		return nil, fmt.Errorf("error querying server version: %w", err)
+	}
+	if serverVersion < binaryFormatMinVersion {
+		logrus.WithField("version", serverVersion).Warn("binary replication requires PostgreSQL 14 or later, falling back to text")
+		return nil, nil
+	}
+
+	var connInfo = pgtype.NewConnInfo()
+	var hasBinaryDecoder = func(oid uint32) bool {
+		if dt, ok := connInfo.DataTypeForOID(oid); ok {
+			_, ok = dt.Value.(pgtype.BinaryDecoder)
+			return ok
+		}
+		return false
+	}
+
+	var aliases = make(map[uint32]uint32)
+	var unsupported []string
+	var oid, baseOID uint32
+	var typeName, typeType string
+	if _, err := db.connScan.QueryFunc(ctx, queryColumnTypes, nil,
+		[]interface{}{&oid, &typeName, &typeType, &baseOID},
+		func(r pgx.QueryFuncRow) error {
+			switch {
+			case hasBinaryDecoder(oid):
+			case typeType == "e":
+				aliases[oid] = pgtype.TextOID
+			case typeType == "d" && hasBinaryDecoder(baseOID):
+				aliases[oid] = baseOID
+			default:
+				unsupported = append(unsupported, typeName)
+			}
+			return nil
+		}); err != nil {
+		return nil, fmt.Errorf("error querying column types: %w", err)
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		logrus.WithField("types", unsupported).Warn("some column types can't be decoded from binary, falling back to text replication")
+		return nil, nil
+	}
+	return aliases, nil
+}
+
+// decodeBinaryColumnData decodes a column value sent in its binary format.
+func (s *replicationStream) decodeBinaryColumnData(data []byte, dataType uint32) (interface{}, error) {
+	if alias, ok := s.binaryTypes[dataType]; ok {
+		dataType = alias
+	}
+	var dt, ok = s.connInfo.DataTypeForOID(dataType)
+	if !ok {
+		return nil, fmt.Errorf("no binary decoder for type %q (restarting the capture will fall back to text)", s.typeName(dataType))
+	}
+	decoder, ok := dt.Value.(pgtype.BinaryDecoder)
+	if !ok {
+		return nil, fmt.Errorf("no binary decoder for type %q (restarting the capture will fall back to text)", dt.Name)
+	}
+	if err := decoder.DecodeBinary(s.connInfo, data); err != nil {
+		return nil, err
+	}
+	return decoder.(pgtype.Value).Get(), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+)
+
+// benchmarkTuple returns a relation and a tuple of values for it, with the values
+// encoded in either the text or binary format. The text of each value is given
+// explicitly, as PostgreSQL would send it.
+func benchmarkTuple(b *testing.B, connInfo *pgtype.ConnInfo, binary bool) (*pglogrepl.RelationMessage, *pglogrepl.TupleData) {
+	var numeric pgtype.Numeric
+	if err := numeric.Set("12345.6789"); err != nil {
+		b.Fatalf("error setting numeric: %v", err)
+	}
+	var payload = []byte("some binary payload data")
+	var columns = []struct {
+		name  string
+		oid   uint32
+		text  string
+		value pgtype.BinaryEncoder
+	}{
+		{"id", pgtype.Int8OID, "123456789", &pgtype.Int8{Int: 123456789, Status: pgtype.Present}},
+		{"amount", pgtype.NumericOID, "12345.6789", &numeric},
+		{"updated_at", pgtype.TimestamptzOID, "2021-11-03 12:30:00+00", &pgtype.Timestamptz{Time: time.Date(2021, 11, 3, 12, 30, 0, 0, time.UTC), Status: pgtype.Present}},
+		{"payload", pgtype.ByteaOID, `\x` + hex.EncodeToString(payload), &pgtype.Bytea{Bytes: payload, Status: pgtype.Present}},
+		{"description", pgtype.TextOID, "a description of moderate length", &pgtype.Text{String: "a description of moderate length", Status: pgtype.Present}},
+	}
+
+	var rel = &pglogrepl.RelationMessage{Namespace: "public", RelationName: "bench"}
+	var tuple = &pglogrepl.TupleData{}
+	for _, col := range columns {
+		rel.Columns = append(rel.Columns, &pglogrepl.RelationMessageColumn{Name: col.name, DataType: col.oid})
+
+		var data = []byte(col.text)
+		var dataType uint8 = 't'
+		if binary {
+			var err error
+			if data, err = col.value.EncodeBinary(connInfo, nil); err != nil {
+				b.Fatalf("error encoding column %q: %v", col.name, err)
+			}
+			dataType = 'b'
+		}
+		tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: dataType, Length: uint32(len(data)), Data: data})
+	}
+	rel.ColumnNum = uint16(len(rel.Columns))
+	tuple.ColumnNum = uint16(len(tuple.Columns))
+	return rel, tuple
+}
+
+// BenchmarkDecodeTuple compares the throughput of decoding replicated rows whose
+// values are sent in the text and binary formats.
+func BenchmarkDecodeTuple(b *testing.B) {
+	for _, format := range []string{replicationFormatText, replicationFormatBinary} {
+		b.Run(format, func(b *testing.B) {
+			var stream = &replicationStream{
+				connInfo:    pgtype.NewConnInfo(),
+				tables:      newTableOptions(nil, nil),
+				binary:      format == replicationFormatBinary,
+				binaryTypes: make(map[uint32]uint32),
+			}
+			var rel, tuple = benchmarkTuple(b, stream.connInfo, stream.binary)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := stream.decodeTuple(tuple, rel, false); err != nil {
+					b.Fatalf("error decoding tuple: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"sort"
 	"strings"
 	"time"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/protocols/airbyte"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pglogrepl"
@@ -21,78 +21,29 @@ const (
 	defaultSchemaName = "public"
 )
 
-// PersistentState represents the part of a connector's state which can be serialized
-// and emitted in a state checkpoint, and resumed from after a restart.
-type PersistentState struct {
-	// The LSN (sequence number) from which replication should resume.
-	CurrentLSN pglogrepl.LSN `json:"current_lsn"`
-	// A mapping from table IDs (<namespace>.<table>) to table-sepcific state.
-	Streams map[string]*TableState `json:"streams"`
-}
+// postgresDatabase implements sqlcapture.Database (along with the optional
+// KeylessDatabase, SnapshotDatabase, and ValueFetcher interfaces) for PostgreSQL.
+type postgresDatabase struct {
+	config     *Config       // The configuration read from `config.json`
+	connScan   *pgx.Conn     // The DB connection used for table scanning
+	chunkSizes *chunkSizer   // The number of rows to read from each table per backfill query
+	tables     *tableOptions // The per-table configuration of the capture
 
-// Validate performs basic sanity-checking after a state has been parsed from JSON. More
-// detailed checks are performed by UpdateState.
-func (ps *PersistentState) Validate() error {
-	return nil
+	scanColumnNames map[string][]string // The columns selected by the current scan of each projected table
 }
 
-// pendingStreams returns the IDs of all streams which still need to be backfilled,
-// in sorted order for test stability.
-func (ps *PersistentState) pendingStreams() []string {
-	var pending []string
-	for id, tableState := range ps.Streams {
-		if tableState.Mode == tableModeBackfill {
-			pending = append(pending, id)
-		}
+// RunCapture is the top level of the database capture process. It is responsible for opening
+// DB connections and performing PostgreSQL-specific sanity checks, after which the generic
+// capture process takes over.
+func RunCapture(ctx context.Context, config *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState, dest sqlcapture.MessageOutput) error {
+	// Each shard of a capture split by key range replicates from its own slot, and
+	// emits just the changes to rows within its range.
+	if !sqlcapture.IsFullRange(catalog.Range) {
+		var shardConfig = *config
+		shardConfig.SlotName = shardSlotName(config.SlotName, catalog.Range)
+		config = &shardConfig
 	}
-	sort.Strings(pending)
-	return pending
-}
-
-// TableState represents the serializable/resumable state of a particular table's capture.
-// It is mostly concerned with the "backfill" scanning process and the transition from that
-// to logical replication.
-type TableState struct {
-	// Mode is either "Backfill" during the backfill scanning process
-	// or "Active" once the backfill is complete.
-	Mode string `json:"mode"`
-	// KeyColumns is the "primary key" used for ordering/chunking the backfill scan.
-	KeyColumns []string `json:"key_columns,omitempty"`
-	// Scanned is a FoundationDB-serialized tuple representing the KeyColumns
-	// values of the last row which has been backfilled. Replication events will
-	// only be emitted for rows <= this value while backfilling is in progress.
-	Scanned []byte `json:"scanned,omitempty"`
-}
-
-const (
-	tableModeBackfill = "Backfill"
-	tableModeActive   = "Active"
-	tableModeIgnore   = "Ignore"
-)
-
-// capture encapsulates the entire process of capturing data from PostgreSQL with a particular
-// configuration/catalog/state and emitting records and state updates to some messageOutput.
-type capture struct {
-	state   *PersistentState           // State read from `state.json` and emitted as updates
-	config  *Config                    // The configuration read from `config.json`
-	catalog *airbyte.ConfiguredCatalog // The catalog read from `catalog.json`
-	encoder messageOutput              // The encoder to which records and state updates are written
-
-	connScan   *pgx.Conn          // The DB connection used for table scanning
-	replStream *replicationStream // The high-level replication stream abstraction
-}
-
-// messageOutput represents "the thing to which Capture writes records and state checkpoints".
-// A json.Encoder satisfies this interface in normal usage, but during tests a custom messageOutput
-// is used which collects output in memory.
-type messageOutput interface {
-	Encode(v interface{}) error
-}
 
-// RunCapture is the top level of the database capture process. It  is responsible for opening DB
-// connections, scanning tables, and then streaming replication events until shutdown conditions
-// (if any) are met.
-func RunCapture(ctx context.Context, config *Config, catalog *airbyte.ConfiguredCatalog, state *PersistentState, dest messageOutput) error {
 	logrus.WithFields(logrus.Fields{
 		"uri":  config.ConnectionURI,
 		"slot": config.SlotName,
@@ -105,292 +56,156 @@ func RunCapture(ctx context.Context, config *Config, catalog *airbyte.Configured
 	}
 	defer connScan.Close(ctx)
 
-	// Replication database connection used for event streaming
-	replConnConfig, err := pgconn.ParseConfig(config.ConnectionURI)
-	if err != nil {
-		return err
-	}
-	replConnConfig.RuntimeParams["replication"] = "database"
-	connRepl, err := pgconn.ConnectConfig(ctx, replConnConfig)
-	if err != nil {
-		return fmt.Errorf("unable to connect to database for replication: %w", err)
-	}
-	replStream, err := startReplication(ctx, connRepl, config.SlotName, config.PublicationName, state.CurrentLSN)
-	if err != nil {
-		return fmt.Errorf("unable to start replication stream: %w", err)
-	}
-	defer replStream.Close(ctx)
-
-	var c = &capture{
-		state:      state,
-		config:     config,
-		catalog:    catalog,
-		encoder:    dest,
-		connScan:   connScan,
-		replStream: replStream,
-	}
-
-	if err := c.updateState(ctx); err != nil {
-		return fmt.Errorf("error updating capture state: %w", err)
-	}
-
-	return c.streamChanges(ctx)
-}
-
-func (c *capture) updateState(ctx context.Context) error {
-	var stateDirty = false
-
-	// Create the Streams map if nil
-	if c.state.Streams == nil {
-		c.state.Streams = make(map[string]*TableState)
-		stateDirty = true
+	// Streams without a namespace are assumed to be in the default schema.
+	for idx := range catalog.Streams {
+		if catalog.Streams[idx].Stream.Namespace == "" {
+			catalog.Streams[idx].Stream.Namespace = defaultSchemaName
+		}
 	}
-
-	// Streams may be added to the catalog at various times. We need to
-	// initialize new state entries for these streams, and while we're at
-	// it this is a good time to sanity-check the primary key configuration.
-	var dbPrimaryKeys, err = getPrimaryKeys(ctx, c.connScan)
+	dbPrimaryKeys, err := getPrimaryKeys(ctx, connScan)
 	if err != nil {
 		return fmt.Errorf("error querying database about primary keys: %w", err)
 	}
-
-	for _, catalogStream := range c.catalog.Streams {
-		var streamID = joinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
-
-		// In the catalog a primary key is an array of arrays of strings, but in the
-		// case of Postgres each of those sub-arrays must be length-1 because we're
-		// just naming a column and can't descend into individual fields.
-		var catalogPrimaryKey []string
-		for _, col := range catalogStream.PrimaryKey {
-			if len(col) != 1 {
-				return fmt.Errorf("stream %q: primary key element %q invalid", streamID, col)
-			}
-			catalogPrimaryKey = append(catalogPrimaryKey, col[0])
+	if config.CaptureMode == sqlcapture.CaptureModeReplication {
+		if err := checkReplicaIdentities(ctx, connScan, catalog, dbPrimaryKeys); err != nil {
+			return err
 		}
+	}
 
-		// If the `PrimaryKey` property is specified in the catalog then use that,
-		// otherwise use the "native" primary key of this table in the database.
-		// Print a warning if the two are not the same.
-		var primaryKey = dbPrimaryKeys[streamID]
-		if len(primaryKey) != 0 {
-			logrus.WithFields(logrus.Fields{
-				"table": streamID,
-				"key":   primaryKey,
-			}).Debug("queried primary key")
-		}
-		if len(catalogPrimaryKey) != 0 {
-			if strings.Join(primaryKey, ",") != strings.Join(catalogPrimaryKey, ",") {
-				logrus.WithFields(logrus.Fields{
-					"stream":      streamID,
-					"catalogKey":  catalogPrimaryKey,
-					"databaseKey": primaryKey,
-				}).Warn("primary key in catalog differs from database table")
+	// The key columns of each table are always captured, even when excluded by the
+	// table's configuration. Keys specified in the catalog take precedence, as they
+	// do for the capture itself.
+	var keyColumns = make(map[string][]string)
+	for streamID, key := range dbPrimaryKeys {
+		keyColumns[streamID] = key
+	}
+	for _, catalogStream := range catalog.Streams {
+		if len(catalogStream.PrimaryKey) > 0 {
+			var streamID = sqlcapture.JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
+			var key []string
+			for _, col := range catalogStream.PrimaryKey {
+				key = append(key, col[0])
 			}
-			primaryKey = catalogPrimaryKey
-		}
-		if len(primaryKey) == 0 {
-			return fmt.Errorf("stream %q: primary key unspecified in the catalog and no primary key found in database", streamID)
-		}
-
-		// See if the stream is already initialized. If it's not, then create it.
-		var streamState, ok = c.state.Streams[streamID]
-		if !ok {
-			c.state.Streams[streamID] = &TableState{Mode: tableModeBackfill, KeyColumns: primaryKey}
-			stateDirty = true
-			continue
-		}
-
-		if strings.Join(streamState.KeyColumns, ",") != strings.Join(primaryKey, ",") {
-			return fmt.Errorf("stream %q: primary key %q doesn't match initialized scan key %q", streamID, primaryKey, streamState.KeyColumns)
+			keyColumns[streamID] = key
 		}
 	}
-
-	// Likewise streams may be removed from the catalog, and we need to forget
-	// the corresponding state information.
-	for streamID := range c.state.Streams {
-		// List membership checks are always a pain in Go, but that's all this loop is
-		var streamExistsInCatalog = false
-		for _, catalogStream := range c.catalog.Streams {
-			var catalogStreamID = joinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
-			if streamID == catalogStreamID {
-				streamExistsInCatalog = true
+	// When polling, the cursor column of each table is also part of its scan key.
+	if config.CaptureMode == sqlcapture.CaptureModePolling {
+		for streamID, table := range config.Tables {
+			if table.CursorColumn != "" {
+				keyColumns[streamID] = append([]string{table.CursorColumn}, keyColumns[streamID]...)
 			}
 		}
-
-		if !streamExistsInCatalog {
-			logrus.WithField("stream", streamID).Info("stream removed from catalog")
-			delete(c.state.Streams, streamID)
-			stateDirty = true
-		}
 	}
 
-	// If we've altered the state, emit it to stdout. This isn't strictly necessary
-	// but it helps to make the emitted sequence of state updates a lot more readable.
-	if stateDirty {
-		c.emitState(c.state)
+	var db = &postgresDatabase{
+		config:          config,
+		connScan:        connScan,
+		chunkSizes:      newChunkSizer(config.BackfillChunkSize, config.BackfillChunkSizes),
+		tables:          newTableOptions(config.Tables, keyColumns),
+		scanColumnNames: make(map[string][]string),
 	}
-	return nil
-}
-
-// This is the main loop of the capture process, which interleaves replication event
-// streaming with backfill scan results as necessary.
-func (c *capture) streamChanges(ctx context.Context) error {
-	var results *resultSet
-	for c.state.pendingStreams() != nil {
-		watermark, err := writeWatermark(ctx, c.connScan, c.config.WatermarksTable, c.config.SlotName)
-		if err != nil {
-			return fmt.Errorf("error writing next watermark: %w", err)
-		}
-		if err := c.streamToWatermark(watermark, results); err != nil {
-			return fmt.Errorf("error streaming until watermark: %w", err)
-		} else if err := c.emitBuffered(results); err != nil {
-			return fmt.Errorf("error emitting buffered results: %w", err)
-		}
-		results, err = c.backfillStreams(ctx, c.state.pendingStreams())
-		if err != nil {
-			return fmt.Errorf("error performing backfill: %w", err)
-		}
+	db.tables.polling = config.CaptureMode == sqlcapture.CaptureModePolling
+	if err := db.tables.checkTransforms(); err != nil {
+		return err
 	}
-
-	// Once there is no more backfilling to do, just stream changes forever and emit
-	// state updates on every transaction commit.
-	var targetWatermark = "nonexistent-watermark"
-	if !c.catalog.Tail {
-		var watermark, err = writeWatermark(ctx, c.connScan, c.config.WatermarksTable, c.config.SlotName)
-		if err != nil {
-			return fmt.Errorf("error writing poll watermark: %w", err)
-		}
-		targetWatermark = watermark
+	return sqlcapture.RunCapture(ctx, db, &sqlcapture.Options{
+		BackfillMethod:        config.BackfillMethod,
+		SchemaChangePolicy:    config.SchemaChangePolicy,
+		SchemaChangesStream:   config.SchemaChangesStream,
+		UnchangedColumnPolicy: config.UnchangedToastPolicy,
+		SignalTable:           config.SignalTable,
+		HeartbeatInterval:     time.Duration(config.HeartbeatInterval) * time.Second,
+		BackfillMemoryLimit:   config.BackfillMemoryLimit * 1024 * 1024,
+		BackfillFilters:       db.tables.backfillFilters(),
+		CaptureMode:           config.CaptureMode,
+		PollInterval:          time.Duration(config.PollInterval) * time.Second,
+		PollCursorColumns:     db.tables.cursorColumns(),
+		MessagesStream:        config.MessagesStream,
+	}, catalog, state, dest)
+}
+
+// shardSlotName returns the name of the replication slot used by the capture shard
+// responsible for the given key range.
+func shardSlotName(slot string, r airbyte.Range) string {
+	return fmt.Sprintf("%s_%08x_%08x", slot, r.Begin, r.End)
+}
+
+// checkReplicaIdentities checks the replica identity of each table in the catalog,
+// logging the consequences for how changes to it will be captured.
+func checkReplicaIdentities(ctx context.Context, conn *pgx.Conn, catalog *airbyte.ConfiguredCatalog, dbPrimaryKeys map[string][]string) error {
+	var dbReplicaIdentities, err = getReplicaIdentities(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("error querying database about replica identities: %w", err)
 	}
-	logrus.WithFields(logrus.Fields{
-		"tail":      c.catalog.Tail,
-		"watermark": targetWatermark,
-	}).Info("streaming until watermark")
-	return c.streamToWatermark(targetWatermark, nil)
-}
-
-func (c *capture) streamToWatermark(watermark string, results *resultSet) error {
-	var watermarkReached = false
-	for event := range c.replStream.Events() {
-		// Commit events update the current LSN and trigger a state update. If this is
-		// the commit after the target watermark, it also ends the loop.
-		if event.Type == "Commit" {
-			c.state.CurrentLSN = event.LSN
-			if err := c.emitState(c.state); err != nil {
-				return fmt.Errorf("error emitting state update: %w", err)
-			}
-			if watermarkReached {
-				return nil
-			}
-			continue
-		}
-
-		// Note when the expected watermark is finally observed. The subsequent Commit will exit the loop.
-		// TODO(wgd): Can we ensure/require that 'WatermarksTable' is always fully-qualified?
-		var streamID = joinStreamID(event.Namespace, event.Table)
-		if streamID == c.config.WatermarksTable {
-			logrus.WithFields(logrus.Fields{"expected": watermark, "written": event.Fields["watermark"]}).Debug("watermark write")
-			if event.Fields["watermark"] == watermark {
-				watermarkReached = true
-			}
-		}
-
-		// Handle the easy cases: Events on ignored or fully-active tables.
-		var tableState = c.state.Streams[streamID]
-		if tableState == nil || tableState.Mode == tableModeIgnore {
-			logrus.WithFields(logrus.Fields{"stream": streamID, "type": event.Type}).Debug("ignoring stream")
-			continue
-		}
-		if tableState.Mode == tableModeActive {
-			if err := c.handleChangeEvent(event); err != nil {
-				return fmt.Errorf("error handling replication event: %w", err)
-			}
-			continue
-		}
-		if tableState.Mode != tableModeBackfill {
-			return fmt.Errorf("table %q in invalid mode %q", streamID, tableState.Mode)
-		}
-
-		// While a table is being backfilled, events occurring *before* the current scan point
-		// will be emitted, while events *after* that point will be patched (or ignored) into
-		// the buffered resultSet.
-		var rowKey, err = encodeRowKey(tableState.KeyColumns, event.Fields)
-		if err != nil {
-			return fmt.Errorf("error encoding row key: %w", err)
-		}
-		if compareTuples(rowKey, tableState.Scanned) <= 0 {
-			if err := c.handleChangeEvent(event); err != nil {
-				return fmt.Errorf("error handling replication event: %w", err)
-			}
-		} else if err := results.Patch(streamID, event); err != nil {
-			return fmt.Errorf("error patching resultset: %w", err)
+	for _, catalogStream := range catalog.Streams {
+		var streamID = sqlcapture.JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
+		if identity, ok := dbReplicaIdentities[streamID]; ok {
+			checkReplicaIdentity(streamID, identity, len(dbPrimaryKeys[streamID]) > 0)
 		}
 	}
 	return nil
 }
 
-func (c *capture) emitBuffered(results *resultSet) error {
-	// Emit any buffered results and update table states accordingly.
-	for _, streamID := range results.Streams() {
-		var events = results.Changes(streamID)
-		for _, event := range events {
-			if err := c.handleChangeEvent(event); err != nil {
-				return fmt.Errorf("error handling backfill change: %w", err)
-			}
+// StartReplication opens a replication connection and begins streaming changes
+// from the specified LSN, or from the current WAL position if it's empty.
+func (db *postgresDatabase) StartReplication(ctx context.Context, startCursor string) (sqlcapture.ReplicationStream, error) {
+	var startLSN pglogrepl.LSN
+	if startCursor != "" {
+		var err error
+		if startLSN, err = pglogrepl.ParseLSN(startCursor); err != nil {
+			return nil, fmt.Errorf("error parsing start cursor %q: %w", startCursor, err)
 		}
+	}
 
-		if results.Complete(streamID) {
-			c.state.Streams[streamID].Mode = tableModeActive
-			c.state.Streams[streamID].Scanned = nil
-		} else {
-			c.state.Streams[streamID].Scanned = results.Scanned(streamID)
+	// Replication database connection used for event streaming
+	var replConnConfig, err = pgconn.ParseConfig(db.config.ConnectionURI)
+	if err != nil {
+		return nil, err
+	}
+	replConnConfig.RuntimeParams["replication"] = "database"
+	connRepl, err := pgconn.ConnectConfig(ctx, replConnConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database for replication: %w", err)
+	}
+	if err := db.ensureReplication(ctx, connRepl, startLSN); err != nil {
+		connRepl.Close(ctx)
+		return nil, err
+	}
+	var binaryTypes map[uint32]uint32
+	if db.config.ReplicationFormat == replicationFormatBinary {
+		if binaryTypes, err = db.binaryReplicationTypes(ctx); err != nil {
+			connRepl.Close(ctx)
+			return nil, err
 		}
 	}
-
-	// Emit a new state update. The global `CurrentLSN` has been advanced by the
-	// watermark commit event, and the individual stream `Scanned` tracking for
-	// each stream has been advanced just above.
-	return c.emitState(c.state)
+	return startReplication(ctx, connRepl, db.config.SlotName, db.config.PublicationName, startLSN, db.tables, binaryTypes, newMessageOptions(db.config))
 }
 
-func (c *capture) backfillStreams(ctx context.Context, streams []string) (*resultSet, error) {
-	var results = newResultSet()
-
-	// TODO(wgd): Add a sanity-check assertion that the current watermark value
-	// in the database matches the one we previously wrote? Maybe that's more effort
-	// than it's worth until we have other evidence of correctness violations though.
-
-	// TODO(wgd): We can dispatch these table reads concurrently with a WaitGroup
-	// for synchronization.
-	for _, streamID := range streams {
-		var streamState = c.state.Streams[streamID]
+// WatermarksTable returns the stream ID of the watermarks table.
+func (db *postgresDatabase) WatermarksTable() string {
+	return db.config.WatermarksTable
+}
 
-		// Fetch a chunk of entries from the specified stream
-		var events, err = scanTableChunk(ctx, c.connScan, streamID, streamState.KeyColumns, streamState.Scanned)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning table: %w", err)
-		}
+// DiscoverPrimaryKeys returns the primary key columns of each table.
+func (db *postgresDatabase) DiscoverPrimaryKeys(ctx context.Context) (map[string][]string, error) {
+	return getPrimaryKeys(ctx, db.connScan)
+}
 
-		// Translate the resulting list of entries into a backfillChunk
-		if err := results.Buffer(streamID, streamState.KeyColumns, events); err != nil {
-			return nil, fmt.Errorf("error buffering scan results: %w", err)
-		}
+// ColumnJSONSchema returns the JSON schema of a non-null value of the named
+// PostgreSQL type, or nil if the type is unknown.
+func (db *postgresDatabase) ColumnJSONSchema(typeName string) json.RawMessage {
+	if jsonType, ok := postgresTypeToJSON[typeName]; ok {
+		return json.RawMessage(jsonType)
 	}
-	return results, nil
+	return nil
 }
 
-func (c *capture) handleChangeEvent(event *changeEvent) error {
-	event.Fields["_change_type"] = event.Type
-
-	for id, val := range event.Fields {
-		var translated, err = translateRecordField(val)
-		if err != nil {
-			logrus.WithField("val", val).Error("value translation error")
-			return fmt.Errorf("error translating field %q value: %w", id, err)
-		}
-		event.Fields[id] = translated
-	}
-	return c.emitRecord(event.Namespace, event.Table, event.Fields)
+// RowLocatorColumn returns the name of the `ctid` system column, which holds the
+// physical location of a row. Tables without any primary key are backfilled in
+// ctid order.
+func (db *postgresDatabase) RowLocatorColumn() string {
+	return ctidColumn
 }
 
 // TranslateRecordField "translates" a value from the PostgreSQL driver into
@@ -398,6 +213,10 @@ func (c *capture) handleChangeEvent(event *changeEvent) error {
 // PostgreSQL `cidr` type becomes a `*net.IPNet`, but the default JSON
 // marshalling of a `net.IPNet` isn't a great fit and we'd prefer to use
 // the `String()` method to get the usual "192.168.100.0/24" notation.
+func (db *postgresDatabase) TranslateRecordField(val interface{}) (interface{}, error) {
+	return translateRecordField(val)
+}
+
 func translateRecordField(val interface{}) (interface{}, error) {
 	switch x := val.(type) {
 	case *net.IPNet:
@@ -423,44 +242,3 @@ func translateRecordField(val interface{}) (interface{}, error) {
 	}
 	return val, nil
 }
-
-func (c *capture) emitRecord(ns, stream string, data interface{}) error {
-	var rawData, err = json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("error encoding record data: %w", err)
-	}
-	return c.emit(airbyte.Message{
-		Type: airbyte.MessageTypeRecord,
-		Record: &airbyte.Record{
-			Namespace: ns,
-			Stream:    stream,
-			EmittedAt: time.Now().UnixNano() / int64(time.Millisecond),
-			Data:      json.RawMessage(rawData),
-		},
-	})
-}
-
-func (c *capture) emitState(state interface{}) error {
-	var rawState, err = json.Marshal(state)
-	if err != nil {
-		return fmt.Errorf("error encoding state message: %w", err)
-	}
-	return c.emit(airbyte.Message{
-		Type:  airbyte.MessageTypeState,
-		State: &airbyte.State{Data: json.RawMessage(rawState)},
-	})
-}
-
-func (c *capture) emit(msg interface{}) error {
-	return c.encoder.Encode(msg)
-}
-
-// joinStreamID combines a namespace and a stream name into a fully-qualified
-// stream (or table) identifier. Because it's possible for the namespace to be
-// unspecified, we default to "public" in that situation.
-func joinStreamID(namespace, stream string) string {
-	if namespace == "" {
-		namespace = defaultSchemaName
-	}
-	return strings.ToLower(namespace + "." + stream)
-}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
 	"github.com/jackc/pglogrepl"
 	"github.com/sirupsen/logrus"
 )
@@ -16,7 +20,7 @@ import (
 func TestSimpleCapture(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	// Add data, perform capture, verify result
 	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
@@ -30,7 +34,35 @@ func TestSimpleCapture(t *testing.T) {
 func TestTailing(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	catalog.Tail = true
+
+	// Initial data which must be backfilled
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {10, "bbb"}, {20, "CDEFGHIJKLMNOP"}, {30, "Four"}, {40, "5"}})
+
+	// Run the capture
+	var captureCtx, cancelCapture = context.WithCancel(ctx)
+	go verifiedCapture(captureCtx, t, &cfg, &catalog, &state, "")
+	time.Sleep(1 * time.Second)
+
+	// Some more changes occurring after the backfill completes
+	dbInsert(ctx, t, tableName, [][]interface{}{{5, "asdf"}, {100, "lots"}})
+	dbQuery(ctx, t, fmt.Sprintf("DELETE FROM %s WHERE id = 20;", tableName))
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'updated' WHERE id = 30;", tableName))
+
+	// Let the capture catch up and then terminate it
+	time.Sleep(1 * time.Second)
+	cancelCapture()
+}
+
+// TestSnapshotBackfill performs a tailing capture using the 'snapshot' backfill
+// method, which scans the table from an exported snapshot instead of writing any
+// watermarks, and then replicates subsequent changes.
+func TestSnapshotBackfill(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	cfg.BackfillMethod = sqlcapture.BackfillMethodSnapshot
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 	catalog.Tail = true
 
 	// Initial data which must be backfilled
@@ -57,7 +89,7 @@ func TestTailing(t *testing.T) {
 func TestReplicationInserts(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
@@ -65,13 +97,31 @@ func TestReplicationInserts(t *testing.T) {
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
 }
 
+// TestQuotedIdentifiers verifies that tables and columns whose names have mixed case,
+// spaces, or are reserved words can be backfilled and replicated.
+func TestQuotedIdentifiers(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = "Test QuotedIdentifiers"
+	var quotedName = quoteTableName("public", tableName)
+	dbQuery(ctx, t, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, quotedName))
+	dbQuery(ctx, t, fmt.Sprintf(`CREATE TABLE %s ("Id" INTEGER PRIMARY KEY, "select" TEXT, "Secret Data" TEXT);`, quotedName))
+	t.Cleanup(func() { dbQuery(ctx, t, fmt.Sprintf(`DROP TABLE %s;`, quotedName)) })
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.Tables = map[string]*TableConfig{"public." + tableName: {ExcludeColumns: []string{"Secret Data"}}}
+
+	dbQuery(ctx, t, fmt.Sprintf(`INSERT INTO %s VALUES (0, 'A', 'x'), (1, 'bbb', 'y');`, quotedName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbQuery(ctx, t, fmt.Sprintf(`INSERT INTO %s VALUES (2, 'CDE', 'z');`, quotedName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
 // TestReplicationDeletes runs two captures, where the first will perform the
 // initial table scan and the second capture will use replication to receive
 // additional inserts and deletions performed after the first capture.
 func TestReplicationDeletes(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
@@ -86,7 +136,7 @@ func TestReplicationDeletes(t *testing.T) {
 func TestReplicationUpdates(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}})
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
@@ -95,12 +145,48 @@ func TestReplicationUpdates(t *testing.T) {
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
 }
 
+// TestReplicaIdentityFull verifies that updates and deletes to a table with
+// REPLICA IDENTITY FULL capture the complete previous values of the row.
+func TestReplicaIdentityFull(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	dbQuery(ctx, t, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL;", tableName))
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'updated' WHERE id = 1;", tableName))
+	dbQuery(ctx, t, fmt.Sprintf("DELETE FROM %s WHERE id = 2;", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestUnchangedToast verifies that large column values which are left unchanged by
+// an update are omitted from the update record, or fetched from the table when the
+// `unchanged_toast_policy` config option requests that.
+func TestUnchangedToast(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, big TEXT, small TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	// External storage prevents the large value from being compressed inline.
+	dbQuery(ctx, t, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN big SET STORAGE EXTERNAL;", tableName))
+	dbQuery(ctx, t, fmt.Sprintf("INSERT INTO %s VALUES (1, repeat('0123456789', 300), 'a');", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET small = 'b' WHERE id = 1;", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "omit")
+
+	cfg.UnchangedToastPolicy = unchangedToastFetch
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET small = 'c' WHERE id = 1;", tableName))
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "fetch")
+}
+
 // TestEmptyTable leaves the table empty during the initial table backfill
 // and only adds data after replication has begun.
 func TestEmptyTable(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
 	dbInsert(ctx, t, tableName, [][]interface{}{{1002, "some"}, {1000, "more"}, {1001, "rows"}})
@@ -115,7 +201,7 @@ func TestEmptyTable(t *testing.T) {
 func TestComplexDataset(t *testing.T) {
 	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
 	var tableName = createTestTable(ctx, t, "", "(year INTEGER, state TEXT, fullname TEXT, population INTEGER, PRIMARY KEY (year, state))")
-	var catalog, state = testCatalog(tableName), PersistentState{}
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
 
 	dbLoadCSV(ctx, t, tableName, "statepop.csv", 0)
 	var states = verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
@@ -141,7 +227,7 @@ func TestComplexDataset(t *testing.T) {
 // TestMultipleStreams exercises captures with multiple stream configured, as
 // well as adding/removing/re-adding a stream.
 func TestMultipleStreams(t *testing.T) {
-	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), PersistentState{}
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
 	var table1 = createTestTable(ctx, t, "one", "(id INTEGER PRIMARY KEY, data TEXT)")
 	var table2 = createTestTable(ctx, t, "two", "(id INTEGER PRIMARY KEY, data TEXT)")
 	var table3 = createTestTable(ctx, t, "three", "(id INTEGER PRIMARY KEY, data TEXT)")
@@ -164,7 +250,7 @@ func TestMultipleStreams(t *testing.T) {
 // TestCatalogPrimaryKey sets up a table with no primary key in the database
 // and instead specifies one in the catalog configuration.
 func TestCatalogPrimaryKey(t *testing.T) {
-	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), PersistentState{}
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
 	var table = createTestTable(ctx, t, "", "(year INTEGER, state TEXT, fullname TEXT, population INTEGER)")
 	dbLoadCSV(ctx, t, table, "statepop.csv", 100)
 	var catalog = testCatalog(table)
@@ -181,7 +267,7 @@ func TestCatalogPrimaryKey(t *testing.T) {
 // TestCatalogPrimaryKeyOverride sets up a table with a primary key, but
 // then overrides that via the catalog configuration.
 func TestCatalogPrimaryKeyOverride(t *testing.T) {
-	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), PersistentState{}
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
 	var table = createTestTable(ctx, t, "", "(year INTEGER, state TEXT, fullname TEXT, population INTEGER, PRIMARY KEY (year, state))")
 	dbLoadCSV(ctx, t, table, "statepop.csv", 100)
 	var catalog = testCatalog(table)
@@ -195,10 +281,20 @@ func TestCatalogPrimaryKeyOverride(t *testing.T) {
 	verifiedCapture(ctx, t, &cfg, &catalog, &state, "capture2")
 }
 
+// TestKeylessCapture sets up a table with no primary key in the database or
+// the catalog, which must be backfilled in physical order.
+func TestKeylessCapture(t *testing.T) {
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
+	var table = createTestTable(ctx, t, "", "(id INTEGER, data TEXT)")
+	var catalog = testCatalog(table)
+	dbInsert(ctx, t, table, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
 // TestIgnoredStreams checks that replicated changes are only reported
 // for tables which are configured in the catalog.
 func TestIgnoredStreams(t *testing.T) {
-	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), PersistentState{}
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
 	var table1 = createTestTable(ctx, t, "one", "(id INTEGER PRIMARY KEY, data TEXT)")
 	var table2 = createTestTable(ctx, t, "two", "(id INTEGER PRIMARY KEY, data TEXT)")
 	dbInsert(ctx, t, table1, [][]interface{}{{0, "zero"}, {1, "one"}, {2, "two"}})
@@ -219,7 +315,7 @@ func TestSlotLSNAdvances(t *testing.T) {
 		t.Skip("skipping test in short mode.")
 	}
 
-	var cfg, ctx, state = TestDefaultConfig, longTestContext(t, 60*time.Second), PersistentState{}
+	var cfg, ctx, state = TestDefaultConfig, longTestContext(t, 60*time.Second), sqlcapture.PersistentState{}
 	var table = createTestTable(ctx, t, "one", "(id INTEGER PRIMARY KEY, data TEXT)")
 	var catalog = testCatalog(table)
 
@@ -262,3 +358,270 @@ func TestSlotLSNAdvances(t *testing.T) {
 	}
 	t.Errorf("slot %q restart LSN failed to advance after %d retries", *TestReplicationSlot, retryCount)
 }
+
+// TestSignalBackfill verifies that inserting a row into the signal table causes the
+// named table to be backfilled again, restricted to the rows matching the filter
+// predicate of the signal.
+func TestSignalBackfill(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var signalTable = createTestTable(ctx, t, "signals", "(id INTEGER PRIMARY KEY, stream TEXT, filter TEXT)")
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.SignalTable = "public." + signalTable
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, signalTable, [][]interface{}{{1, "public." + tableName, "id >= 2"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestSnapshotSignalBackfill verifies that when a filtered re-backfill is performed
+// from a snapshot, changes to rows outside the filter which were made in between the
+// signal and the snapshot are still captured by replication.
+func TestSnapshotSignalBackfill(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	cfg.BackfillMethod = sqlcapture.BackfillMethodSnapshot
+	var signalTable = createTestTable(ctx, t, "signals", "(id INTEGER PRIMARY KEY, stream TEXT, filter TEXT)")
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.SignalTable = "public." + signalTable
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}, {2, "CDEFGHIJKLMNOP"}, {3, "Four"}, {4, "5"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+	dbInsert(ctx, t, signalTable, [][]interface{}{{1, "public." + tableName, "id >= 2"}})
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'updated' WHERE id = 0;", tableName))
+	var result, _ = performCapture(ctx, t, &cfg, &catalog, &state)
+
+	var changes = recordChanges(t, result)
+	if got := strings.Join(changes[0], ","); got != "Update" {
+		t.Errorf("expected only an update of row 0, got %q", got)
+	}
+	for _, id := range []int{2, 3, 4} {
+		if got := strings.Join(changes[id], ","); got != "Insert" {
+			t.Errorf("expected row %d to be backfilled once, got %q", id, got)
+		}
+	}
+	if len(changes[1]) != 0 {
+		t.Errorf("expected no changes to row 1, got %q", changes[1])
+	}
+}
+
+// TestTableOptions verifies that excluded columns are omitted from both backfilled
+// and replicated rows, and that a configured backfill filter restricts the backfill
+// without affecting replication.
+func TestTableOptions(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT, blob TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.Tables = map[string]*TableConfig{
+		"public." + tableName: {ExcludeColumns: []string{"blob"}, BackfillFilter: "id >= 2"},
+	}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A", "x"}, {1, "bbb", "x"}, {2, "CDEFGHIJKLMNOP", "x"}, {3, "Four", "x"}, {4, "5", "x"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, tableName, [][]interface{}{{1002, "some", "y"}, {1001, "more", "y"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestBackfillFilterReplication verifies that changes made in the middle of a
+// multi-chunk backfill to rows excluded by the configured backfill filter, whose
+// keys lie beyond the scan point, are still captured by replication.
+func TestBackfillFilterReplication(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.Tables = map[string]*TableConfig{"public." + tableName: {BackfillFilter: "data <> 'hidden'"}}
+
+	var rows [][]interface{}
+	for id := 0; id < 40; id++ {
+		rows = append(rows, []interface{}{id, fmt.Sprintf("row %d", id)})
+	}
+	rows = append(rows, []interface{}{100, "hidden"})
+	dbInsert(ctx, t, tableName, rows)
+
+	// Once the first chunk has been emitted, modify rows which the backfill won't scan.
+	var output = &hookedOutput{onRecord: func() {
+		dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'hidden' WHERE id = 100;", tableName))
+		dbInsert(ctx, t, tableName, [][]interface{}{{101, "hidden"}})
+	}}
+	if err := RunCapture(ctx, &cfg, &catalog, &state, output); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes = recordChanges(t, output.Snapshot.String())
+	if len(changes[39]) != 1 {
+		t.Errorf("expected row 39 to be backfilled once, got %q", changes[39])
+	}
+	if got := strings.Join(changes[100], ","); got != "Update" {
+		t.Errorf("expected only an update of row 100, got %q", got)
+	}
+	if got := strings.Join(changes[101], ","); got != "Insert" {
+		t.Errorf("expected only an insert of row 101, got %q", got)
+	}
+}
+
+// recordChanges is a test helper which collects the change types of the records in
+// the output of a capture, by the `id` of each record.
+func recordChanges(t *testing.T, output string) map[int][]string {
+	t.Helper()
+	var changes = make(map[int][]string)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var msg airbyte.Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != airbyte.MessageTypeRecord {
+			continue
+		}
+		var record struct {
+			ID   int    `json:"id"`
+			Type string `json:"_change_type"`
+		}
+		if err := json.Unmarshal(msg.Record.Data, &record); err != nil {
+			t.Fatal(err)
+		}
+		changes[record.ID] = append(changes[record.ID], record.Type)
+	}
+	return changes
+}
+
+// hookedOutput is a CaptureOutputBuffer which calls a function when the first
+// record is output, so that tests can make changes while a capture is running.
+type hookedOutput struct {
+	CaptureOutputBuffer
+	onRecord func()
+}
+
+func (out *hookedOutput) Encode(v interface{}) error {
+	if msg, ok := v.(airbyte.Message); ok && msg.Type == airbyte.MessageTypeRecord && out.onRecord != nil {
+		out.onRecord()
+		out.onRecord = nil
+	}
+	return out.CaptureOutputBuffer.Encode(v)
+}
+
+// TestPollingCapture verifies that in the polling capture mode a table is backfilled
+// in cursor order, and subsequent captures emit just the rows whose cursor has advanced.
+func TestPollingCapture(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT, version INTEGER)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.CaptureMode = sqlcapture.CaptureModePolling
+	cfg.Tables = map[string]*TableConfig{"public." + tableName: {CursorColumn: "version"}}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A", 1}, {1, "bbb", 1}, {2, "CDEFGHIJKLMNOP", 2}, {3, "Four", nil}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'a', version = 3 WHERE id = 0;", tableName))
+	dbInsert(ctx, t, tableName, [][]interface{}{{4, "5", 3}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
+
+// TestShardedCapture verifies that when a capture is split into shards by key range,
+// every backfilled row and replicated change is emitted by exactly one of them.
+func TestShardedCapture(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var ranges = []airbyte.Range{{Begin: 0, End: 0x7FFFFFFF}, {Begin: 0x80000000, End: 0xFFFFFFFF}}
+	t.Cleanup(func() {
+		for _, r := range ranges {
+			dbQuery(ctx, t, `SELECT pg_drop_replication_slot(slot_name) FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`, shardSlotName(cfg.SlotName, r))
+		}
+	})
+
+	var catalogs = make([]airbyte.ConfiguredCatalog, len(ranges))
+	var states = make([]sqlcapture.PersistentState, len(ranges))
+	for idx, r := range ranges {
+		catalogs[idx] = testCatalog(tableName)
+		catalogs[idx].Range = r
+	}
+	var captureShards = func() map[int]int {
+		var counts = make(map[int]int)
+		for idx := range ranges {
+			var result, _ = performCapture(ctx, t, &cfg, &catalogs[idx], &states[idx])
+			for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+				var msg airbyte.Message
+				if err := json.Unmarshal([]byte(line), &msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Type != airbyte.MessageTypeRecord {
+					continue
+				}
+				var record struct{ ID int }
+				if err := json.Unmarshal(msg.Record.Data, &record); err != nil {
+					t.Fatal(err)
+				}
+				counts[record.ID]++
+			}
+		}
+		return counts
+	}
+
+	var rows [][]interface{}
+	for id := 0; id < 20; id++ {
+		rows = append(rows, []interface{}{id, fmt.Sprintf("row %d", id)})
+	}
+	dbInsert(ctx, t, tableName, rows)
+	var backfilled = captureShards()
+	dbInsert(ctx, t, tableName, [][]interface{}{{100, "more"}, {101, "rows"}, {102, "here"}})
+	dbQuery(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'updated' WHERE id < 5;", tableName))
+	var replicated = captureShards()
+
+	for id := 0; id < 20; id++ {
+		if backfilled[id] != 1 {
+			t.Errorf("row %d backfilled %d times, expected once", id, backfilled[id])
+		}
+	}
+	for _, id := range []int{0, 1, 2, 3, 4, 100, 101, 102} {
+		if replicated[id] != 1 {
+			t.Errorf("change to row %d replicated %d times, expected once", id, replicated[id])
+		}
+	}
+}
+
+// TestHeartbeats verifies that while a tailing capture is idle, heartbeat writes
+// cause the replication cursor to advance and be checkpointed.
+func TestHeartbeats(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	cfg.HeartbeatInterval = 1
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+
+	// Tail the idle table for long enough that several heartbeats are written.
+	catalog.Tail = true
+	var captureCtx, cancelCapture = context.WithTimeout(ctx, 3500*time.Millisecond)
+	defer cancelCapture()
+	var output = new(cursorRecorder)
+	if err := RunCapture(captureCtx, &cfg, &catalog, &state, output); err != nil && captureCtx.Err() == nil {
+		t.Fatal(err)
+	}
+
+	var distinct = make(map[string]bool)
+	for _, cursor := range output.Cursors {
+		distinct[cursor] = true
+	}
+	if len(distinct) < 2 {
+		t.Errorf("expected heartbeats to advance the cursor, got %q", output.Cursors)
+	}
+}
+
+// A cursorRecorder receives the stream of output messages from a capture, and
+// records the replication cursor of every state update.
+type cursorRecorder struct {
+	Cursors []string
+}
+
+func (r *cursorRecorder) Encode(v interface{}) error {
+	var msg, ok = v.(airbyte.Message)
+	if !ok || msg.Type != airbyte.MessageTypeState {
+		return nil
+	}
+	var state sqlcapture.PersistentState
+	if err := json.Unmarshal(msg.State.Data, &state); err != nil {
+		return err
+	}
+	r.Cursors = append(r.Cursors, state.Cursor)
+	return nil
+}
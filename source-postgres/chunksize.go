@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// These parameters control how backfill chunk sizes are adapted. Each chunk
+// is sized to take roughly `targetChunkLatency` to query and to return roughly
+// `targetChunkBytes` of row data, whichever is smaller, and the size changes
+// by a bounded factor after each chunk so that a few unusually slow queries or
+// unusually large rows don't cause wild swings.
+const (
+	targetChunkBytes   = 16 * 1024 * 1024
+	targetChunkLatency = 5 * time.Second
+	minChunkSizeFactor = 0.25
+	maxChunkSizeFactor = 2.0
+)
+
+// maxBackfillChunkSize is the upper limit on adaptive chunk sizes. In normal use
+// it acts like a constant, it's just a variable here so that it can be lowered
+// in tests to keep chunking behavior predictable.
+var maxBackfillChunkSize = 1 << 18
+
+// A chunkSizer decides how many rows to request in each backfill query of a table.
+// Tables with a configured chunk size always use that, while all other tables start
+// from the default size and adapt it according to the observed results of each query.
+type chunkSizer struct {
+	defaultSize int
+	fixed       map[string]int // Configured chunk sizes, which are never adapted
+	adaptive    map[string]int // The current chunk size of each other table
+}
+
+func newChunkSizer(defaultSize int, fixed map[string]int) *chunkSizer {
+	var sizer = &chunkSizer{
+		defaultSize: defaultSize,
+		fixed:       make(map[string]int),
+		adaptive:    make(map[string]int),
+	}
+	for streamID, size := range fixed {
+		sizer.fixed[streamID] = size
+	}
+	return sizer
+}
+
+// ChunkSize returns the number of rows which should be requested from the table.
+func (s *chunkSizer) ChunkSize(streamID string) int {
+	if size, ok := s.fixed[streamID]; ok {
+		return size
+	}
+	if size, ok := s.adaptive[streamID]; ok {
+		return size
+	}
+	return s.defaultSize
+}
+
+// Observe records the result of a backfill query which requested `limit` rows and
+// received `rows` rows totalling `bytes` bytes, taking `latency` time to complete.
+func (s *chunkSizer) Observe(streamID string, limit, rows, bytes int, latency time.Duration) {
+	if _, ok := s.fixed[streamID]; ok {
+		return
+	}
+	// A partial chunk only means that the end of the table was reached, and says
+	// little about how long a full chunk would take.
+	if rows < limit || rows == 0 {
+		return
+	}
+
+	var factor = maxChunkSizeFactor
+	if bytes > 0 && float64(targetChunkBytes)/float64(bytes) < factor {
+		factor = float64(targetChunkBytes) / float64(bytes)
+	}
+	if latency > 0 && float64(targetChunkLatency)/float64(latency) < factor {
+		factor = float64(targetChunkLatency) / float64(latency)
+	}
+	if factor < minChunkSizeFactor {
+		factor = minChunkSizeFactor
+	}
+
+	var size = int(float64(limit) * factor)
+	if size < 1 {
+		size = 1
+	}
+	if size > maxBackfillChunkSize {
+		size = maxBackfillChunkSize
+	}
+	if size != limit {
+		logrus.WithFields(logrus.Fields{
+			"stream":  streamID,
+			"prev":    limit,
+			"next":    size,
+			"bytes":   bytes,
+			"latency": latency.String(),
+		}).Debug("adjusted backfill chunk size")
+	}
+	s.adaptive[streamID] = size
+}
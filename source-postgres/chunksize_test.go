@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunkSizer(t *testing.T) {
+	var prevMax = maxBackfillChunkSize
+	maxBackfillChunkSize = 1000
+	defer func() { maxBackfillChunkSize = prevMax }()
+
+	var sizer = newChunkSizer(100, map[string]int{"public.Fixed": 10})
+	for _, tc := range []struct {
+		stream  string
+		rows    int
+		bytes   int
+		latency time.Duration
+		expect  int
+	}{
+		{"public.fast", 100, 1000, time.Millisecond, 200},                // Small and fast chunks grow
+		{"public.fast", 200, 2000, time.Millisecond, 400},                // ...by a bounded factor
+		{"public.fast", 50, 500, time.Millisecond, 400},                  // Partial chunks are ignored
+		{"public.wide", 100, targetChunkBytes * 2, time.Millisecond, 50}, // Large rows shrink the chunk
+		{"public.slow", 100, 1000, targetChunkLatency * 10, 25},          // Slow queries shrink the chunk by a bounded factor
+		{"public.Fixed", 10, targetChunkBytes * 2, time.Millisecond, 10}, // Configured sizes never change
+	} {
+		sizer.Observe(tc.stream, sizer.ChunkSize(tc.stream), tc.rows, tc.bytes, tc.latency)
+		if size := sizer.ChunkSize(tc.stream); size != tc.expect {
+			t.Errorf("stream %q: expected chunk size %d, got %d", tc.stream, tc.expect, size)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		sizer.Observe("public.fast", sizer.ChunkSize("public.fast"), sizer.ChunkSize("public.fast"), 1000, time.Millisecond)
+	}
+	if size := sizer.ChunkSize("public.fast"); size != maxBackfillChunkSize {
+		t.Errorf("expected chunk size to be limited to %d, got %d", maxBackfillChunkSize, size)
+	}
+}
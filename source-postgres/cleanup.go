@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/estuary/protocols/airbyte"
+	"github.com/jackc/pgx/v4"
+	"github.com/jessevdk/go-flags"
+	"github.com/sirupsen/logrus"
+)
+
+// cleanupCmd is the `cleanup` subcommand, which removes the database objects created
+// by a capture once it has been deleted. Replication slots in particular retain WAL
+// indefinitely until they're dropped.
+type cleanupCmd struct {
+	airbyte.ConfigFile
+	DropTables bool `long:"drop-tables" description:"Drop the watermarks and heartbeat tables even if other captures still have rows in them"`
+}
+
+// runCleanupCommand parses the arguments of the `cleanup` subcommand and runs it.
+// This function will not return.
+func runCleanupCommand(args []string) {
+	var cmd cleanupCmd
+	if _, err := flags.ParseArgs(&cmd, args); err != nil {
+		os.Exit(1)
+	}
+	var config Config
+	if err := cmd.ConfigFile.Parse(&config); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+	if err := cleanup(context.Background(), &config, cmd.DropTables); err != nil {
+		fmt.Fprintln(os.Stderr, "Error: ", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// cleanup drops the replication slot (including those of any capture shards) and
+// publication of the configured capture, and deletes its rows from the watermarks
+// and heartbeat tables. Since those tables may be shared with other captures they
+// are only dropped once empty, or if dropTables is set. Objects which don't exist
+// are skipped, but an active slot is an error since the capture may still be running.
+func cleanup(ctx context.Context, config *Config, dropTables bool) error {
+	var conn, err = pgx.Connect(ctx, config.ConnectionURI)
+	if err != nil {
+		return fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var slots []string
+	var shardSlot = regexp.MustCompile("^" + regexp.QuoteMeta(config.SlotName) + "_[0-9a-f]{8}_[0-9a-f]{8}$")
+	var slotName string
+	var active bool
+	if _, err := conn.QueryFunc(ctx, `SELECT slot_name, active FROM pg_catalog.pg_replication_slots;`, nil,
+		[]interface{}{&slotName, &active},
+		func(r pgx.QueryFuncRow) error {
+			if slotName != config.SlotName && !shardSlot.MatchString(slotName) {
+				return nil
+			}
+			if active {
+				return fmt.Errorf("replication slot %q is in use, stop the capture before cleaning it up", slotName)
+			}
+			slots = append(slots, slotName)
+			return nil
+		}); err != nil {
+		return fmt.Errorf("error listing replication slots: %w", err)
+	}
+	for _, slot := range slots {
+		logrus.WithField("slot", slot).Info("dropping replication slot")
+		if _, err := conn.Exec(ctx, `SELECT pg_drop_replication_slot($1);`, slot); err != nil {
+			return fmt.Errorf("error dropping replication slot %q: %w", slot, err)
+		}
+	}
+
+	logrus.WithField("publication", config.PublicationName).Info("dropping publication")
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`DROP PUBLICATION IF EXISTS %s;`, quoteIdentifier(config.PublicationName))); err != nil {
+		return fmt.Errorf("error dropping publication %q: %w", config.PublicationName, err)
+	}
+	for _, table := range []string{config.WatermarksTable, config.HeartbeatTable} {
+		if err := cleanupSlotTable(ctx, conn, table, dropTables, func(slot string) bool {
+			return slot == config.SlotName || shardSlot.MatchString(slot)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanupSlotTable deletes the rows of a table keyed by slot name which belong to the
+// capture, as decided by the ownSlot function, and then drops the table if no rows
+// of other captures remain in it or if dropTable is set.
+func cleanupSlotTable(ctx context.Context, conn *pgx.Conn, table string, dropTable bool, ownSlot func(string) bool) error {
+	var log = logrus.WithField("table", table)
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, quoteStreamID(table)).Scan(&exists); err != nil {
+		return fmt.Errorf("error querying table %q: %w", table, err)
+	} else if !exists {
+		return nil
+	}
+
+	var slots, others []string
+	var slot string
+	if _, err := conn.QueryFunc(ctx, fmt.Sprintf(`SELECT slot FROM %s;`, quoteStreamID(table)), nil,
+		[]interface{}{&slot},
+		func(r pgx.QueryFuncRow) error {
+			if ownSlot(slot) {
+				slots = append(slots, slot)
+			} else {
+				others = append(others, slot)
+			}
+			return nil
+		}); err != nil {
+		return fmt.Errorf("error listing slots of table %q: %w", table, err)
+	}
+	if len(slots) > 0 {
+		log.WithField("slots", slots).Info("deleting rows of replication slots")
+		if _, err := conn.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE slot = ANY($1);`, quoteStreamID(table)), slots); err != nil {
+			return fmt.Errorf("error deleting rows from table %q: %w", table, err)
+		}
+	}
+
+	if len(others) > 0 && !dropTable {
+		log.WithField("slots", others).Info("not dropping table which is still used by other slots")
+		return nil
+	}
+	log.Info("dropping table")
+	if _, err := conn.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, quoteStreamID(table))); err != nil {
+		return fmt.Errorf("error dropping table %q: %w", table, err)
+	}
+	return nil
+}
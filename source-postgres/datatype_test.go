@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/protocols/airbyte"
 )
 
@@ -133,7 +134,7 @@ func TestDatatypes(t *testing.T) {
 					t.Errorf("column type %q: no stream named %q discovered", tc.ColumnType, table)
 					return
 				}
-				var expectedSchema = fmt.Sprintf(`{"properties":{"a":{"type":"integer"},"b":%s},"required":["a"],"type":"object"}`, tc.OutputType)
+				var expectedSchema = fmt.Sprintf(`{"properties":{"_meta":%s,"a":{"type":"integer"},"b":%s},"required":["a"],"type":"object"}`, metadataSchema, tc.OutputType)
 				if string(stream.JSONSchema) != expectedSchema {
 					t.Errorf("column type %q did not produce expected schema: %s", tc.ColumnType, expectedSchema)
 					t.Errorf("column type %q resulted in schema: %s", tc.ColumnType, stream.JSONSchema)
@@ -143,7 +144,7 @@ func TestDatatypes(t *testing.T) {
 
 			// Insert a test row and scan it back out, then do the same via replication
 			t.Run("roundtrip", func(t *testing.T) {
-				var catalog, state = testCatalog(table), PersistentState{}
+				var catalog, state = testCatalog(table), sqlcapture.PersistentState{}
 
 				t.Run("scan", func(t *testing.T) {
 					dbQuery(ctx, t, fmt.Sprintf(`INSERT INTO %s VALUES (1, %s);`, table, tc.ColumnValue))
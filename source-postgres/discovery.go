@@ -5,11 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/protocols/airbyte"
 	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
 )
 
+// metadataSchema is the JSON schema of the `_meta` property of every record,
+// which is populated by the capture.
+const metadataSchema = `{"type":"object","description":"Metadata describing the source and nature of this change",` +
+	`"properties":{` +
+	`"op":{"type":"string","enum":["Backfill","Insert","Update","Delete","Poll"],"description":"The operation which produced this record"},` +
+	`"schema":{"type":"string","description":"The schema of the source table"},` +
+	`"table":{"type":"string","description":"The name of the source table"},` +
+	`"lsn":{"type":"string","description":"The commit LSN of the transaction, for replicated changes"},` +
+	`"txid":{"type":"integer","description":"The ID of the transaction, for replicated changes"},` +
+	`"commit_ts":{"type":"string","format":"date-time","description":"The commit time of the transaction, for replicated changes"},` +
+	`"before":{"type":"object","description":"The previous values of the row, for updates and deletes when available"},` +
+	`"unchanged_toast":{"type":"array","items":{"type":"string"},"description":"Columns omitted from this update because their large (TOASTed) values were unchanged, and should be merged from the previous document"}` +
+	`},"required":["op","schema","table"]}`
+
 // DiscoverCatalog queries the database and generates an Airbyte Catalog
 // describing the available tables and their columns.
 func DiscoverCatalog(ctx context.Context, config Config) (*airbyte.Catalog, error) {
@@ -19,33 +34,58 @@ func DiscoverCatalog(ctx context.Context, config Config) (*airbyte.Catalog, erro
 	}
 	defer conn.Close(ctx)
 
-	tables, err := getDatabaseTables(ctx, conn)
+	tables, err := getDatabaseTables(ctx, conn, newDiscoveryFilter(&config))
 	if err != nil {
 		return nil, err
 	}
 
+	// Columns excluded by the configuration of a table are omitted from its schema.
+	var keyColumns = make(map[string][]string)
+	for _, table := range tables {
+		keyColumns[sqlcapture.JoinStreamID(table.Schema, table.Name)] = table.PrimaryKey
+	}
+	var tableOpts = newTableOptions(config.Tables, keyColumns)
+
 	var catalog = new(airbyte.Catalog)
 	for _, table := range tables {
+		var streamID = sqlcapture.JoinStreamID(table.Schema, table.Name)
 		logrus.WithFields(logrus.Fields{
 			"table":      table.Name,
 			"namespace":  table.Schema,
 			"primaryKey": table.PrimaryKey,
 		}).Debug("discovered table")
+		checkReplicaIdentity(table.Schema+"."+table.Name, table.ReplicaIdentity, len(table.PrimaryKey) > 0)
 
 		var fields = make(map[string]json.RawMessage)
 		for _, column := range table.Columns {
+			if !tableOpts.includesColumn(streamID, column.Name) {
+				continue
+			}
 			var jsonType, ok = postgresTypeToJSON[column.DataType]
 			if !ok {
 				return nil, fmt.Errorf("cannot translate PostgreSQL column type %q to JSON schema", column.DataType)
 			}
-			if column.IsNullable && jsonType != "{}" {
+			if transform := tableOpts.transform(streamID, column.Name); transform != nil {
+				jsonType = transform.JSONSchema(jsonType, column.IsNullable)
+			} else if column.IsNullable && jsonType != "{}" {
 				jsonType = fmt.Sprintf(`{"anyOf":[%s,{"type":"null"}]}`, jsonType)
 			}
 			fields[column.Name] = json.RawMessage(jsonType)
 		}
+
+		// Keyless tables are captured with a synthetic change ID for each record,
+		// which serves as the key of the resulting (delta-style) collection.
+		var primaryKey = table.PrimaryKey
+		if len(primaryKey) == 0 {
+			fields[sqlcapture.ChangeIDProperty] = json.RawMessage(`{"type":"string","description":"Synthetic identifier of this change, as the source table has no primary key"}`)
+			primaryKey = []string{sqlcapture.ChangeIDProperty}
+		}
+
+		fields[sqlcapture.MetadataProperty] = json.RawMessage(metadataSchema)
+
 		var schema, err = json.Marshal(map[string]interface{}{
 			"type":       "object",
-			"required":   table.PrimaryKey,
+			"required":   primaryKey,
 			"properties": fields,
 		})
 		if err != nil {
@@ -60,7 +100,7 @@ func DiscoverCatalog(ctx context.Context, config Config) (*airbyte.Catalog, erro
 		}).Debug("translated table schema")
 
 		var sourceDefinedPrimaryKey [][]string
-		for _, colName := range table.PrimaryKey {
+		for _, colName := range primaryKey {
 			sourceDefinedPrimaryKey = append(sourceDefinedPrimaryKey, []string{colName})
 		}
 
@@ -73,6 +113,12 @@ func DiscoverCatalog(ctx context.Context, config Config) (*airbyte.Catalog, erro
 			SourceDefinedPrimaryKey: sourceDefinedPrimaryKey,
 		})
 	}
+	if opts := newMessageOptions(&config); opts != nil {
+		catalog.Streams = append(catalog.Streams, discoverMessagesStream(opts))
+	}
+	if config.SchemaChangesStream != "" {
+		catalog.Streams = append(catalog.Streams, sqlcapture.SchemaChangesStream(config.SchemaChangesStream))
+	}
 	return catalog, err
 }
 
@@ -136,6 +182,8 @@ type tableInfo struct {
 	Schema     string       // The PostgreSQL schema (a namespace, in normal parlance) which contains the table.
 	Columns    []columnInfo // Information about each column of the table.
 	PrimaryKey []string     // An ordered list of the column names which together form the table's primary key.
+
+	ReplicaIdentity string // The REPLICA IDENTITY setting of the table, as a `pg_class.relreplident` value.
 }
 
 // columnInfo represents a specific column of a specific table in PostgreSQL,
@@ -150,9 +198,9 @@ type columnInfo struct {
 }
 
 // getDatabaseTables queries the database to produce a list of all tables
-// (with the exception of some internal system schemas) with information
-// about their column types and primary key.
-func getDatabaseTables(ctx context.Context, conn *pgx.Conn) ([]tableInfo, error) {
+// (with the exception of some internal system schemas, and those excluded
+// by the filter) with information about their column types and primary key.
+func getDatabaseTables(ctx context.Context, conn *pgx.Conn, filter *discoveryFilter) ([]tableInfo, error) {
 	// Get lists of all columns and primary keys in the database
 	var columns, err = getColumns(ctx, conn)
 	if err != nil {
@@ -162,12 +210,19 @@ func getDatabaseTables(ctx context.Context, conn *pgx.Conn) ([]tableInfo, error)
 	if err != nil {
 		return nil, fmt.Errorf("unable to list database primary keys: %w", err)
 	}
+	replicaIdentities, err := getReplicaIdentities(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list table replica identities: %w", err)
+	}
 
 	// Aggregate column and primary key information into TableInfo structs
 	// using a map from fully-qualified "<schema>.<name>" table names to
 	// the corresponding TableInfo.
 	var tableMap = make(map[string]*tableInfo)
 	for _, column := range columns {
+		if !filter.includesTable(column.TableSchema, column.TableName) {
+			continue
+		}
 		var id = column.TableSchema + "." + column.TableName
 		if _, ok := tableMap[id]; !ok {
 			tableMap[id] = &tableInfo{Schema: column.TableSchema, Name: column.TableName}
@@ -175,14 +230,21 @@ func getDatabaseTables(ctx context.Context, conn *pgx.Conn) ([]tableInfo, error)
 		tableMap[id].Columns = append(tableMap[id].Columns, column)
 	}
 	for id, key := range primaryKeys {
-		// The `getColumns()` query implements the "exclude system schemas" logic,
-		// so here we ignore primary key information for tables we don't care about.
+		// The `getColumns()` query implements the "exclude system schemas" logic and
+		// the filter was applied to columns, so here we ignore primary key information
+		// for tables we don't care about.
 		if _, ok := tableMap[id]; !ok {
 			continue
 		}
 		logrus.WithFields(logrus.Fields{"table": id, "key": key}).Debug("queried primary key")
 		tableMap[id].PrimaryKey = key
 	}
+	for id, identity := range replicaIdentities {
+		if _, ok := tableMap[id]; !ok {
+			continue
+		}
+		tableMap[id].ReplicaIdentity = identity
+	}
 
 	// Now that aggregation is complete, discard map keys and return
 	// just the list of TableInfo structs.
@@ -193,12 +255,23 @@ func getDatabaseTables(ctx context.Context, conn *pgx.Conn) ([]tableInfo, error)
 	return tables, nil
 }
 
+// queryDiscoverColumns lists the columns of every table, view, and foreign table
+// which the user can select from. It's equivalent to a query of the view
+// `information_schema.columns`, but queries the system catalogs directly since
+// the view is very slow on databases with many tables. Columns of domain types
+// are reported with the name of the domain's base type, as `udt_name` would be.
 const queryDiscoverColumns = `
-  SELECT table_schema, table_name, ordinal_position, column_name, is_nullable::boolean, udt_name
-  FROM information_schema.columns
-  WHERE table_schema != 'pg_catalog' AND table_schema != 'information_schema'
-        AND table_schema != 'pg_internal' AND table_schema != 'catalog_history'
-  ORDER BY table_schema, table_name, ordinal_position;`
+  SELECT n.nspname, c.relname, a.attnum, a.attname, NOT a.attnotnull, COALESCE(bt.typname, t.typname)
+  FROM pg_catalog.pg_attribute a
+    JOIN pg_catalog.pg_class c ON (a.attrelid = c.oid)
+    JOIN pg_catalog.pg_namespace n ON (c.relnamespace = n.oid)
+    JOIN pg_catalog.pg_type t ON (a.atttypid = t.oid)
+    LEFT JOIN pg_catalog.pg_type bt ON (t.typtype = 'd' AND t.typbasetype = bt.oid)
+  WHERE a.attnum > 0 AND NOT a.attisdropped
+        AND c.relkind IN ('r', 'p', 'v', 'f')
+        AND n.nspname NOT IN ('pg_catalog', 'information_schema', 'pg_internal', 'catalog_history')
+        AND has_column_privilege(c.oid, a.attnum, 'SELECT, INSERT, UPDATE, REFERENCES')
+  ORDER BY n.nspname, c.relname, a.attnum;`
 
 func getColumns(ctx context.Context, conn *pgx.Conn) ([]columnInfo, error) {
 	var columns []columnInfo
@@ -254,3 +327,67 @@ func getPrimaryKeys(ctx context.Context, conn *pgx.Conn) (map[string][]string, e
 		})
 	return keys, err
 }
+
+// The possible values of `pg_class.relreplident`, describing which columns of the
+// previous row are included in the replication stream for updates and deletes.
+const (
+	replicaIdentityDefault = "d" // The primary key columns, if there is a primary key.
+	replicaIdentityNothing = "n" // No columns.
+	replicaIdentityFull    = "f" // All columns.
+	replicaIdentityIndex   = "i" // The columns of a specific unique index.
+)
+
+const queryDiscoverReplicaIdentities = `
+  SELECT n.nspname, c.relname, c.relreplident::text
+  FROM pg_catalog.pg_class c
+    JOIN pg_catalog.pg_namespace n ON (c.relnamespace = n.oid)
+  WHERE c.relkind IN ('r', 'p');`
+
+// getReplicaIdentities queries the database to produce a map from fully-qualified
+// "<schema>.<name>" table names to their replica identity settings.
+func getReplicaIdentities(ctx context.Context, conn *pgx.Conn) (map[string]string, error) {
+	var identities = make(map[string]string)
+	var tableSchema, tableName, identity string
+	var _, err = conn.QueryFunc(ctx, queryDiscoverReplicaIdentities, nil,
+		[]interface{}{&tableSchema, &tableName, &identity},
+		func(r pgx.QueryFuncRow) error {
+			identities[fmt.Sprintf("%s.%s", tableSchema, tableName)] = identity
+			return nil
+		})
+	return identities, err
+}
+
+// replicaIdentityProblem describes the consequences of a table's replica identity
+// setting for the capture, or returns the empty string if there are none. A problem
+// is severe if updates and deletes to the table can't be replicated at all, rather
+// than only lacking complete previous values.
+func replicaIdentityProblem(identity string, hasPrimaryKey bool) (problem string, severe bool) {
+	switch {
+	case identity == replicaIdentityFull:
+		// All columns of the previous row are available, nothing to worry about.
+		return "", false
+	case identity == replicaIdentityNothing, identity == replicaIdentityDefault && !hasPrimaryKey:
+		return "table has no replica identity, so updates and deletes to it will fail while it is published", true
+	case identity == replicaIdentityDefault:
+		return "deletes will only capture the primary key of the deleted row, and updates will not capture previous values", false
+	case identity == replicaIdentityIndex:
+		return "deletes will only capture the replica identity index columns of the deleted row, and updates will not capture previous values", false
+	default:
+		return fmt.Sprintf("unknown replica identity setting %q", identity), false
+	}
+}
+
+// checkReplicaIdentity logs the consequences of a table's replica identity setting
+// for the capture. A table which can't replicate updates and deletes at all gets a
+// warning, while one which will only provide partial previous values for them gets
+// an informational message.
+func checkReplicaIdentity(streamID, identity string, hasPrimaryKey bool) {
+	var log = logrus.WithFields(logrus.Fields{"table": streamID, "replicaIdentity": identity})
+	if problem, severe := replicaIdentityProblem(identity, hasPrimaryKey); problem == "" {
+		return
+	} else if severe {
+		log.Warn(problem + " (use `ALTER TABLE ... REPLICA IDENTITY FULL` to fix this)")
+	} else {
+		log.Info(problem + " (use `ALTER TABLE ... REPLICA IDENTITY FULL` to capture complete rows)")
+	}
+}
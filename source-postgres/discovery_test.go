@@ -21,6 +21,19 @@ func TestDiscoverySimple(t *testing.T) {
 	verifyStream(t, "", catalog, tableName)
 }
 
+func TestDiscoveryKeyless(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(a INTEGER, b TEXT)")
+
+	// Tables without a primary key should be discovered with the synthetic
+	// change ID property as their key.
+	var catalog, err = DiscoverCatalog(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyStream(t, "", catalog, tableName)
+}
+
 // verifyStream is a helper function which locates a particular stream by name in
 // the discovered catalog and then uses verifySnapshot on that. This is necessary
 // because we don't want to make assumptions about what other tables might be
@@ -42,3 +55,34 @@ func verifyStream(t *testing.T, suffix string, catalog *airbyte.Catalog, expecte
 	}
 	t.Fatalf("test stream %q not found in catalog", expectedStream)
 }
+
+func TestDiscoveryFilters(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var includedTable = createTestTable(ctx, t, "one", "(a INTEGER PRIMARY KEY, b TEXT)")
+	createTestTable(ctx, t, "two", "(a INTEGER PRIMARY KEY, b TEXT)")
+	createTestTable(ctx, t, "three", "(a INTEGER PRIMARY KEY, b TEXT)")
+
+	// Only tables matching an inclusion pattern and no exclusion pattern
+	// should be discovered.
+	cfg.IncludeSchemas = []string{"pub*"}
+	cfg.IncludeTables = []string{"public.test_discoveryfilters_*"}
+	cfg.ExcludeTables = []string{"*.test_discoveryfilters_t[wh]*"}
+	var catalog, err = DiscoverCatalog(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Streams) != 1 || catalog.Streams[0].Name != includedTable {
+		var names []string
+		for _, stream := range catalog.Streams {
+			names = append(names, stream.Name)
+		}
+		t.Fatalf("expected only stream %q to be discovered, got %q", includedTable, names)
+	}
+
+	cfg.ExcludeSchemas = []string{"public"}
+	if catalog, err = DiscoverCatalog(ctx, cfg); err != nil {
+		t.Fatal(err)
+	} else if len(catalog.Streams) != 0 {
+		t.Fatalf("expected no streams to be discovered, got %d", len(catalog.Streams))
+	}
+}
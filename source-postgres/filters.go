@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+// discoveryFilter restricts which schemas and tables are discovered, according
+// to lists of glob patterns (as understood by `path.Match`). Schema patterns are
+// matched against schema names, while table patterns are matched against the
+// fully-qualified "<schema>.<table>" names of tables. Exclusions take precedence
+// over inclusions, and an empty inclusion list includes everything.
+type discoveryFilter struct {
+	includeSchemas []string
+	excludeSchemas []string
+	includeTables  []string
+	excludeTables  []string
+}
+
+func newDiscoveryFilter(config *Config) *discoveryFilter {
+	return &discoveryFilter{
+		includeSchemas: config.IncludeSchemas,
+		excludeSchemas: config.ExcludeSchemas,
+		includeTables:  config.IncludeTables,
+		excludeTables:  config.ExcludeTables,
+	}
+}
+
+// includesSchema returns true if tables of the named schema may be discovered.
+func (f *discoveryFilter) includesSchema(schemaName string) bool {
+	return matchesFilter(f.includeSchemas, f.excludeSchemas, schemaName)
+}
+
+// includesTable returns true if the named table should be discovered.
+func (f *discoveryFilter) includesTable(schemaName, tableName string) bool {
+	return f.includesSchema(schemaName) && matchesFilter(f.includeTables, f.excludeTables, schemaName+"."+tableName)
+}
+
+func matchesFilter(include, exclude []string, name string) bool {
+	if matchesAny(exclude, name) {
+		return false
+	}
+	return len(include) == 0 || matchesAny(include, name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated along with the rest of the config, so errors
+		// can't happen here.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePatterns checks that every pattern of a list is well-formed.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
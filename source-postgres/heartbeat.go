@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// The possible heartbeat methods. Table heartbeats require write access to the
+// heartbeat table, while message heartbeats require the REPLICATION privilege.
+const (
+	heartbeatMethodTable   = "table"
+	heartbeatMethodMessage = "message"
+)
+
+// heartbeatMessagePrefix is the prefix of the logical decoding messages written
+// as heartbeats.
+const heartbeatMessagePrefix = "flow_heartbeat"
+
+// WriteHeartbeat performs a write whose commit will appear in the replication
+// stream, either by upserting the current time into the heartbeat table or by
+// emitting a transactional logical decoding message.
+func (db *postgresDatabase) WriteHeartbeat(ctx context.Context) error {
+	if db.config.HeartbeatMethod == heartbeatMethodMessage {
+		if _, err := db.connScan.Exec(ctx, `SELECT pg_logical_emit_message(true, $1, now()::text);`, heartbeatMessagePrefix); err != nil {
+			return fmt.Errorf("error emitting heartbeat message: %w", err)
+		}
+		return nil
+	}
+
+	var table = quoteStreamID(db.config.HeartbeatTable)
+	if _, err := db.connScan.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot TEXT PRIMARY KEY, heartbeat TIMESTAMPTZ);", table)); err != nil {
+		return fmt.Errorf("error creating heartbeat table: %w", err)
+	}
+	var query = fmt.Sprintf(`INSERT INTO %s (slot, heartbeat) VALUES ($1, now()) ON CONFLICT (slot) DO UPDATE SET heartbeat = now();`, table)
+	if _, err := db.connScan.Exec(ctx, query, db.config.SlotName); err != nil {
+		return fmt.Errorf("error writing heartbeat for slot %q: %w", db.config.SlotName, err)
+	}
+	return nil
+}
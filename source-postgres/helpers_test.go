@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/json"
@@ -13,8 +14,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/protocols/airbyte"
 	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
 )
@@ -48,6 +51,7 @@ func TestMain(m *testing.M) {
 
 	// Tweak some parameters to make things easier to test on a smaller scale
 	backfillChunkSize = 16
+	maxBackfillChunkSize = 16
 	replicationBufferSize = 0
 
 	// Open a connection to the database which will be used for creating and
@@ -90,7 +94,7 @@ func TestMain(m *testing.M) {
 
 // createTestTable is a test helper for creating a new database table and returning the
 // name of the new table. The table is named "test_<testName>", or "test_<testName>_<suffix>"
-// if the suffix is non-empty.
+// if the suffix is non-empty, in lowercase since that's how unquoted names are stored.
 func createTestTable(ctx context.Context, t *testing.T, suffix string, tableDef string) string {
 	t.Helper()
 
@@ -100,6 +104,7 @@ func createTestTable(ctx context.Context, t *testing.T, suffix string, tableDef
 	}
 	tableName = strings.ReplaceAll(tableName, "/", "_")
 	tableName = strings.ReplaceAll(tableName, "=", "_")
+	tableName = strings.ToLower(tableName)
 
 	logrus.WithFields(logrus.Fields{"table": tableName, "cols": tableDef}).Debug("creating test table")
 	dbQueryInternal(ctx, t, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, tableName))
@@ -246,7 +251,7 @@ func dbQueryInternal(ctx context.Context, t *testing.T, query string, args ...in
 // verifiedCapture is a test helper which performs a database capture and automatically
 // verifies the result against a golden snapshot. It returns a list of all states
 // emitted during the capture, and updates the `state` argument to the final one.
-func verifiedCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *PersistentState, suffix string) []PersistentState {
+func verifiedCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState, suffix string) []sqlcapture.PersistentState {
 	t.Helper()
 	var result, states = performCapture(ctx, t, cfg, catalog, state)
 	verifySnapshot(t, suffix, result)
@@ -260,7 +265,7 @@ func verifiedCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *ai
 // test runs, and so can be fed directly into verifySnapshot.
 //
 // As a side effect the input state is modified to the final result state.
-func performCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *PersistentState) (string, []PersistentState) {
+func performCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState) (string, []sqlcapture.PersistentState) {
 	t.Helper()
 
 	// Use a JSON round-trip to deep-copy the state, so that the act of running a
@@ -270,7 +275,7 @@ func performCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *air
 	if err != nil {
 		t.Fatal(err)
 	}
-	var cleanState = new(PersistentState)
+	var cleanState = new(sqlcapture.PersistentState)
 	if err := json.Unmarshal(bs, cleanState); err != nil {
 		t.Fatal(err)
 	}
@@ -291,7 +296,7 @@ func performCapture(ctx context.Context, t *testing.T, cfg *Config, catalog *air
 // Capture instance, recording State updates in one list and Records
 // in another.
 type CaptureOutputBuffer struct {
-	States    []PersistentState
+	States    []sqlcapture.PersistentState
 	Snapshot  strings.Builder
 	lastState string
 }
@@ -320,14 +325,24 @@ func (buf *CaptureOutputBuffer) bufferState(msg airbyte.Message) error {
 	// that because we're unmarshalling each state update from JSON we
 	// can rely on the states being independent and not sharing any
 	// pointer-identity in their 'Streams' map or `ScanRanges` lists.
-	var originalState PersistentState
+	var originalState sqlcapture.PersistentState
 	if err := json.Unmarshal(msg.State.Data, &originalState); err != nil {
 		return fmt.Errorf("error unmarshaling to PersistentState: %w", err)
 	}
 
-	// Sanitize state by rewriting the LSN to a constant, then encode
+	// Sanitize state by rewriting the LSNs to a constant, then encode
 	// back into new bytes.
-	var cleanState = PersistentState{CurrentLSN: 1234, Streams: originalState.Streams}
+	var cleanState = sqlcapture.PersistentState{Cursor: pglogrepl.LSN(1234).String()}
+	if originalState.Streams != nil {
+		cleanState.Streams = make(map[string]*sqlcapture.TableState)
+	}
+	for streamID, tableState := range originalState.Streams {
+		var cleanTableState = *tableState
+		if cleanTableState.SnapshotCursor != "" {
+			cleanTableState.SnapshotCursor = pglogrepl.LSN(1234).String()
+		}
+		cleanState.Streams[streamID] = &cleanTableState
+	}
 	var bs, err = json.Marshal(cleanState)
 	if err != nil {
 		return fmt.Errorf("error encoding cleaned state: %w", err)
@@ -352,13 +367,42 @@ func (buf *CaptureOutputBuffer) bufferState(msg airbyte.Message) error {
 
 func (buf *CaptureOutputBuffer) bufferRecord(msg airbyte.Message) error {
 	buf.lastState = ""
+
+	// Sanitize the replication metadata of the record, which varies across test runs.
+	// The record is decoded with `UseNumber` so that other values are unaffected.
+	var fields map[string]interface{}
+	var dec = json.NewDecoder(bytes.NewReader(msg.Record.Data))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return fmt.Errorf("error decoding record data: %w", err)
+	}
+	if meta, ok := fields[sqlcapture.MetadataProperty].(map[string]interface{}); ok {
+		if _, ok := meta["lsn"]; ok {
+			meta["lsn"] = pglogrepl.LSN(1234).String()
+		}
+		if _, ok := meta["txid"]; ok {
+			meta["txid"] = 1234
+		}
+		if _, ok := meta["commit_ts"]; ok {
+			meta["commit_ts"] = time.Unix(1234, 0).UTC()
+		}
+	}
+	// Captured logical decoding messages are keyed by their LSN, which likewise varies.
+	if _, ok := fields["lsn"].(string); ok {
+		fields["lsn"] = pglogrepl.LSN(1234).String()
+	}
+	var data, err = json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("error encoding sanitized record data: %w", err)
+	}
+
 	return buf.bufferMessage(airbyte.Message{
 		Type: airbyte.MessageTypeRecord,
 		Record: &airbyte.Record{
 			Namespace: msg.Record.Namespace,
 			Stream:    msg.Record.Stream,
 			EmittedAt: 1234, // Replaced because non-reproducible
-			Data:      msg.Record.Data,
+			Data:      json.RawMessage(data),
 		},
 	})
 }
@@ -373,7 +417,7 @@ func (buf *CaptureOutputBuffer) bufferMessage(msg airbyte.Message) error {
 	return nil
 }
 
-func (buf *CaptureOutputBuffer) Output() (string, []PersistentState) {
+func (buf *CaptureOutputBuffer) Output() (string, []sqlcapture.PersistentState) {
 	return buf.Snapshot.String(), buf.States
 }
 
@@ -5,21 +5,51 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/protocols/airbyte"
+	"github.com/jackc/pglogrepl"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	// The `cleanup` subcommand isn't part of the standard connector protocol.
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		runCleanupCommand(os.Args[2:])
+	}
 	airbyte.RunMain(spec, doCheck, doDiscover, doRead)
 }
 
 // Config tells the connector how to connect to the source database and can
 // optionally be used to customize some other parameters such as polling timeout.
 type Config struct {
-	ConnectionURI   string `json:"connectionURI"`
-	SlotName        string `json:"slot_name"`
-	PublicationName string `json:"publication_name"`
-	WatermarksTable string `json:"watermarks_table"`
+	ConnectionURI        string                  `json:"connectionURI"`
+	SlotName             string                  `json:"slot_name"`
+	PublicationName      string                  `json:"publication_name"`
+	WatermarksTable      string                  `json:"watermarks_table"`
+	BackfillMethod       string                  `json:"backfill_method"`
+	SchemaChangePolicy   string                  `json:"schema_change_policy"`
+	SchemaChangesStream  string                  `json:"schema_changes_stream"`
+	UnchangedToastPolicy string                  `json:"unchanged_toast_policy"`
+	SignalTable          string                  `json:"signal_table"`
+	HeartbeatInterval    int                     `json:"heartbeat_interval_seconds"`
+	HeartbeatMethod      string                  `json:"heartbeat_method"`
+	HeartbeatTable       string                  `json:"heartbeat_table"`
+	BackfillChunkSize    int                     `json:"backfill_chunk_size"`
+	BackfillChunkSizes   map[string]int          `json:"backfill_chunk_sizes"`
+	BackfillMemoryLimit  int                     `json:"backfill_memory_limit_mb"`
+	Tables               map[string]*TableConfig `json:"tables"`
+	CaptureMode          string                  `json:"capture_mode"`
+	PollInterval         int                     `json:"poll_interval_seconds"`
+	IncludeSchemas       []string                `json:"include_schemas"`
+	ExcludeSchemas       []string                `json:"exclude_schemas"`
+	IncludeTables        []string                `json:"include_tables"`
+	ExcludeTables        []string                `json:"exclude_tables"`
+	ReplicationFormat    string                  `json:"replication_format"`
+	MessagesStream       string                  `json:"messages_stream"`
+	MessagePrefixes      []string                `json:"message_prefixes"`
+	MessageFormat        string                  `json:"message_format"`
 }
 
 // Validate checks that the configuration passes some basic sanity checks, and
@@ -37,6 +67,114 @@ func (c *Config) Validate() error {
 	if c.WatermarksTable == "" {
 		c.WatermarksTable = "public.flow_watermarks"
 	}
+	switch c.BackfillMethod {
+	case "":
+		c.BackfillMethod = sqlcapture.BackfillMethodWatermarks
+	case sqlcapture.BackfillMethodWatermarks, sqlcapture.BackfillMethodSnapshot:
+	default:
+		return fmt.Errorf("invalid backfill method %q", c.BackfillMethod)
+	}
+	switch c.SchemaChangePolicy {
+	case "":
+		c.SchemaChangePolicy = sqlcapture.SchemaChangeLog
+	case sqlcapture.SchemaChangeLog, sqlcapture.SchemaChangeEvent, sqlcapture.SchemaChangeFail, sqlcapture.SchemaChangeBackfill:
+	default:
+		return fmt.Errorf("invalid schema change policy %q", c.SchemaChangePolicy)
+	}
+	if c.SchemaChangePolicy != sqlcapture.SchemaChangeEvent && c.SchemaChangesStream != "" {
+		return fmt.Errorf("schema changes stream can only be set with the %q schema change policy", sqlcapture.SchemaChangeEvent)
+	} else if c.SchemaChangePolicy == sqlcapture.SchemaChangeEvent && c.SchemaChangesStream == "" {
+		c.SchemaChangesStream = "public.flow_schema_changes"
+	} else if c.SchemaChangesStream != "" && !strings.Contains(c.SchemaChangesStream, ".") {
+		return fmt.Errorf("schema changes stream %q must be of the form <namespace>.<name>", c.SchemaChangesStream)
+	}
+	switch c.UnchangedToastPolicy {
+	case "":
+		c.UnchangedToastPolicy = unchangedToastOmit
+	case unchangedToastOmit, unchangedToastFetch:
+	default:
+		return fmt.Errorf("invalid unchanged TOAST policy %q", c.UnchangedToastPolicy)
+	}
+	if c.HeartbeatInterval < 0 {
+		return fmt.Errorf("heartbeat interval must be non-negative")
+	}
+	switch c.HeartbeatMethod {
+	case "":
+		c.HeartbeatMethod = heartbeatMethodTable
+	case heartbeatMethodTable, heartbeatMethodMessage:
+	default:
+		return fmt.Errorf("invalid heartbeat method %q", c.HeartbeatMethod)
+	}
+	if c.HeartbeatTable == "" {
+		c.HeartbeatTable = "public.flow_heartbeats"
+	}
+	if c.BackfillChunkSize < 0 {
+		return fmt.Errorf("backfill chunk size must be non-negative")
+	} else if c.BackfillChunkSize == 0 {
+		c.BackfillChunkSize = backfillChunkSize
+	}
+	for streamID, size := range c.BackfillChunkSizes {
+		if size <= 0 {
+			return fmt.Errorf("backfill chunk size of table %q must be positive", streamID)
+		}
+	}
+	if c.BackfillMemoryLimit < 0 {
+		return fmt.Errorf("backfill memory limit must be non-negative")
+	} else if c.BackfillMemoryLimit == 0 {
+		c.BackfillMemoryLimit = 256
+	}
+	switch c.CaptureMode {
+	case "":
+		c.CaptureMode = sqlcapture.CaptureModeReplication
+	case sqlcapture.CaptureModeReplication, sqlcapture.CaptureModePolling:
+	default:
+		return fmt.Errorf("invalid capture mode %q", c.CaptureMode)
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("poll interval must be non-negative")
+	} else if c.PollInterval == 0 {
+		c.PollInterval = 60
+	}
+	switch c.ReplicationFormat {
+	case "":
+		c.ReplicationFormat = replicationFormatText
+	case replicationFormatText, replicationFormatBinary:
+	default:
+		return fmt.Errorf("invalid replication format %q", c.ReplicationFormat)
+	}
+	if c.MessagesStream != "" {
+		if !strings.Contains(c.MessagesStream, ".") {
+			return fmt.Errorf("messages stream %q must be of the form <namespace>.<name>", c.MessagesStream)
+		}
+		if c.CaptureMode == sqlcapture.CaptureModePolling {
+			return fmt.Errorf("messages can't be captured in the polling capture mode")
+		}
+	}
+	switch c.MessageFormat {
+	case "":
+		c.MessageFormat = messageFormatText
+	case messageFormatText, messageFormatJSON:
+	default:
+		return fmt.Errorf("invalid message format %q", c.MessageFormat)
+	}
+	for name, patterns := range map[string][]string{
+		"include_schemas": c.IncludeSchemas,
+		"exclude_schemas": c.ExcludeSchemas,
+		"include_tables":  c.IncludeTables,
+		"exclude_tables":  c.ExcludeTables,
+	} {
+		if err := validatePatterns(patterns); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	for streamID, table := range c.Tables {
+		if table == nil {
+			return fmt.Errorf("configuration of table %q must be an object", streamID)
+		}
+		if err := table.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration of table %q: %w", streamID, err)
+		}
+	}
 	return nil
 }
 
@@ -73,6 +211,209 @@ const configSchema = `{
 			"title":       "Watermarks Table",
 			"description": "The name of the table used for watermark writes during backfills",
 			"default":     "public.flow_watermarks"
+		},
+		"backfill_method": {
+			"type":        "string",
+			"title":       "Backfill Method",
+			"description": "How preexisting table contents are backfilled. The 'watermarks' method writes to the watermarks table, while the 'snapshot' method scans tables from an exported snapshot and requires no write access",
+			"enum":        ["watermarks", "snapshot"],
+			"default":     "watermarks"
+		},
+		"schema_change_policy": {
+			"type":        "string",
+			"title":       "Schema Change Policy",
+			"description": "What to do when the columns of a captured table change: 'log' a warning and continue, emit a schema change 'event' record and continue, 'fail' the capture, or 'backfill' the table again",
+			"enum":        ["log", "event", "fail", "backfill"],
+			"default":     "log"
+		},
+		"schema_changes_stream": {
+			"type":        "string",
+			"title":       "Schema Changes Stream",
+			"description": "The stream (of the form <namespace>.<name>) under which the records of the 'event' schema change policy are captured. Only used with that policy",
+			"default":     "public.flow_schema_changes"
+		},
+		"unchanged_toast_policy": {
+			"type":        "string",
+			"title":       "Unchanged TOAST Policy",
+			"description": "What to do with large (TOASTed) column values which an update left unchanged, and which are therefore absent from the replication stream: 'omit' them from the record, or 'fetch' their current values from the table",
+			"enum":        ["omit", "fetch"],
+			"default":     "omit"
+		},
+		"signal_table": {
+			"type":        "string",
+			"title":       "Signal Table",
+			"description": "The name of a table into which rows may be inserted to request that a captured table be backfilled again. Disabled if unset"
+		},
+		"heartbeat_interval_seconds": {
+			"type":        "integer",
+			"title":       "Heartbeat Interval (Seconds)",
+			"description": "How often to write a heartbeat, so that the replication position advances even when no captured tables are changing. Disabled if zero",
+			"default":     0
+		},
+		"heartbeat_method": {
+			"type":        "string",
+			"title":       "Heartbeat Method",
+			"description": "How heartbeats are written. The 'table' method writes to the heartbeat table, while the 'message' method emits a logical decoding message and requires the REPLICATION privilege",
+			"enum":        ["table", "message"],
+			"default":     "table"
+		},
+		"heartbeat_table": {
+			"type":        "string",
+			"title":       "Heartbeat Table",
+			"description": "The name of the table used for heartbeat writes by the 'table' heartbeat method",
+			"default":     "public.flow_heartbeats"
+		},
+		"backfill_chunk_size": {
+			"type":        "integer",
+			"title":       "Backfill Chunk Size",
+			"description": "The number of rows read from a table in its first backfill query. Subsequent queries adapt the chunk size according to the size of the rows and the time taken to read them",
+			"default":     4096
+		},
+		"backfill_chunk_sizes": {
+			"type":        "object",
+			"title":       "Per-Table Backfill Chunk Sizes",
+			"description": "A map from '<schema>.<table>' names to a fixed number of rows read in each backfill query of that table, overriding the adaptive chunk size",
+			"additionalProperties": { "type": "integer", "minimum": 1 }
+		},
+		"backfill_memory_limit_mb": {
+			"type":        "integer",
+			"title":       "Backfill Memory Limit (MB)",
+			"description": "The approximate amount of buffered backfill data held in memory, beyond which it is spilled to a temporary file on disk",
+			"default":     256
+		},
+		"capture_mode": {
+			"type":        "string",
+			"title":       "Capture Mode",
+			"description": "How changes are captured. The 'replication' mode requires logical replication, while the 'polling' mode periodically queries each table for rows whose cursor column has advanced, and can't observe deletions",
+			"enum":        ["replication", "polling"],
+			"default":     "replication"
+		},
+		"poll_interval_seconds": {
+			"type":        "integer",
+			"title":       "Poll Interval (Seconds)",
+			"description": "How often tables are polled for changes in the polling capture mode",
+			"default":     60
+		},
+		"replication_format": {
+			"type":        "string",
+			"title":       "Replication Format",
+			"description": "Whether column values are replicated as 'text', or in 'binary' format where possible, which is faster to decode. Binary replication requires PostgreSQL 14 or later, and falls back to text otherwise",
+			"enum":        ["text", "binary"],
+			"default":     "text"
+		},
+		"messages_stream": {
+			"type":        "string",
+			"title":       "Messages Stream",
+			"description": "The stream (of the form <namespace>.<name>) under which logical decoding messages emitted by pg_logical_emit_message are captured. Requires PostgreSQL 14 or later. Disabled if empty"
+		},
+		"message_prefixes": {
+			"type":        "array",
+			"items":       {"type": "string"},
+			"title":       "Message Prefixes",
+			"description": "The prefixes of the logical decoding messages to capture. All messages are captured if empty"
+		},
+		"message_format": {
+			"type":        "string",
+			"title":       "Message Format",
+			"description": "Whether the content of captured messages is a string ('text'), or parsed as JSON ('json')",
+			"enum":        ["text", "json"],
+			"default":     "text"
+		},
+		"include_schemas": {
+			"type":        "array",
+			"items":       { "type": "string" },
+			"title":       "Include Schemas",
+			"description": "If set, only tables in schemas matching one of these glob patterns are discovered"
+		},
+		"exclude_schemas": {
+			"type":        "array",
+			"items":       { "type": "string" },
+			"title":       "Exclude Schemas",
+			"description": "Tables in schemas matching any of these glob patterns are not discovered"
+		},
+		"include_tables": {
+			"type":        "array",
+			"items":       { "type": "string" },
+			"title":       "Include Tables",
+			"description": "If set, only tables whose '<schema>.<table>' names match one of these glob patterns are discovered"
+		},
+		"exclude_tables": {
+			"type":        "array",
+			"items":       { "type": "string" },
+			"title":       "Exclude Tables",
+			"description": "Tables whose '<schema>.<table>' names match any of these glob patterns are not discovered"
+		},
+		"tables": {
+			"type":        "object",
+			"title":       "Table Options",
+			"description": "A map from '<schema>.<table>' names to options which apply to that table",
+			"additionalProperties": {
+				"type": "object",
+				"properties": {
+					"columns": {
+						"type":        "array",
+						"items":       { "type": "string" },
+						"title":       "Columns",
+						"description": "If set, only these columns (and the primary key) of the table are captured"
+					},
+					"exclude_columns": {
+						"type":        "array",
+						"items":       { "type": "string" },
+						"title":       "Exclude Columns",
+						"description": "Columns of the table which are not captured. Primary key columns are always captured"
+					},
+					"cursor_column": {
+						"type":        "string",
+						"title":       "Cursor Column",
+						"description": "A column whose value increases whenever a row is inserted or updated, such as an 'updated_at' timestamp or a serial ID. Required for every captured table in the polling capture mode"
+					},
+					"backfill_filter": {
+						"type":        "string",
+						"title":       "Backfill Filter",
+						"description": "A SQL predicate restricting which rows of the table are backfilled, such as \"updated_at > now() - interval '90 days'\". Changes to other rows are still captured by replication"
+					},
+					"transforms": {
+						"type":        "object",
+						"title":       "Column Transforms",
+						"description": "A map from column names to transforms applied to the values of that column before they're captured",
+						"additionalProperties": {
+							"type": "object",
+							"properties": {
+								"type": {
+									"type":        "string",
+									"title":       "Transform Type",
+									"description": "Whether to 'drop' the column, replace its values with 'null', 'hash' them with SHA-256, or 'redact' them",
+									"enum":        ["drop", "null", "hash", "redact"]
+								},
+								"salt": {
+									"type":        "string",
+									"title":       "Salt",
+									"description": "A secret prepended to values before they're hashed"
+								},
+								"keep_first": {
+									"type":        "integer",
+									"title":       "Keep First",
+									"description": "The number of leading characters left unredacted",
+									"default":     0
+								},
+								"keep_last": {
+									"type":        "integer",
+									"title":       "Keep Last",
+									"description": "The number of trailing characters left unredacted",
+									"default":     0
+								},
+								"mask": {
+									"type":        "string",
+									"title":       "Mask",
+									"description": "The character which replaces redacted characters",
+									"default":     "*"
+								}
+							},
+							"required": ["type"]
+						}
+					}
+				}
+			}
 		}
 	},
 	"required": [ "connectionURI" ]
@@ -83,10 +424,22 @@ func doCheck(args airbyte.CheckCmd) error {
 	if err := args.ConfigFile.Parse(&config); err != nil {
 		return err
 	}
+	var ctx = context.Background()
 	var result = &airbyte.ConnectionStatus{Status: airbyte.StatusSucceeded}
-	if _, err := DiscoverCatalog(context.Background(), config); err != nil {
+	if catalog, err := DiscoverCatalog(ctx, config); err != nil {
 		result.Status = airbyte.StatusFailed
 		result.Message = err.Error()
+	} else if len(catalog.Streams) == 0 {
+		result.Status = airbyte.StatusFailed
+		result.Message = "no tables were discovered, check the schema and table filters of the configuration"
+	} else if report, err := runPreflight(ctx, &config); err != nil {
+		result.Status = airbyte.StatusFailed
+		result.Message = err.Error()
+	} else {
+		if report.Failed() {
+			result.Status = airbyte.StatusFailed
+		}
+		result.Message = report.Message()
 	}
 	return airbyte.NewStdoutEncoder().Encode(airbyte.Message{
 		Type:             airbyte.MessageTypeConnectionStatus,
@@ -112,10 +465,12 @@ func doDiscover(args airbyte.DiscoverCmd) error {
 func doRead(args airbyte.ReadCmd) error {
 	var ctx = context.Background()
 
-	var state = &PersistentState{Streams: make(map[string]*TableState)}
+	var state = &sqlcapture.PersistentState{Streams: make(map[string]*sqlcapture.TableState)}
 	if args.StateFile != "" {
 		if err := args.StateFile.Parse(state); err != nil {
-			return fmt.Errorf("unable to parse state file: %w", err)
+			if legacyErr := parseLegacyState(args.StateFile, state); legacyErr != nil {
+				return fmt.Errorf("unable to parse state file: %w", err)
+			}
 		}
 	}
 
@@ -131,3 +486,39 @@ func doRead(args airbyte.ReadCmd) error {
 
 	return RunCapture(ctx, config, catalog, state, json.NewEncoder(os.Stdout))
 }
+
+// parseLegacyState parses a state file written before the capture state was
+// generalized across databases, when replication positions were stored as numeric
+// LSNs rather than cursor strings. Such states fail to parse strictly as the current
+// PersistentState, and are translated into it.
+func parseLegacyState(stateFile airbyte.JSONFile, state *sqlcapture.PersistentState) error {
+	var bs, err = os.ReadFile(string(stateFile))
+	if err != nil {
+		return err
+	}
+	var legacy struct {
+		CurrentLSN pglogrepl.LSN `json:"current_lsn"`
+		Streams    map[string]*struct {
+			sqlcapture.TableState
+			SnapshotLSN pglogrepl.LSN `json:"snapshot_lsn"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(bs, &legacy); err != nil {
+		return err
+	}
+	if legacy.CurrentLSN == 0 {
+		return fmt.Errorf("state file has no legacy LSN")
+	}
+
+	state.Cursor = legacy.CurrentLSN.String()
+	state.Streams = make(map[string]*sqlcapture.TableState)
+	for streamID, legacyTableState := range legacy.Streams {
+		var tableState = legacyTableState.TableState
+		if legacyTableState.SnapshotLSN != 0 {
+			tableState.SnapshotCursor = legacyTableState.SnapshotLSN.String()
+		}
+		state.Streams[streamID] = &tableState
+	}
+	logrus.WithField("cursor", state.Cursor).Info("migrated legacy state")
+	return nil
+}
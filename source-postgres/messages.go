@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// The possible values of the `message_format` config option, which controls how
+// the content of captured logical decoding messages is represented.
+const (
+	messageFormatText = "text" // Content is captured as a string.
+	messageFormatJSON = "json" // Content is parsed as JSON, falling back to a string if it isn't valid.
+)
+
+// messagesMinVersion is the first PostgreSQL version (14) whose pgoutput plugin
+// can send logical decoding messages.
+const messagesMinVersion = 140000
+
+// messageOptions describes how logical decoding messages are captured, as the
+// records of a single stream.
+type messageOptions struct {
+	namespace string   // The namespace of the messages stream
+	stream    string   // The name of the messages stream
+	prefixes  []string // The prefixes of the messages to capture, or empty to capture all of them
+	format    string   // How message content is represented
+}
+
+// newMessageOptions returns the message capture options of the configuration, or
+// nil if messages aren't captured.
+func newMessageOptions(config *Config) *messageOptions {
+	if config.MessagesStream == "" {
+		return nil
+	}
+	var parts = strings.SplitN(config.MessagesStream, ".", 2)
+	return &messageOptions{
+		namespace: parts[0],
+		stream:    parts[1],
+		prefixes:  config.MessagePrefixes,
+		format:    config.MessageFormat,
+	}
+}
+
+// includesPrefix returns true if messages with the given prefix are captured.
+// Heartbeat messages are never captured.
+func (opts *messageOptions) includesPrefix(prefix string) bool {
+	if prefix == heartbeatMessagePrefix {
+		return false
+	}
+	if len(opts.prefixes) == 0 {
+		return true
+	}
+	for _, p := range opts.prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// logicalMessageByteID identifies a pgoutput Message message, which is sent for
+// each call to `pg_logical_emit_message` when the `messages` option is enabled.
+const logicalMessageByteID = 'M'
+
+// logicalMessage is a logical decoding message from the replication stream. The
+// pglogrepl package doesn't know about these, so they're parsed here instead.
+type logicalMessage struct {
+	Transactional bool          // True if the message was emitted as part of its transaction
+	LSN           pglogrepl.LSN // The WAL position of the message itself
+	Prefix        string        // The prefix given when the message was emitted
+	Content       []byte        // The content of the message
+}
+
+func (m *logicalMessage) Type() pglogrepl.MessageType {
+	return pglogrepl.MessageType(logicalMessageByteID)
+}
+
+// parseLogicalMessage parses the body of a Message message, following the type
+// byte. It consists of a flags byte, the LSN of the message, a null-terminated
+// prefix, and the length-prefixed content. The content aliases the input buffer.
+func parseLogicalMessage(data []byte) (*logicalMessage, error) {
+	if len(data) < 9 {
+		return nil, fmt.Errorf("message too short (%d bytes)", len(data))
+	}
+	var msg = &logicalMessage{
+		Transactional: data[0]&1 != 0,
+		LSN:           pglogrepl.LSN(binary.BigEndian.Uint64(data[1:9])),
+	}
+	var rest = data[9:]
+	var end = bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("message prefix isn't null-terminated")
+	}
+	msg.Prefix, rest = string(rest[:end]), rest[end+1:]
+	if len(rest) < 4 {
+		return nil, fmt.Errorf("message content length missing")
+	}
+	var length = binary.BigEndian.Uint32(rest)
+	if rest = rest[4:]; uint32(len(rest)) != length {
+		return nil, fmt.Errorf("message content length is %d but %d bytes remain", length, len(rest))
+	}
+	msg.Content = rest
+	return msg, nil
+}
+
+// decodeLogicalMessage decodes a logical decoding message into a change event of
+// the messages stream, or returns nil if the message isn't captured. Each message
+// is captured as an insert keyed by its LSN. Transactional messages are described
+// by the metadata of their transaction like any other change, while those emitted
+// outside of a transaction use their own LSN as their cursor.
+func (s *replicationStream) decodeLogicalMessage(msg *logicalMessage) (*sqlcapture.ChangeEvent, error) {
+	if s.messages == nil || !s.messages.includesPrefix(msg.Prefix) {
+		return nil, nil
+	}
+	if msg.Transactional && !s.inTransaction {
+		return nil, fmt.Errorf("got transactional message without a transaction in progress")
+	}
+
+	// The content must be copied, since the buffer it aliases will be reused.
+	var content interface{} = string(msg.Content)
+	if s.messages.format == messageFormatJSON {
+		if json.Valid(msg.Content) {
+			content = json.RawMessage(append([]byte(nil), msg.Content...))
+		} else {
+			logrus.WithFields(logrus.Fields{"prefix": msg.Prefix, "lsn": msg.LSN}).Warn("message content isn't valid JSON, capturing it as a string")
+		}
+	}
+
+	var event = &sqlcapture.ChangeEvent{
+		Type:      "Insert",
+		Cursor:    msg.LSN.String(),
+		Namespace: s.messages.namespace,
+		Table:     s.messages.stream,
+		Fields: map[string]interface{}{
+			"lsn":           msg.LSN.String(),
+			"prefix":        msg.Prefix,
+			"content":       content,
+			"transactional": msg.Transactional,
+		},
+	}
+	if msg.Transactional {
+		event.Cursor = s.transactionLSN.String()
+		event.Source = s.transactionSource()
+	}
+	return event, nil
+}
+
+// messagesStreamSchema returns the JSON schema of the records of the messages stream.
+func messagesStreamSchema(format string) json.RawMessage {
+	var contentSchema = `{"type":"string","description":"The content of the message"}`
+	if format == messageFormatJSON {
+		contentSchema = `{"description":"The content of the message, parsed as JSON if possible and otherwise a string"}`
+	}
+	return json.RawMessage(`{"type":"object","required":["lsn","prefix","content","transactional"],"properties":{` +
+		`"lsn":{"type":"string","description":"The WAL position of the message, which uniquely identifies it"},` +
+		`"prefix":{"type":"string","description":"The prefix given when the message was emitted"},` +
+		`"content":` + contentSchema + `,` +
+		`"transactional":{"type":"boolean","description":"Whether the message was emitted as part of its transaction, and so only if the transaction committed"},` +
+		`"_meta":` + metadataSchema +
+		`}}`)
+}
+
+// discoverMessagesStream returns the catalog stream of logical decoding messages.
+func discoverMessagesStream(opts *messageOptions) airbyte.Stream {
+	return airbyte.Stream{
+		Name:                    opts.stream,
+		Namespace:               opts.namespace,
+		JSONSchema:              messagesStreamSchema(opts.format),
+		SupportedSyncModes:      airbyte.AllSyncModes,
+		SourceDefinedCursor:     true,
+		SourceDefinedPrimaryKey: [][]string{{"lsn"}},
+	}
+}
+
+// checkMessagesSupport verifies that the server can send logical decoding messages
+// when they're to be captured.
+func checkMessagesSupport(ctx context.Context, conn *pgx.Conn, report *preflightReport) {
+	var serverVersion int
+	if err := conn.QueryRow(ctx, `SELECT current_setting('server_version_num')::integer;`).Scan(&serverVersion); err != nil {
+		report.fail(fmt.Sprintf("unable to query the server version: %v", err), "the capture user must be able to read server settings")
+	} else if serverVersion < messagesMinVersion {
+		report.fail(fmt.Sprintf("capturing logical decoding messages requires PostgreSQL 14 or later, but the server version is %d", serverVersion),
+			"upgrade the server, or unset 'messages_stream'")
+	}
+}
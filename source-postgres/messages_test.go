@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+)
+
+func TestParseLogicalMessage(t *testing.T) {
+	var data = []byte{1}
+	data = append(data, 0, 0, 0, 0, 0x01, 0x6B, 0x37, 0x48) // LSN 0/16B3748
+	data = append(data, []byte("outbox\x00")...)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[len(data)-4:], 7)
+	data = append(data, []byte(`{"a":1}`)...)
+
+	var msg, err = parseLogicalMessage(data)
+	if err != nil {
+		t.Fatalf("error parsing message: %v", err)
+	}
+	if !msg.Transactional || msg.LSN != pglogrepl.LSN(0x16B3748) || msg.Prefix != "outbox" || !bytes.Equal(msg.Content, []byte(`{"a":1}`)) {
+		t.Errorf("incorrectly parsed message: %#v", msg)
+	}
+
+	for _, truncated := range [][]byte{data[:5], data[:12], data[:18], data[:len(data)-1]} {
+		if _, err := parseLogicalMessage(truncated); err == nil {
+			t.Errorf("expected an error parsing truncated message %q", truncated)
+		}
+	}
+}
+
+// TestCaptureMessages emits logical decoding messages with various prefixes, and
+// verifies that only those selected by the configuration are captured, with their
+// content parsed as JSON where possible.
+func TestCaptureMessages(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	cfg.MessagesStream = "public.test_messages"
+	cfg.MessagePrefixes = []string{"outbox"}
+	cfg.MessageFormat = messageFormatJSON
+	var catalog, state = testCatalog("test_messages"), sqlcapture.PersistentState{}
+	catalog.Streams[0].PrimaryKey = [][]string{{"lsn"}}
+
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbQuery(ctx, t, `SELECT pg_logical_emit_message(true, 'outbox', '{"id": 1, "event": "created"}');`)
+	dbQuery(ctx, t, `SELECT pg_logical_emit_message(false, 'outbox', 'not json');`)
+	dbQuery(ctx, t, `SELECT pg_logical_emit_message(true, 'other', '{"id": 2}');`)
+	dbQuery(ctx, t, `SELECT pg_logical_emit_message(true, $1, 'heartbeat');`, heartbeatMessagePrefix)
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
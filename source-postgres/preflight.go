@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgx/v4"
+)
+
+// A preflightReport collects the outcome of the preflight checks run by `doCheck`,
+// which verify the prerequisites of a capture that discovery alone doesn't exercise.
+type preflightReport struct {
+	failures []string // Unmet prerequisites, each with a hint on how to fix it
+	notes    []string // Informational findings which don't prevent the capture
+}
+
+// fail records an unmet prerequisite, with a hint on how to fix it unless the
+// description of the problem already includes one.
+func (r *preflightReport) fail(problem, remediation string) {
+	if remediation != "" {
+		problem = fmt.Sprintf("%s (%s)", problem, remediation)
+	}
+	r.failures = append(r.failures, problem)
+}
+
+func (r *preflightReport) note(format string, args ...interface{}) {
+	r.notes = append(r.notes, fmt.Sprintf(format, args...))
+}
+
+// Failed returns true if any prerequisite of the capture is unmet.
+func (r *preflightReport) Failed() bool {
+	return len(r.failures) > 0
+}
+
+// Message describes every failure of the report, or its notes if there are none.
+func (r *preflightReport) Message() string {
+	if r.Failed() {
+		return fmt.Sprintf("%d preflight check(s) failed:\n  - %s", len(r.failures), strings.Join(r.failures, "\n  - "))
+	}
+	return strings.Join(r.notes, "\n")
+}
+
+// runPreflight checks that the database is configured as the capture requires. Every
+// check is run regardless of earlier failures, so that all problems can be reported
+// at once. An error is only returned if the checks can't be run at all.
+func runPreflight(ctx context.Context, config *Config) (*preflightReport, error) {
+	var conn, err = pgx.Connect(ctx, config.ConnectionURI)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var report = new(preflightReport)
+	if config.CaptureMode == sqlcapture.CaptureModeReplication {
+		checkWALLevel(ctx, conn, report)
+		checkReplicationPrivilege(ctx, conn, report)
+		checkReplicationSlot(ctx, conn, config, report)
+		checkPublication(ctx, conn, config, report)
+		checkTableReplicaIdentities(ctx, conn, config, report)
+		if config.BackfillMethod == sqlcapture.BackfillMethodWatermarks {
+			checkTableWritable(ctx, conn, report, "watermarks", config.WatermarksTable,
+				"(slot TEXT PRIMARY KEY, watermark TEXT)", "INSERT INTO %s (slot, watermark) VALUES ('preflight', 'preflight') ON CONFLICT (slot) DO UPDATE SET watermark = 'preflight';",
+				"or set 'backfill_method' to 'snapshot', which requires no writes")
+		}
+		if config.MessagesStream != "" {
+			checkMessagesSupport(ctx, conn, report)
+		}
+		if config.HeartbeatInterval > 0 && config.HeartbeatMethod == heartbeatMethodTable {
+			checkTableWritable(ctx, conn, report, "heartbeat", config.HeartbeatTable,
+				"(slot TEXT PRIMARY KEY, heartbeat TIMESTAMPTZ)", "INSERT INTO %s (slot, heartbeat) VALUES ('preflight', now()) ON CONFLICT (slot) DO UPDATE SET heartbeat = now();",
+				"or set 'heartbeat_method' to 'message'")
+		}
+	}
+	return report, nil
+}
+
+func checkWALLevel(ctx context.Context, conn *pgx.Conn, report *preflightReport) {
+	var walLevel string
+	if err := conn.QueryRow(ctx, `SHOW wal_level;`).Scan(&walLevel); err != nil {
+		report.fail(fmt.Sprintf("unable to query wal_level: %v", err), "the capture user must be able to read server settings")
+	} else if walLevel != "logical" {
+		report.fail(fmt.Sprintf("wal_level is %q rather than \"logical\"", walLevel),
+			"set 'wal_level = logical' in postgresql.conf and restart the server, or on Amazon RDS set the 'rds.logical_replication' parameter to 1")
+	}
+}
+
+const queryReplicationPrivilege = `
+  SELECT r.rolreplication OR r.rolsuper OR EXISTS (
+    SELECT 1 FROM pg_catalog.pg_roles g
+    WHERE g.rolname = 'rds_replication' AND pg_has_role(r.oid, g.oid, 'member'))
+  FROM pg_catalog.pg_roles r
+  WHERE r.rolname = current_user;`
+
+func checkReplicationPrivilege(ctx context.Context, conn *pgx.Conn, report *preflightReport) {
+	var ok bool
+	if err := conn.QueryRow(ctx, queryReplicationPrivilege).Scan(&ok); err != nil {
+		report.fail(fmt.Sprintf("unable to query the privileges of the capture user: %v", err), "the capture user must be able to read pg_roles")
+	} else if !ok {
+		report.fail("the capture user lacks the REPLICATION privilege",
+			"run 'ALTER ROLE <user> WITH REPLICATION', or on Amazon RDS 'GRANT rds_replication TO <user>'")
+	}
+}
+
+// checkReplicationSlot verifies that the replication slot is usable and isn't in use
+// by another consumer if it exists, reporting how far it lags behind the current WAL
+// position, and otherwise that there's room for it to be created. The snapshot backfill
+// method also needs room for a temporary slot.
+//
+// Each shard of a sharded capture creates a slot of its own, but the number of shards
+// isn't part of the endpoint config, so it can't be checked. Instead the number of slots
+// which remain available is reported, as that bounds how many shards there can be.
+func checkReplicationSlot(ctx context.Context, conn *pgx.Conn, config *Config, report *preflightReport) {
+	var slotsNeeded int
+	var database string
+	if err := conn.QueryRow(ctx, `SELECT current_database();`).Scan(&database); err != nil {
+		report.fail(fmt.Sprintf("unable to query the current database: %v", err), "the capture user must be able to connect to the database")
+		return
+	}
+	var info, err = getSlotInfo(ctx, conn, config.SlotName)
+	if err != nil {
+		report.fail(err.Error(), "the capture user must be able to read pg_replication_slots")
+		return
+	}
+	if info == nil {
+		slotsNeeded++
+	} else if err := info.Validate(config.SlotName, database); err != nil {
+		report.fail(err.Error(), "")
+	} else if info.Active {
+		// The consumer is most likely this capture itself, whose connection check
+		// runs while it's capturing, so this can't be treated as a failure.
+		report.note("replication slot %q is in use by process %d (presumably the running capture), and lags %d bytes behind the current WAL position", config.SlotName, info.ActivePID, info.LagBytes)
+	} else {
+		report.note("replication slot %q lags %d bytes behind the current WAL position", config.SlotName, info.LagBytes)
+	}
+	if config.BackfillMethod == sqlcapture.BackfillMethodSnapshot {
+		slotsNeeded++
+	}
+
+	var slotCount, maxSlots int
+	if err := conn.QueryRow(ctx, `SELECT count(*), current_setting('max_replication_slots')::integer FROM pg_catalog.pg_replication_slots;`).Scan(&slotCount, &maxSlots); err != nil {
+		report.fail(fmt.Sprintf("unable to query replication slot usage: %v", err), "the capture user must be able to read pg_replication_slots")
+	} else if slotCount+slotsNeeded > maxSlots {
+		report.fail(fmt.Sprintf("%d of max_replication_slots = %d replication slots are in use, but the capture needs %d more", slotCount, maxSlots, slotsNeeded),
+			"increase 'max_replication_slots' and restart the server, or drop unused slots with pg_drop_replication_slot()")
+	} else {
+		report.note("%d of max_replication_slots = %d replication slots are available, and a sharded capture needs one for each of its shards", maxSlots-slotCount, maxSlots)
+	}
+}
+
+// checkPublication verifies that the publication exists, or otherwise that the capture
+// user can create it. Publications of all tables can only be created by superusers.
+func checkPublication(ctx context.Context, conn *pgx.Conn, config *Config, report *preflightReport) {
+	var exists, superuser bool
+	if err := conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_publication WHERE pubname = $1);`, config.PublicationName).Scan(&exists); err != nil {
+		report.fail(fmt.Sprintf("unable to query publication %q: %v", config.PublicationName, err), "the capture user must be able to read pg_publication")
+	} else if exists {
+		return
+	} else if err := conn.QueryRow(ctx, `SELECT rolsuper FROM pg_catalog.pg_roles WHERE rolname = current_user;`).Scan(&superuser); err != nil {
+		report.fail(fmt.Sprintf("unable to query the privileges of the capture user: %v", err), "the capture user must be able to read pg_roles")
+	} else if !superuser {
+		report.fail(fmt.Sprintf("publication %q doesn't exist, and the capture user can't create it", config.PublicationName),
+			publicationRemediation(config.PublicationName))
+	} else {
+		report.note("publication %q doesn't exist, and will be created by the capture", config.PublicationName)
+	}
+}
+
+// checkTableReplicaIdentities verifies the replica identity of every table which would
+// be discovered. Tables whose updates and deletes can't be replicated at all are failures,
+// while those whose changes will lack complete previous values are noted, grouped by the
+// consequence so that a database of many such tables doesn't produce a wall of notes.
+func checkTableReplicaIdentities(ctx context.Context, conn *pgx.Conn, config *Config, report *preflightReport) {
+	var tables, err = getDatabaseTables(ctx, conn, newDiscoveryFilter(config))
+	if err != nil {
+		report.fail(fmt.Sprintf("unable to query table replica identities: %v", err), "the capture user must be able to read pg_class")
+		return
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Schema+"."+tables[i].Name < tables[j].Schema+"."+tables[j].Name
+	})
+
+	var degraded = make(map[string][]string) // A map from each problem to the tables it applies to
+	var problems []string
+	for _, table := range tables {
+		if table.ReplicaIdentity == "" {
+			continue // Views and foreign tables aren't replicated at all
+		}
+		var streamID = table.Schema + "." + table.Name
+		var problem, severe = replicaIdentityProblem(table.ReplicaIdentity, len(table.PrimaryKey) > 0)
+		if problem == "" {
+			continue
+		} else if severe {
+			report.fail(fmt.Sprintf("%q: %s", streamID, problem),
+				fmt.Sprintf("run 'ALTER TABLE %s REPLICA IDENTITY FULL;'", quoteTableName(table.Schema, table.Name)))
+			continue
+		}
+		if _, ok := degraded[problem]; !ok {
+			problems = append(problems, problem)
+		}
+		degraded[problem] = append(degraded[problem], streamID)
+	}
+	for _, problem := range problems {
+		report.note("for tables %s: %s (run 'ALTER TABLE <table> REPLICA IDENTITY FULL;' to capture complete rows)", strings.Join(degraded[problem], ", "), problem)
+	}
+}
+
+// publicationRemediation describes how to create the publication of a capture whose
+// user can't create it.
+func publicationRemediation(publication string) string {
+	return fmt.Sprintf("create the publication as a superuser with 'CREATE PUBLICATION %s FOR ALL TABLES;'", quoteIdentifier(publication))
+}
+
+// checkTableWritable verifies that the named table can be created if it doesn't exist,
+// and written to, by performing the same writes as the capture within a transaction
+// which is then rolled back.
+func checkTableWritable(ctx context.Context, conn *pgx.Conn, report *preflightReport, description, streamID, tableDef, insertQuery, alternative string) {
+	var table = quoteStreamID(streamID)
+	var problem = fmt.Sprintf("the %s table %q isn't writable", description, streamID)
+	var remediation = fmt.Sprintf("create it with 'CREATE TABLE %s %s;' and grant the capture user INSERT and UPDATE on it, %s", table, tableDef, alternative)
+
+	var tx, err = conn.Begin(ctx)
+	if err != nil {
+		report.fail(fmt.Sprintf("%s: %v", problem, err), remediation)
+		return
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s %s;", table, tableDef)); err != nil {
+		report.fail(fmt.Sprintf("%s: %v", problem, err), remediation)
+	} else if _, err := tx.Exec(ctx, fmt.Sprintf(insertQuery, table)); err != nil {
+		report.fail(fmt.Sprintf("%s: %v", problem, err), remediation)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPreflight(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+
+	// The test database is configured correctly, so every check should pass.
+	var report, err = runPreflight(ctx, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed() {
+		t.Fatalf("expected preflight checks to pass, got: %s", report.Message())
+	}
+	if !strings.Contains(report.Message(), "replication slots are available") {
+		t.Fatalf("expected a note about available replication slots, got: %s", report.Message())
+	}
+
+	// A missing publication is created by the capture, since the test user is a
+	// superuser, so it's noted rather than failing the checks.
+	cfg.PublicationName = "test_preflight_missing_publication"
+	if report, err = runPreflight(ctx, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed() || !strings.Contains(report.Message(), "will be created") {
+		t.Fatalf("expected a note about the missing publication, got: %s", report.Message())
+	}
+	cfg.PublicationName = TestDefaultConfig.PublicationName
+
+	// A watermarks table in a nonexistent schema can't be written to, and
+	// the failure should say so.
+	cfg.WatermarksTable = "nonexistent_schema.flow_watermarks"
+	if report, err = runPreflight(ctx, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.failures) != 1 || !strings.Contains(report.failures[0], "watermarks table") {
+		t.Fatalf("expected a single watermarks table failure, got: %s", report.Message())
+	}
+	cfg.WatermarksTable = TestDefaultConfig.WatermarksTable
+
+	// A table with the default replica identity captures incomplete previous values,
+	// which is noted, while one without any replica identity fails the checks.
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	if report, err = runPreflight(ctx, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed() || !strings.Contains(report.Message(), tableName) {
+		t.Fatalf("expected a note about the replica identity of %q, got: %s", tableName, report.Message())
+	}
+	dbQuery(ctx, t, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY NOTHING;", tableName))
+	if report, err = runPreflight(ctx, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.failures) != 1 || !strings.Contains(report.failures[0], tableName) || !strings.Contains(report.failures[0], "REPLICA IDENTITY FULL") {
+		t.Fatalf("expected a single replica identity failure, got: %s", report.Message())
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgproto3/v2"
@@ -14,16 +15,6 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type changeEventHandler func(event *changeEvent) error
-
-type changeEvent struct {
-	Type      string
-	LSN       pglogrepl.LSN // Only set for "Commit" events
-	Namespace string
-	Table     string
-	Fields    map[string]interface{}
-}
-
 // A replicationStream represents the process of receiving PostgreSQL
 // Logical Replication events, managing keepalives and status updates,
 // and translating changes into a more friendly representation. There
@@ -32,8 +23,10 @@ type changeEvent struct {
 type replicationStream struct {
 	replSlot  string         // The name of the PostgreSQL replication slot to use
 	pubName   string         // The name of the PostgreSQL publication to use
+	startLSN  pglogrepl.LSN  // The LSN from which replication was started
 	commitLSN uint64         // The most recently *committed* LSN, given to us by startReplication or the CommitLSN() method
 	conn      *pgconn.PgConn // The PostgreSQL replication connection
+	tables    *tableOptions  // The per-table configuration, which determines the captured columns
 
 	// standbyStatusDeadline is the time at which we need to stop receiving
 	// replication messages and go send a Standby Status Update message to
@@ -44,15 +37,23 @@ type replicationStream struct {
 	// from the database.
 	connInfo *pgtype.ConnInfo
 
+	binary      bool              // True if column values are sent in binary format where possible
+	binaryTypes map[uint32]uint32 // The types whose binary decoders are used for the values of user-defined types
+	messages    *messageOptions   // How logical decoding messages are captured, or nil if they aren't
+
 	// relations keeps track of all "Relation Messages" from the database. These
 	// messages tell us about the integer ID corresponding to a particular table
 	// and other information about the table structure at a particular moment.
 	relations map[uint32]*pglogrepl.RelationMessage
 
-	inTransaction bool // inTransaction is true when we're in between a BEGIN/COMMIT message pair.
+	inTransaction  bool          // inTransaction is true when we're in between a BEGIN/COMMIT message pair.
+	transactionLSN pglogrepl.LSN // transactionLSN is the commit LSN of the current transaction, given to us by the BEGIN message.
+	transactionSeq int           // transactionSeq counts the changes decoded so far in the current transaction.
+	transactionXID uint32        // transactionXID is the ID of the current transaction.
+	transactionTS  time.Time     // transactionTS is the commit time of the current transaction.
 
-	eventBuf *changeEvent      // A single-element buffer used in between 'receiveMessage' and the output channel
-	events   chan *changeEvent // The channel to which replication events will be written
+	eventBuf *sqlcapture.ChangeEvent      // A single-element buffer used in between 'receiveMessage' and the output channel
+	events   chan *sqlcapture.ChangeEvent // The channel to which replication events will be written
 
 	cancel context.CancelFunc // Cancel function for the replication goroutine's context
 }
@@ -65,7 +66,11 @@ const standbyStatusInterval = 10 * time.Second
 // likely to exercise blocking sends and backpressure.
 var replicationBufferSize = 1024
 
-func startReplication(ctx context.Context, conn *pgconn.PgConn, slot, publication string, startLSN pglogrepl.LSN) (*replicationStream, error) {
+// startReplication begins streaming changes from the replication slot. Column values
+// are requested in binary format if binaryTypes is non-nil, in which case it maps the
+// OIDs of user-defined column types to the types whose decoders are used for them.
+// Logical decoding messages are requested if messages is non-nil.
+func startReplication(ctx context.Context, conn *pgconn.PgConn, slot, publication string, startLSN pglogrepl.LSN, tables *tableOptions, binaryTypes map[uint32]uint32, messages *messageOptions) (*replicationStream, error) {
 	// If we don't have a valid `startLSN` from a previous capture, it gets initialized
 	// to the current WAL flush position obtained via the `IDENTIFY_SYSTEM` command.
 	if startLSN == 0 {
@@ -85,25 +90,32 @@ func startReplication(ctx context.Context, conn *pgconn.PgConn, slot, publicatio
 	var stream = &replicationStream{
 		replSlot:  slot,
 		pubName:   publication,
+		startLSN:  startLSN,
 		commitLSN: uint64(startLSN),
 		conn:      conn,
+		tables:    tables,
 		connInfo:  pgtype.NewConnInfo(),
 		relations: make(map[uint32]*pglogrepl.RelationMessage),
+
+		binary:      binaryTypes != nil,
+		binaryTypes: binaryTypes,
+		messages:    messages,
 		// standbyStatusDeadline is left uninitialized so an update will be sent ASAP
-		events: make(chan *changeEvent, replicationBufferSize),
+		events: make(chan *sqlcapture.ChangeEvent, replicationBufferSize),
 	}
 
-	// Create the publication and replication slot, ignoring the inevitable errors
-	// when they already exist. We could in theory add some extra logic to check,
-	// but why bother when PostgreSQL will already do what we need?
-	_ = conn.Exec(ctx, fmt.Sprintf(`CREATE PUBLICATION %s FOR ALL TABLES;`, stream.pubName)).Close()
-	_ = conn.Exec(ctx, fmt.Sprintf(`CREATE_REPLICATION_SLOT %s LOGICAL pgoutput;`, stream.replSlot)).Close()
-
+	var pluginArgs = []string{
+		`"proto_version" '1'`,
+		fmt.Sprintf(`"publication_names" '%s'`, stream.pubName),
+	}
+	if stream.binary {
+		pluginArgs = append(pluginArgs, `"binary" 'true'`)
+	}
+	if stream.messages != nil {
+		pluginArgs = append(pluginArgs, `"messages" 'true'`)
+	}
 	if err := pglogrepl.StartReplication(ctx, stream.conn, slot, startLSN, pglogrepl.StartReplicationOptions{
-		PluginArgs: []string{
-			`"proto_version" '1'`,
-			fmt.Sprintf(`"publication_names" '%s'`, stream.pubName),
-		},
+		PluginArgs: pluginArgs,
 	}); err != nil {
 		conn.Close(ctx)
 		return nil, fmt.Errorf("unable to start replication: %w", err)
@@ -122,7 +134,12 @@ func startReplication(ctx context.Context, conn *pgconn.PgConn, slot, publicatio
 	return stream, nil
 }
 
-func (s *replicationStream) Events() <-chan *changeEvent {
+// StartCursor returns the LSN from which replication was started.
+func (s *replicationStream) StartCursor() string {
+	return s.startLSN.String()
+}
+
+func (s *replicationStream) Events() <-chan *sqlcapture.ChangeEvent {
 	return s.events
 }
 
@@ -189,7 +206,7 @@ func (s *replicationStream) relayMessages(ctx context.Context) error {
 	}
 }
 
-func (s *replicationStream) decodeMessage(msg pglogrepl.Message) (*changeEvent, error) {
+func (s *replicationStream) decodeMessage(msg pglogrepl.Message) (*sqlcapture.ChangeEvent, error) {
 	// Some notes on the Logical Replication / pgoutput message stream, since
 	// as far as I can tell this isn't documented anywhere but comments in the
 	// relevant PostgreSQL sources.
@@ -210,32 +227,68 @@ func (s *replicationStream) decodeMessage(msg pglogrepl.Message) (*changeEvent,
 	// sequence of values along with a "Relation ID" which can be used to
 	// look it up. We will only be given a particular relation once (unless
 	// it changes on the server) in a given replication session, so entries
-	// in the relations mapping will never be removed.
+	// in the relations mapping will never be removed. Each one is also relayed
+	// as a "Relation" event, so that schema changes can be noticed downstream.
 	switch msg := msg.(type) {
+	case *keepaliveMessage:
+		// Keepalives only tell us anything useful about our progress through
+		// the WAL when they arrive in between transactions.
+		if s.inTransaction {
+			return nil, nil
+		}
+		return &sqlcapture.ChangeEvent{Type: "KeepAlive", Cursor: msg.WALEnd.String()}, nil
 	case *pglogrepl.RelationMessage:
 		s.relations[msg.RelationID] = msg
-		return nil, nil
+		// Columns which aren't captured are omitted, since otherwise they'd appear to
+		// have been added relative to the discovered schema of the table. The types of
+		// transformed columns are left unknown, since their captured values have the
+		// type of the transform rather than that of the column.
+		var streamID = sqlcapture.JoinStreamID(msg.Namespace, msg.RelationName)
+		var columns = make(map[string]string)
+		for _, col := range msg.Columns {
+			if !s.tables.includesColumn(streamID, col.Name) {
+				continue
+			} else if s.tables.transform(streamID, col.Name) != nil {
+				columns[col.Name] = ""
+				continue
+			}
+			columns[col.Name] = s.typeName(col.DataType)
+		}
+		return &sqlcapture.ChangeEvent{
+			Type:      "Relation",
+			Namespace: msg.Namespace,
+			Table:     msg.RelationName,
+			Columns:   columns,
+		}, nil
 	case *pglogrepl.BeginMessage:
 		if s.inTransaction {
 			return nil, fmt.Errorf("got BEGIN message while another transaction in progress")
 		}
 		s.inTransaction = true
+		s.transactionLSN = msg.FinalLSN
+		s.transactionSeq = 0
+		s.transactionXID = msg.Xid
+		s.transactionTS = msg.CommitTime
 		return nil, nil
 	case *pglogrepl.InsertMessage:
-		return s.decodeChangeEvent(msg.Type().String(), msg.Tuple, msg.RelationID)
+		return s.decodeChangeEvent(msg.Type().String(), 0, nil, msg.Tuple, msg.RelationID)
 	case *pglogrepl.UpdateMessage:
-		return s.decodeChangeEvent(msg.Type().String(), msg.NewTuple, msg.RelationID)
+		// The old tuple of an update is only sent when the replica identity of the
+		// table is FULL, or when the update modified the replica identity columns.
+		return s.decodeChangeEvent(msg.Type().String(), msg.OldTupleType, msg.OldTuple, msg.NewTuple, msg.RelationID)
 	case *pglogrepl.DeleteMessage:
-		return s.decodeChangeEvent(msg.Type().String(), msg.OldTuple, msg.RelationID)
+		return s.decodeChangeEvent(msg.Type().String(), msg.OldTupleType, msg.OldTuple, msg.OldTuple, msg.RelationID)
+	case *logicalMessage:
+		return s.decodeLogicalMessage(msg)
 	case *pglogrepl.CommitMessage:
 		if !s.inTransaction {
 			return nil, fmt.Errorf("got COMMIT message without a transaction in progress")
 		}
 		s.inTransaction = false
 
-		var event = &changeEvent{
-			Type: "Commit",
-			LSN:  msg.TransactionEndLSN,
+		var event = &sqlcapture.ChangeEvent{
+			Type:   "Commit",
+			Cursor: msg.TransactionEndLSN.String(),
 		}
 		return event, nil
 	}
@@ -269,7 +322,12 @@ func (s *replicationStream) decodeMessage(msg pglogrepl.Message) (*changeEvent,
 	return nil, fmt.Errorf("unhandled message type %q: %v", msg.Type(), msg)
 }
 
-func (s *replicationStream) decodeChangeEvent(eventType string, tuple *pglogrepl.TupleData, relID uint32) (*changeEvent, error) {
+// decodeChangeEvent decodes an Insert, Update, or Delete message into a ChangeEvent.
+// The before tuple holds the previous values of the row, and its type is either 'O'
+// when it contains every column (REPLICA IDENTITY FULL), or 'K' when it contains only
+// the replica identity columns, in which case the other columns are omitted from the
+// before-image rather than being reported as null.
+func (s *replicationStream) decodeChangeEvent(eventType string, beforeType uint8, before, tuple *pglogrepl.TupleData, relID uint32) (*sqlcapture.ChangeEvent, error) {
 	if !s.inTransaction {
 		return nil, fmt.Errorf("got %s message without a transaction in progress", eventType)
 	}
@@ -279,32 +337,115 @@ func (s *replicationStream) decodeChangeEvent(eventType string, tuple *pglogrepl
 		return nil, fmt.Errorf("unknown relation ID %d", relID)
 	}
 
+	var sequence = s.transactionSeq
+	s.transactionSeq++
+
+	var fields, unchanged, err = s.decodeTuple(tuple, rel, false)
+	if err != nil {
+		return nil, err
+	}
+	var beforeFields map[string]interface{}
+	if before != nil {
+		var keyOnly = beforeType == pglogrepl.UpdateMessageTupleTypeKey
+		if beforeFields, _, err = s.decodeTuple(before, rel, keyOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	var event = &sqlcapture.ChangeEvent{
+		Type:   eventType,
+		Cursor: s.transactionLSN.String(),
+		// Replicated changes are identified by their transaction's commit LSN and
+		// their index within the transaction, which sort in the order they occurred.
+		ChangeID:  fmt.Sprintf("R%016X%08X", uint64(s.transactionLSN), sequence),
+		Source:    s.transactionSource(),
+		Namespace: rel.Namespace,
+		Table:     rel.RelationName,
+		Fields:    fields,
+		Before:    beforeFields,
+		Unchanged: unchanged,
+	}
+	return event, nil
+}
+
+// transactionSource returns the metadata of a change, which describes the current
+// transaction.
+func (s *replicationStream) transactionSource() map[string]interface{} {
+	var source = map[string]interface{}{"lsn": s.transactionLSN.String()}
+	if s.transactionXID != 0 {
+		source["txid"] = s.transactionXID
+	}
+	if !s.transactionTS.IsZero() {
+		var ts = s.transactionTS.UTC()
+		source["commit_ts"] = &ts
+	}
+	return source
+}
+
+// decodeTuple decodes the column values of a tuple into a map from column names
+// to values. A nil tuple is decoded as an empty map. When keyOnly is true, columns
+// which aren't part of the replica identity of the relation are omitted.
+//
+// Large values which PostgreSQL has stored out of line ("TOASTed") aren't sent at
+// all when an update leaves them unchanged. Such columns are omitted from the map
+// and their names are returned separately.
+//
+// Columns which are excluded from capture by the table's configuration are omitted
+// entirely, and the configured transforms are applied to the values of the others.
+func (s *replicationStream) decodeTuple(tuple *pglogrepl.TupleData, rel *pglogrepl.RelationMessage, keyOnly bool) (map[string]interface{}, []string, error) {
+	var streamID = sqlcapture.JoinStreamID(rel.Namespace, rel.RelationName)
 	var fields = make(map[string]interface{})
+	var unchanged []string
 	if tuple != nil {
 		for idx, col := range tuple.Columns {
+			if keyOnly && rel.Columns[idx].Flags&relationColumnKeyFlag == 0 {
+				continue
+			}
 			var colName = rel.Columns[idx].Name
+			if !s.tables.includesColumn(streamID, colName) {
+				continue
+			}
 			switch col.DataType {
 			case 'n':
 				fields[colName] = nil
+			case 'u':
+				unchanged = append(unchanged, colName)
 			case 't':
 				var val, err = s.decodeTextColumnData(col.Data, rel.Columns[idx].DataType)
 				if err != nil {
-					return nil, fmt.Errorf("error decoding column data: %w", err)
+					return nil, nil, fmt.Errorf("error decoding column data: %w", err)
+				}
+				fields[colName] = val
+			case 'b':
+				// Even in binary mode, values of types without a binary output
+				// function are sent as text, so the format varies by column.
+				var val, err = s.decodeBinaryColumnData(col.Data, rel.Columns[idx].DataType)
+				if err != nil {
+					return nil, nil, fmt.Errorf("error decoding binary column %q: %w", colName, err)
 				}
 				fields[colName] = val
 			default:
-				return nil, fmt.Errorf("unhandled column data type %v", col.DataType)
+				return nil, nil, fmt.Errorf("unhandled column data type %v", col.DataType)
 			}
 		}
 	}
+	if err := s.tables.transformFields(streamID, fields); err != nil {
+		return nil, nil, fmt.Errorf("table %q: %w", streamID, err)
+	}
+	return fields, unchanged, nil
+}
 
-	var event = &changeEvent{
-		Type:      eventType,
-		Namespace: rel.Namespace,
-		Table:     rel.RelationName,
-		Fields:    fields,
+// relationColumnKeyFlag is set in the flags of a relation column which is part of
+// the replica identity of the relation.
+const relationColumnKeyFlag = 1
+
+// typeName returns the PostgreSQL name of the type with the specified OID, or a
+// placeholder for types which are unknown to us.
+func (s *replicationStream) typeName(oid uint32) string {
+	if dt, ok := s.connInfo.DataTypeForOID(oid); ok {
+		return dt.Name
 	}
-	return event, nil
+	return fmt.Sprintf("oid:%d", oid)
 }
 
 func (s *replicationStream) decodeTextColumnData(data []byte, dataType uint32) (interface{}, error) {
@@ -323,6 +464,17 @@ func (s *replicationStream) decodeTextColumnData(data []byte, dataType uint32) (
 	return decoder.(pgtype.Value).Get(), nil
 }
 
+// keepaliveMessage is a synthetic replication message representing a Primary
+// Keepalive from the database, so that the server's current WAL position can be
+// relayed to the consumer in the same way as any other change.
+type keepaliveMessage struct {
+	WALEnd pglogrepl.LSN
+}
+
+func (m *keepaliveMessage) Type() pglogrepl.MessageType {
+	return pglogrepl.MessageType(pglogrepl.PrimaryKeepaliveMessageByteID)
+}
+
 // receiveMessage reads and parses the next replication message from the database,
 // blocking until a message is available, the context is cancelled, or an error
 // occurs.
@@ -344,11 +496,19 @@ func (s *replicationStream) receiveMessage(ctx context.Context) (pglogrepl.Messa
 				if pkm.ReplyRequested {
 					s.standbyStatusDeadline = time.Now()
 				}
+				return &keepaliveMessage{WALEnd: pkm.ServerWALEnd}, nil
 			case pglogrepl.XLogDataByteID:
 				var xld, err = pglogrepl.ParseXLogData(msg.Data[1:])
 				if err != nil {
 					return nil, fmt.Errorf("error parsing XLogData: %w", err)
 				}
+				if len(xld.WALData) > 0 && xld.WALData[0] == logicalMessageByteID {
+					var msg, err = parseLogicalMessage(xld.WALData[1:])
+					if err != nil {
+						return nil, fmt.Errorf("error parsing logical decoding message: %w", err)
+					}
+					return msg, nil
+				}
 				msg, err := pglogrepl.Parse(xld.WALData)
 				if err != nil {
 					return nil, fmt.Errorf("error parsing logical replication message: %w", err)
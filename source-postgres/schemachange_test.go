@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/protocols/airbyte"
+)
+
+// TestSchemaChangeFail alters a table in between two changes which are replicated
+// in the same session, and verifies that the 'fail' policy stops the capture.
+func TestSchemaChangeFail(t *testing.T) {
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
+	cfg.SchemaChangePolicy = sqlcapture.SchemaChangeFail
+	var table = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog = testCatalog(table)
+	dbInsert(ctx, t, table, [][]interface{}{{0, "zero"}, {1, "one"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+
+	dbInsert(ctx, t, table, [][]interface{}{{2, "two"}})
+	dbQuery(ctx, t, fmt.Sprintf("ALTER TABLE %s ADD COLUMN extra TEXT;", table))
+	dbInsert(ctx, t, table, [][]interface{}{{3, "three", "extra"}})
+
+	var err = RunCapture(ctx, &cfg, &catalog, &state, new(CaptureOutputBuffer))
+	if err == nil || !strings.Contains(err.Error(), `{"added":["extra"]}`) {
+		t.Fatalf("expected schema change error, got %v", err)
+	}
+}
+
+// TestSchemaChangeExcludedColumns verifies that columns which aren't captured
+// aren't mistaken for columns added since the discovered schema of the table.
+func TestSchemaChangeExcludedColumns(t *testing.T) {
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
+	cfg.SchemaChangePolicy = sqlcapture.SchemaChangeFail
+	var table = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT, secret TEXT)")
+	cfg.Tables = map[string]*TableConfig{"public." + table: {ExcludeColumns: []string{"secret"}}}
+	var catalog = discoveredCatalog(ctx, t, cfg, table)
+	dbInsert(ctx, t, table, [][]interface{}{{0, "zero", "x"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+
+	// Replicating this change fails the capture if a schema change is detected.
+	dbInsert(ctx, t, table, [][]interface{}{{1, "one", "y"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+}
+
+// TestSchemaChangeTransformedColumns verifies that the types of transformed
+// columns aren't mistaken for changes to the discovered types of the columns.
+func TestSchemaChangeTransformedColumns(t *testing.T) {
+	var cfg, ctx, state = TestDefaultConfig, shortTestContext(t), sqlcapture.PersistentState{}
+	cfg.SchemaChangePolicy = sqlcapture.SchemaChangeFail
+	var table = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, account INTEGER, notes TEXT)")
+	cfg.Tables = map[string]*TableConfig{
+		"public." + table: {Transforms: map[string]*ColumnTransform{
+			"account": {Type: "hash"},
+			"notes":   {Type: "null"},
+		}},
+	}
+	var catalog = discoveredCatalog(ctx, t, cfg, table)
+	dbInsert(ctx, t, table, [][]interface{}{{0, 100, "zero"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+
+	// Replicating this change fails the capture if a schema change is detected.
+	dbInsert(ctx, t, table, [][]interface{}{{1, 101, "one"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+}
+
+// discoveredCatalog is a test helper which constructs a ConfiguredCatalog of the
+// named tables whose streams have the JSON schemas that discovery produces for them.
+func discoveredCatalog(ctx context.Context, t *testing.T, cfg Config, tables ...string) airbyte.ConfiguredCatalog {
+	t.Helper()
+	var discovered, err = DiscoverCatalog(ctx, cfg)
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	var catalog = testCatalog(tables...)
+	for idx := range catalog.Streams {
+		for _, stream := range discovered.Streams {
+			if stream.Namespace == catalog.Streams[idx].Stream.Namespace && stream.Name == catalog.Streams[idx].Stream.Name {
+				catalog.Streams[idx].Stream.JSONSchema = stream.JSONSchema
+			}
+		}
+		if catalog.Streams[idx].Stream.JSONSchema == nil {
+			t.Fatalf("no stream named %q discovered", catalog.Streams[idx].Stream.Name)
+		}
+	}
+	return catalog
+}
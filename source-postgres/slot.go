@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// slotInfo describes an existing replication slot, as reported by the view
+// `pg_replication_slots`.
+type slotInfo struct {
+	Plugin    string // The logical decoding output plugin of the slot
+	SlotType  string // Either 'logical' or 'physical'
+	Database  string // The database of a logical slot
+	Active    bool   // True if the slot is currently in use by some consumer
+	ActivePID int32  // The process ID of the consumer of an active slot
+	LagBytes  int64  // The number of bytes of WAL between the confirmed position of the slot and the current position, or the replayed position on a standby
+	WALStatus string // The availability of the WAL retained by the slot, which is 'lost' if the slot has been invalidated (PostgreSQL 13+)
+}
+
+// querySlotInfo selects the details of a replication slot. The `wal_status` column
+// only exists in PostgreSQL 13 and later, so it's substituted on older versions.
+const querySlotInfo = `
+  SELECT COALESCE(plugin, ''), slot_type, COALESCE(database, ''), active, COALESCE(active_pid, 0),
+    COALESCE(pg_wal_lsn_diff(` + currentWALPosition + `, COALESCE(confirmed_flush_lsn, restart_lsn)), 0)::bigint,
+    %s
+  FROM pg_catalog.pg_replication_slots
+  WHERE slot_name = $1;`
+
+// getSlotInfo queries the details of the named replication slot, returning nil if
+// the slot doesn't exist.
+func getSlotInfo(ctx context.Context, conn *pgx.Conn, slot string) (*slotInfo, error) {
+	var walStatusColumn = "''"
+	var serverVersion int
+	if err := conn.QueryRow(ctx, `SELECT current_setting('server_version_num')::integer;`).Scan(&serverVersion); err != nil {
+		return nil, fmt.Errorf("error querying server version: %w", err)
+	} else if serverVersion >= 130000 {
+		walStatusColumn = "COALESCE(wal_status, '')"
+	}
+
+	var info slotInfo
+	var err = conn.QueryRow(ctx, fmt.Sprintf(querySlotInfo, walStatusColumn), slot).Scan(
+		&info.Plugin, &info.SlotType, &info.Database, &info.Active, &info.ActivePID, &info.LagBytes, &info.WALStatus)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error querying replication slot %q: %w", slot, err)
+	}
+	return &info, nil
+}
+
+// Validate checks that the replication slot can be used by a capture of the
+// given database.
+func (info *slotInfo) Validate(slot, database string) error {
+	if info.SlotType != "logical" || info.Plugin != "pgoutput" {
+		return fmt.Errorf("replication slot %q is a %s slot using plugin %q, but a logical slot using 'pgoutput' is required (drop it with \"SELECT pg_drop_replication_slot('%s');\" or configure a different slot_name)", slot, info.SlotType, info.Plugin, slot)
+	}
+	if info.Database != database {
+		return fmt.Errorf("replication slot %q belongs to database %q rather than %q (configure a different slot_name)", slot, info.Database, database)
+	}
+	if info.WALStatus == "lost" {
+		return fmt.Errorf("replication slot %q has been invalidated because WAL it required was removed, most likely due to max_slot_wal_keep_size (drop it with \"SELECT pg_drop_replication_slot('%s');\" and then reset the capture so that every table is backfilled again, since changes have been lost)", slot, slot)
+	}
+	return nil
+}
+
+// ensureReplication verifies that the configured replication slot and publication
+// exist and are usable, creating them if they don't exist. A slot created after the
+// capture has already made some progress can't provide the changes in between, so a
+// warning is logged in that case.
+func (db *postgresDatabase) ensureReplication(ctx context.Context, connRepl *pgconn.PgConn, startLSN pglogrepl.LSN) error {
+	var slot = db.config.SlotName
+	var database string
+	if err := db.connScan.QueryRow(ctx, `SELECT current_database();`).Scan(&database); err != nil {
+		return fmt.Errorf("error querying current database: %w", err)
+	}
+
+	var info, err = getSlotInfo(ctx, db.connScan, slot)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		if err := info.Validate(slot, database); err != nil {
+			return err
+		}
+	} else {
+		logrus.WithField("slot", slot).Info("creating replication slot")
+		if _, err := pglogrepl.CreateReplicationSlot(ctx, connRepl, slot, "pgoutput", pglogrepl.CreateReplicationSlotOptions{Mode: pglogrepl.LogicalReplication}); err != nil {
+			return fmt.Errorf("error creating replication slot %q: %w", slot, err)
+		}
+		if startLSN != 0 {
+			logrus.WithFields(logrus.Fields{"slot": slot, "resumeLSN": startLSN}).Warn("replication slot was created after the capture's resume position, so changes in between may have been missed")
+		}
+	}
+
+	var exists bool
+	if err := db.connScan.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_publication WHERE pubname = $1);`, db.config.PublicationName).Scan(&exists); err != nil {
+		return fmt.Errorf("error querying publication %q: %w", db.config.PublicationName, err)
+	} else if !exists {
+		logrus.WithField("publication", db.config.PublicationName).Info("creating publication")
+		if _, err := db.connScan.Exec(ctx, fmt.Sprintf(`CREATE PUBLICATION %s FOR ALL TABLES;`, quoteIdentifier(db.config.PublicationName))); err != nil {
+			return fmt.Errorf("error creating publication %q (%s): %w", db.config.PublicationName, publicationRemediation(db.config.PublicationName), err)
+		}
+	}
+	return nil
+}
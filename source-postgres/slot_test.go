@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+)
+
+func TestSlotInfoValidate(t *testing.T) {
+	for _, tc := range []struct {
+		info   slotInfo
+		expect string
+	}{
+		{slotInfo{SlotType: "logical", Plugin: "pgoutput", Database: "flow", WALStatus: "reserved"}, ""},
+		{slotInfo{SlotType: "logical", Plugin: "wal2json", Database: "flow"}, "using plugin \"wal2json\""},
+		{slotInfo{SlotType: "physical", Database: "flow"}, "is a physical slot"},
+		{slotInfo{SlotType: "logical", Plugin: "pgoutput", Database: "other"}, "belongs to database \"other\""},
+		{slotInfo{SlotType: "logical", Plugin: "pgoutput", Database: "flow", WALStatus: "lost"}, "has been invalidated"},
+	} {
+		var err = tc.info.Validate("flow_slot", "flow")
+		if tc.expect == "" && err != nil {
+			t.Errorf("slot %#v: unexpected error: %v", tc.info, err)
+		} else if tc.expect != "" && (err == nil || !strings.Contains(err.Error(), tc.expect)) {
+			t.Errorf("slot %#v: expected error containing %q, got %v", tc.info, tc.expect, err)
+		}
+	}
+}
+
+// TestCleanup verifies that the cleanup command drops the replication slot
+// and publication created by a capture, and deletes its rows from the shared
+// watermarks table without dropping it while other slots still have rows there.
+func TestCleanup(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	cfg.SlotName = "test_cleanup_slot"
+	cfg.PublicationName = "test_cleanup_publication"
+	cfg.WatermarksTable = "public.test_cleanup_watermarks"
+	cfg.HeartbeatTable = "public.test_cleanup_heartbeats"
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "A"}, {1, "bbb"}})
+	performCapture(ctx, t, &cfg, &catalog, &state)
+	if info, err := getSlotInfo(ctx, TestDatabase, cfg.SlotName); err != nil {
+		t.Fatal(err)
+	} else if info == nil {
+		t.Fatalf("expected replication slot %q to have been created", cfg.SlotName)
+	}
+
+	dbQuery(ctx, t, fmt.Sprintf(`INSERT INTO %s (slot, watermark) VALUES ('test_cleanup_other_slot', 'other');`, cfg.WatermarksTable))
+	if err := cleanup(ctx, &cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := getSlotInfo(ctx, TestDatabase, cfg.SlotName); err != nil {
+		t.Fatal(err)
+	} else if info != nil {
+		t.Errorf("expected replication slot %q to have been dropped", cfg.SlotName)
+	}
+	var count int
+	if err := TestDatabase.QueryRow(ctx, `SELECT count(*) FROM pg_catalog.pg_publication WHERE pubname = $1;`, cfg.PublicationName).Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Errorf("expected publication %q to have been dropped", cfg.PublicationName)
+	}
+	var slots []string
+	if rows, err := TestDatabase.Query(ctx, fmt.Sprintf(`SELECT slot FROM %s;`, cfg.WatermarksTable)); err != nil {
+		t.Fatalf("expected watermarks table to remain: %v", err)
+	} else {
+		for rows.Next() {
+			var slot string
+			if err := rows.Scan(&slot); err != nil {
+				t.Fatal(err)
+			}
+			slots = append(slots, slot)
+		}
+		rows.Close()
+	}
+	if len(slots) != 1 || slots[0] != "test_cleanup_other_slot" {
+		t.Errorf("expected only the rows of other slots to remain in the watermarks table, got %q", slots)
+	}
+
+	// The table is dropped despite those rows when explicitly requested.
+	if err := cleanup(ctx, &cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := TestDatabase.QueryRow(ctx, `SELECT count(*) FROM pg_catalog.pg_tables WHERE schemaname = 'public' AND tablename = 'test_cleanup_watermarks';`).Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Errorf("expected watermarks table %q to have been dropped", cfg.WatermarksTable)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// An exportedSnapshot represents a transaction snapshot exported during the
+// creation of a temporary replication slot. The snapshot can be imported into
+// other transactions until it is closed, and reflects exactly the transactions
+// committed prior to the slot's consistent point.
+type exportedSnapshot struct {
+	Name            string        // The snapshot identifier, as used with SET TRANSACTION SNAPSHOT
+	ConsistentPoint pglogrepl.LSN // The LSN at which the snapshot is consistent with the WAL
+	conn            *pgconn.PgConn
+	tx              pgx.Tx            // The read-only transaction into which the snapshot has been imported
+	db              *postgresDatabase // The database from which the snapshot was exported
+}
+
+// ExportSnapshot exports a snapshot of the database and imports it into a new
+// read-only transaction on the scan connection, from which tables can then be
+// scanned until the snapshot is closed.
+func (db *postgresDatabase) ExportSnapshot(ctx context.Context) (sqlcapture.Snapshot, error) {
+	var snapshot, err = exportSnapshot(ctx, db.config.ConnectionURI, db.config.SlotName+"_snapshot")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.connScan.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		snapshot.Close(ctx)
+		return nil, fmt.Errorf("error beginning snapshot transaction: %w", err)
+	}
+	snapshot.tx = tx
+	snapshot.db = db
+	if _, err := tx.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", snapshot.Name)); err != nil {
+		snapshot.Close(ctx)
+		return nil, fmt.Errorf("error importing snapshot %q: %w", snapshot.Name, err)
+	}
+	return snapshot, nil
+}
+
+// exportSnapshot opens a new replication connection and creates a temporary
+// replication slot on it, exporting a snapshot of the database at the slot's
+// consistent point. The snapshot remains valid until Close() is called, which
+// also drops the temporary slot.
+//
+// A temporary slot is used so that the same mechanism works regardless of whether
+// the capture's own replication slot was created just now or long ago, at the cost
+// of briefly requiring one more available replication slot and WAL sender.
+func exportSnapshot(ctx context.Context, connectionURI, slotName string) (*exportedSnapshot, error) {
+	var connConfig, err = pgconn.ParseConfig(connectionURI)
+	if err != nil {
+		return nil, err
+	}
+	connConfig.RuntimeParams["replication"] = "database"
+	conn, err := pgconn.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database for snapshot export: %w", err)
+	}
+
+	result, err := pglogrepl.CreateReplicationSlot(ctx, conn, slotName, "pgoutput", pglogrepl.CreateReplicationSlotOptions{
+		Temporary:      true,
+		SnapshotAction: "EXPORT_SNAPSHOT",
+		Mode:           pglogrepl.LogicalReplication,
+	})
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("error creating temporary replication slot %q: %w", slotName, err)
+	}
+	consistentPoint, err := pglogrepl.ParseLSN(result.ConsistentPoint)
+	if err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("error parsing consistent point %q: %w", result.ConsistentPoint, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"slot":            slotName,
+		"snapshot":        result.SnapshotName,
+		"consistentPoint": consistentPoint,
+	}).Debug("exported snapshot")
+	return &exportedSnapshot{
+		Name:            result.SnapshotName,
+		ConsistentPoint: consistentPoint,
+		conn:            conn,
+	}, nil
+}
+
+// Cursor returns the consistent point of the snapshot.
+func (s *exportedSnapshot) Cursor() string {
+	return s.ConsistentPoint.String()
+}
+
+// ScanTableChunk fetches a chunk of rows from the specified table as of the snapshot.
+func (s *exportedSnapshot) ScanTableChunk(ctx context.Context, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*sqlcapture.ChangeEvent, error) {
+	return s.db.scanTableChunk(ctx, s.tx, streamID, keyColumns, resumeKey, filter)
+}
+
+// Close releases the exported snapshot and the temporary slot which holds it.
+func (s *exportedSnapshot) Close(ctx context.Context) error {
+	if s.tx != nil {
+		s.tx.Rollback(ctx)
+	}
+	return s.conn.Close(ctx)
+}
+
+// currentWALPosition is an SQL expression for the current WAL flush position of
+// the database. A standby can't write WAL of its own, so its position is that of
+// the last WAL it replayed from the primary instead.
+const currentWALPosition = "CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_flush_lsn() END"
+
+// CurrentCursor queries the current WAL flush position of the database. This
+// is a read-only operation, unlike writing a watermark, so it works on standbys.
+func (db *postgresDatabase) CurrentCursor(ctx context.Context) (string, error) {
+	var lsn pglogrepl.LSN
+	if err := db.connScan.QueryRow(ctx, "SELECT "+currentWALPosition+";").Scan(&lsn); err != nil {
+		return "", fmt.Errorf("error querying current WAL position: %w", err)
+	}
+	return lsn.String(), nil
+}
+
+// CompareCursors compares the positions of two LSNs in the WAL.
+func (db *postgresDatabase) CompareCursors(a, b string) (int, error) {
+	var lsnA, err = pglogrepl.ParseLSN(a)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing LSN %q: %w", a, err)
+	}
+	lsnB, err := pglogrepl.ParseLSN(b)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing LSN %q: %w", b, err)
+	}
+	switch {
+	case lsnA < lsnB:
+		return -1, nil
+	case lsnA > lsnB:
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TableConfig holds configuration options which apply to a single table,
+// as the values of the `tables` config map from "<schema>.<table>" names.
+type TableConfig struct {
+	Columns        []string `json:"columns,omitempty"`         // If set, only these columns (and the primary key) are captured
+	ExcludeColumns []string `json:"exclude_columns,omitempty"` // Columns which are never captured, unless part of the primary key
+	BackfillFilter string   `json:"backfill_filter,omitempty"` // A SQL predicate restricting which rows are backfilled
+	CursorColumn   string   `json:"cursor_column,omitempty"`   // The column whose value increases whenever a row changes, in the polling capture mode
+
+	Transforms map[string]*ColumnTransform `json:"transforms,omitempty"` // Transforms applied to the values of sensitive columns
+}
+
+// Validate checks that the table configuration is sensible.
+func (c *TableConfig) Validate() error {
+	if len(c.Columns) > 0 && len(c.ExcludeColumns) > 0 {
+		return fmt.Errorf("at most one of 'columns' and 'exclude_columns' may be set")
+	}
+	for col, transform := range c.Transforms {
+		if transform == nil {
+			return fmt.Errorf("transform of column %q must be an object", col)
+		}
+		if err := transform.Validate(); err != nil {
+			return fmt.Errorf("invalid transform of column %q: %w", col, err)
+		}
+	}
+	return nil
+}
+
+// includesColumn returns true if the named column is captured according to the
+// allow and deny lists and column transforms. A nil TableConfig captures every column.
+func (c *TableConfig) includesColumn(name string) bool {
+	if c == nil {
+		return true
+	}
+	if len(c.Columns) > 0 && !containsString(c.Columns, name) {
+		return false
+	}
+	if transform, ok := c.Transforms[name]; ok && transform.Type == transformDrop {
+		return false
+	}
+	return !containsString(c.ExcludeColumns, name)
+}
+
+// tableOptions applies the per-table configuration of a capture to the rows
+// of each table. Primary key columns are always captured, since the capture
+// can't function without them.
+type tableOptions struct {
+	configs map[string]*TableConfig // The configuration of each table, by stream ID
+	keys    map[string][]string     // The primary key columns of each table, by stream ID
+	polling bool                    // True when tables are captured by polling their cursor columns
+}
+
+func newTableOptions(configs map[string]*TableConfig, keys map[string][]string) *tableOptions {
+	var opts = &tableOptions{
+		configs: make(map[string]*TableConfig),
+		keys:    make(map[string][]string),
+	}
+	for streamID, cfg := range configs {
+		opts.configs[streamID] = cfg
+	}
+	for streamID, key := range keys {
+		opts.keys[streamID] = key
+	}
+	return opts
+}
+
+// projected returns true if some columns of the table may be excluded from capture.
+func (o *tableOptions) projected(streamID string) bool {
+	var cfg = o.configs[streamID]
+	if cfg == nil {
+		return false
+	}
+	if len(cfg.Columns) > 0 || len(cfg.ExcludeColumns) > 0 {
+		return true
+	}
+	for _, transform := range cfg.Transforms {
+		if transform.Type == transformDrop {
+			return true
+		}
+	}
+	return false
+}
+
+// includesColumn returns true if the named column of the table is captured.
+func (o *tableOptions) includesColumn(streamID, column string) bool {
+	if containsString(o.keys[streamID], column) {
+		return true
+	}
+	return o.configs[streamID].includesColumn(column)
+}
+
+// backfillFilter returns the configured backfill predicate of the table, if any.
+// When polling, rows whose cursor column is null are also excluded, since they
+// can't be ordered relative to the position of the previous poll.
+func (o *tableOptions) backfillFilter(streamID string) string {
+	var cfg = o.configs[streamID]
+	if cfg == nil {
+		return ""
+	}
+	var filter = strings.TrimSpace(cfg.BackfillFilter)
+	if o.polling && cfg.CursorColumn != "" {
+		filter = combineFilters(filter, quoteIdentifier(cfg.CursorColumn)+" IS NOT NULL")
+	}
+	return filter
+}
+
+// backfillFilters returns the configured backfill predicate of each table which has
+// one, by stream ID.
+func (o *tableOptions) backfillFilters() map[string]string {
+	var filters = make(map[string]string)
+	for streamID := range o.configs {
+		if filter := o.backfillFilter(streamID); filter != "" {
+			filters[streamID] = filter
+		}
+	}
+	return filters
+}
+
+// cursorColumns returns the configured cursor column of each table, by stream ID.
+func (o *tableOptions) cursorColumns() map[string]string {
+	var columns = make(map[string]string)
+	for streamID, cfg := range o.configs {
+		if cfg.CursorColumn != "" {
+			columns[streamID] = cfg.CursorColumn
+		}
+	}
+	return columns
+}
+
+// projectColumns returns the captured subset of a list of table columns.
+func (o *tableOptions) projectColumns(streamID string, columns []string) []string {
+	var projected []string
+	for _, col := range columns {
+		if o.includesColumn(streamID, col) {
+			projected = append(projected, col)
+		}
+	}
+	return projected
+}
+
+// scanColumns returns the list of columns which should be selected when scanning the
+// table, or nil if every column is captured. The column names of projected tables are
+// queried whenever a new scan of the table begins, and cached for subsequent chunks.
+func (db *postgresDatabase) scanColumns(ctx context.Context, conn queryer, streamID, schemaName, tableName string, start bool) ([]string, error) {
+	if !db.tables.projected(streamID) {
+		return nil, nil
+	}
+	if columns, ok := db.scanColumnNames[streamID]; ok && !start {
+		return columns, nil
+	}
+
+	var rows, err = conn.Query(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0;", quoteTableName(schemaName, tableName)))
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns of table %q: %w", streamID, err)
+	}
+	var names []string
+	for _, desc := range rows.FieldDescriptions() {
+		names = append(names, string(desc.Name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying columns of table %q: %w", streamID, err)
+	}
+
+	var columns = db.tables.projectColumns(streamID, names)
+	db.scanColumnNames[streamID] = columns
+	return columns, nil
+}
+
+// combineFilters returns the conjunction of the non-empty SQL predicates provided.
+func combineFilters(filters ...string) string {
+	var terms []string
+	for _, filter := range filters {
+		if filter != "" {
+			terms = append(terms, "("+filter+")")
+		}
+	}
+	return strings.Join(terms, " AND ")
+}
+
+func containsString(xs []string, x string) bool {
+	for _, s := range xs {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
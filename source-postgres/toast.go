@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/sirupsen/logrus"
+)
+
+// The possible values of the `unchanged_toast_policy` config option, which
+// controls what happens to unchanged TOASTed values omitted from updates.
+const (
+	unchangedToastOmit  = sqlcapture.UnchangedColumnsOmit  // Leave the column out of the record.
+	unchangedToastFetch = sqlcapture.UnchangedColumnsFetch // Query the current value of the column.
+)
+
+// FetchColumns queries the current values of unchanged TOASTed columns which were
+// omitted from an update. These may reflect changes made after the one being
+// captured. If the row no longer exists a nil map is returned.
+func (db *postgresDatabase) FetchColumns(ctx context.Context, streamID string, keyColumns []string, key []interface{}, columns []string) (map[string]interface{}, error) {
+	var query = new(strings.Builder)
+	fmt.Fprintf(query, "SELECT %s FROM %s WHERE ", quoteIdentifiers(columns), quoteStreamID(streamID))
+	for idx, colName := range keyColumns {
+		if idx > 0 {
+			query.WriteString(" AND ")
+		}
+		fmt.Fprintf(query, "%s = $%d", quoteIdentifier(colName), idx+1)
+	}
+	query.WriteString(";")
+
+	logrus.WithFields(logrus.Fields{"query": query.String(), "args": key}).Debug("fetching unchanged TOAST values")
+	var rows, err = db.connScan.Query(ctx, query.String(), key...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query %q: %w", query.String(), err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, rows.Err()
+	}
+	vals, err := rows.Values()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get row values: %w", err)
+	}
+	var fields = make(map[string]interface{})
+	for idx, col := range columns {
+		fields[col] = vals[idx]
+	}
+	if err := db.tables.transformFields(streamID, fields); err != nil {
+		return nil, fmt.Errorf("table %q: %w", streamID, err)
+	}
+	return fields, nil
+}
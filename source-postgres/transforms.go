@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The possible types of column transform.
+const (
+	transformDrop   = "drop"   // Omit the column entirely.
+	transformNull   = "null"   // Replace every value with null.
+	transformHash   = "hash"   // Replace values with the hex SHA-256 digest of the salt and value.
+	transformRedact = "redact" // Replace all but a few leading and trailing characters of values.
+)
+
+// defaultRedactMask is the character which replaces redacted characters of a value.
+const defaultRedactMask = "*"
+
+// A ColumnTransform describes how the values of a sensitive column are modified
+// before they leave the connector.
+type ColumnTransform struct {
+	Type      string `json:"type"`                 // One of 'drop', 'null', 'hash', or 'redact'
+	Salt      string `json:"salt,omitempty"`       // A secret prepended to values before hashing
+	KeepFirst int    `json:"keep_first,omitempty"` // The number of leading characters left unredacted
+	KeepLast  int    `json:"keep_last,omitempty"`  // The number of trailing characters left unredacted
+	Mask      string `json:"mask,omitempty"`       // The character which replaces redacted characters
+}
+
+// Validate checks that the column transform is sensible.
+func (t *ColumnTransform) Validate() error {
+	switch t.Type {
+	case transformDrop, transformNull, transformHash:
+	case transformRedact:
+		if t.KeepFirst < 0 || t.KeepLast < 0 {
+			return fmt.Errorf("the number of unredacted characters must be non-negative")
+		}
+		if t.Mask == "" {
+			t.Mask = defaultRedactMask
+		}
+	default:
+		return fmt.Errorf("invalid transform type %q", t.Type)
+	}
+	return nil
+}
+
+// Apply transforms a single value, which must already have been translated into
+// its JSON-encodable form. Null values remain null. Values which aren't strings are
+// hashed or redacted according to their JSON serialization.
+func (t *ColumnTransform) Apply(val interface{}) (interface{}, error) {
+	if val == nil || t.Type == transformNull {
+		return nil, nil
+	}
+	var str, ok = val.(string)
+	if !ok {
+		var bs, err = json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("error serializing value: %w", err)
+		}
+		str = string(bs)
+	}
+
+	switch t.Type {
+	case transformHash:
+		var sum = sha256.Sum256([]byte(t.Salt + str))
+		return hex.EncodeToString(sum[:]), nil
+	case transformRedact:
+		var runes = []rune(str)
+		var keepFirst, keepLast = t.KeepFirst, t.KeepLast
+		if keepFirst+keepLast >= len(runes) {
+			// Too short to reveal anything, so redact it entirely.
+			keepFirst, keepLast = 0, 0
+		}
+		var masked = len(runes) - keepFirst - keepLast
+		return string(runes[:keepFirst]) + strings.Repeat(t.Mask, masked) + string(runes[len(runes)-keepLast:]), nil
+	}
+	return val, nil
+}
+
+// JSONSchema returns the JSON schema of the transformed values of a column, given
+// the schema of the original values and whether they may be null.
+func (t *ColumnTransform) JSONSchema(original string, nullable bool) string {
+	var schema = original
+	switch t.Type {
+	case transformNull:
+		return `{"type":"null"}`
+	case transformHash:
+		schema = `{"type":"string","contentEncoding":"hex","description":"SHA-256 hash of the column value"}`
+	case transformRedact:
+		schema = `{"type":"string","description":"Partially redacted column value"}`
+	}
+	if nullable {
+		schema = fmt.Sprintf(`{"anyOf":[%s,{"type":"null"}]}`, schema)
+	}
+	return schema
+}
+
+// transform returns the configured transform of a column, or nil if it has none.
+func (o *tableOptions) transform(streamID, column string) *ColumnTransform {
+	if cfg := o.configs[streamID]; cfg != nil {
+		return cfg.Transforms[column]
+	}
+	return nil
+}
+
+// transformFields applies the configured column transforms of a table to a row.
+// Dropped columns are never read in the first place, so there's nothing to do
+// for them here.
+func (o *tableOptions) transformFields(streamID string, fields map[string]interface{}) error {
+	var cfg = o.configs[streamID]
+	if cfg == nil || len(cfg.Transforms) == 0 {
+		return nil
+	}
+	for col, transform := range cfg.Transforms {
+		var val, ok = fields[col]
+		if !ok || transform.Type == transformDrop {
+			continue
+		}
+		translated, err := translateRecordField(val)
+		if err != nil {
+			return fmt.Errorf("error translating column %q value: %w", col, err)
+		}
+		transformed, err := transform.Apply(translated)
+		if err != nil {
+			return fmt.Errorf("error transforming column %q: %w", col, err)
+		}
+		fields[col] = transformed
+	}
+	return nil
+}
+
+// checkTransforms verifies that no key column of any table is transformed,
+// since the capture relies on the actual key values to order and patch rows.
+func (o *tableOptions) checkTransforms() error {
+	for streamID, cfg := range o.configs {
+		for col := range cfg.Transforms {
+			if containsString(o.keys[streamID], col) {
+				return fmt.Errorf("table %q: key column %q cannot be transformed", streamID, col)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+)
+
+func TestColumnTransformApply(t *testing.T) {
+	for _, tc := range []struct {
+		transform ColumnTransform
+		input     interface{}
+		expect    interface{}
+	}{
+		{ColumnTransform{Type: "null"}, "alice@example.com", nil},
+		{ColumnTransform{Type: "hash", Salt: "pepper"}, "alice@example.com", "8b8d9adc4875c0dca816e3e17b7ac87b45e40945b731fa02e3b42bf101589e21"},
+		{ColumnTransform{Type: "hash", Salt: "pepper"}, nil, nil},
+		{ColumnTransform{Type: "redact", KeepLast: 4}, "123-45-6789", "*******6789"},
+		{ColumnTransform{Type: "redact", KeepFirst: 1, KeepLast: 1, Mask: "#"}, "héllo", "h###o"},
+		{ColumnTransform{Type: "redact", KeepFirst: 2, KeepLast: 2}, "abc", "***"},
+		{ColumnTransform{Type: "redact", KeepLast: 2}, 12345, "***45"},
+	} {
+		if err := tc.transform.Validate(); err != nil {
+			t.Fatalf("invalid transform %#v: %v", tc.transform, err)
+		}
+		var actual, err = tc.transform.Apply(tc.input)
+		if err != nil {
+			t.Fatalf("error applying transform %#v: %v", tc.transform, err)
+		}
+		if actual != tc.expect {
+			t.Errorf("transform %q of %v: expected %v, got %v", tc.transform.Type, tc.input, tc.expect, actual)
+		}
+	}
+}
+
+// TestColumnTransforms verifies that column transforms are applied to both
+// backfilled and replicated rows.
+func TestColumnTransforms(t *testing.T) {
+	var cfg, ctx = TestDefaultConfig, shortTestContext(t)
+	var tableName = createTestTable(ctx, t, "", "(id INTEGER PRIMARY KEY, email TEXT, ssn TEXT, notes TEXT, password TEXT)")
+	var catalog, state = testCatalog(tableName), sqlcapture.PersistentState{}
+	cfg.Tables = map[string]*TableConfig{
+		"public." + tableName: {Transforms: map[string]*ColumnTransform{
+			"email":    {Type: "hash", Salt: "pepper"},
+			"ssn":      {Type: "redact", KeepLast: 4, Mask: "*"},
+			"notes":    {Type: "null"},
+			"password": {Type: "drop"},
+		}},
+	}
+
+	dbInsert(ctx, t, tableName, [][]interface{}{{0, "alice@example.com", "123-45-6789", "abc", "hunter2"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "init")
+	dbInsert(ctx, t, tableName, [][]interface{}{{1, "bob@example.com", "987-65-4321", "def", "swordfish"}})
+	verifiedCapture(ctx, t, &cfg, &catalog, &state, "")
+}
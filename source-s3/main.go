@@ -7,36 +7,28 @@ import (
 	"io"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/estuary/connectors/awsauth"
 	"github.com/estuary/connectors/filesource"
 	"github.com/estuary/connectors/parser"
 )
 
 type config struct {
-	AWSAccessKeyID     string         `json:"awsAccessKeyId"`
-	AWSSecretAccessKey string         `json:"awsSecretAccessKey"`
-	AscendingKeys      bool           `json:"ascendingKeys"`
-	Bucket             string         `json:"bucket"`
-	Endpoint           string         `json:"endpoint"`
-	MatchKeys          string         `json:"matchKeys"`
-	Parser             *parser.Config `json:"parser"`
-	Prefix             string         `json:"prefix"`
-	Region             string         `json:"region"`
+	awsauth.Config
+	AscendingKeys bool           `json:"ascendingKeys"`
+	Bucket        string         `json:"bucket"`
+	Endpoint      string         `json:"endpoint"`
+	MatchKeys     string         `json:"matchKeys"`
+	Parser        *parser.Config `json:"parser"`
+	Prefix        string         `json:"prefix"`
+	Region        string         `json:"region"`
 }
 
 func (c *config) Validate() error {
 	if c.Region == "" && c.Endpoint == "" {
 		return fmt.Errorf("must supply one of 'region' or 'endpoint'")
 	}
-	if c.AWSAccessKeyID == "" && c.AWSSecretAccessKey != "" {
-		return fmt.Errorf("missing awsAccessKeyID")
-	}
-	if c.AWSAccessKeyID != "" && c.AWSSecretAccessKey == "" {
-		return fmt.Errorf("missing awsSecretAccessKey")
-	}
-	return nil
+	return c.Config.Validate()
 }
 
 func (c *config) DiscoverRoot() string {
@@ -62,14 +54,6 @@ type s3Store struct {
 func newS3Store(ctx context.Context, cfg *config) (*s3Store, error) {
 	var c = aws.NewConfig()
 
-	if cfg.AWSSecretAccessKey != "" {
-		var creds = credentials.NewStaticCredentials(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, "")
-		c = c.WithCredentials(creds)
-	} else {
-		c = c.WithCredentials(credentials.AnonymousCredentials)
-	}
-	c = c.WithCredentialsChainVerboseErrors(true)
-
 	if cfg.Region != "" {
 		c = c.WithRegion(cfg.Region)
 	}
@@ -77,9 +61,10 @@ func newS3Store(ctx context.Context, cfg *config) (*s3Store, error) {
 		c = c.WithEndpoint(cfg.Endpoint)
 	}
 
-	awsSession, err := session.NewSession(c)
+	// Buckets which are public can be read without any credentials.
+	awsSession, err := cfg.NewSession(c, awsauth.MethodAnonymous)
 	if err != nil {
-		return nil, fmt.Errorf("creating aws config: %w", err)
+		return nil, err
 	}
 
 	return &s3Store{s3: s3.New(awsSession)}, nil
@@ -213,18 +198,7 @@ func main() {
 			"region"
 		],
 		"properties": {
-			"awsAccessKeyId": {
-				"type":        "string",
-				"title":       "AWS Access Key ID",
-				"description": "Part of the AWS credentials that will be used to connect to S3. Required unless the bucket is public and allows anonymous listings and reads.",
-				"default":     "example-aws-access-key-id"
-			},
-			"awsSecretAccessKey": {
-				"type":        "string",
-				"title":       "AWS Secret Access Key",
-				"description": "Part of the AWS credentials that will be used to connect to S3. Required unless the bucket is public and allows anonymous listings and reads.",
-				"default":     "example-aws-secret-access-key"
-			},
+			` + awsauth.SchemaProperties() + `,
 			"ascendingKeys": {
 				"type":        "boolean",
 				"title":       "Ascending Keys",
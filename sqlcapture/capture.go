@@ -0,0 +1,971 @@
+package sqlcapture
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/estuary/protocols/airbyte"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// PersistentState represents the part of a connector's state which can be serialized
+// and emitted in a state checkpoint, and resumed from after a restart.
+type PersistentState struct {
+	// Cursor is the position in the replication stream from which replication
+	// should resume. Its meaning is specific to the database being captured.
+	Cursor string `json:"cursor"`
+	// A mapping from table IDs (<namespace>.<table>) to table-sepcific state.
+	Streams map[string]*TableState `json:"streams"`
+}
+
+// Validate performs basic sanity-checking after a state has been parsed from JSON. More
+// detailed checks are performed by UpdateState.
+func (ps *PersistentState) Validate() error {
+	return nil
+}
+
+// pendingStreams returns the IDs of all streams which still need to be backfilled,
+// in sorted order for test stability.
+func (ps *PersistentState) pendingStreams() []string {
+	var pending []string
+	for id, tableState := range ps.Streams {
+		if tableState.Mode == tableModeBackfill {
+			pending = append(pending, id)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
+
+// TableState represents the serializable/resumable state of a particular table's capture.
+// It is mostly concerned with the "backfill" scanning process and the transition from that
+// to logical replication.
+type TableState struct {
+	// Mode is either "Backfill" during the backfill scanning process
+	// or "Active" once the backfill is complete.
+	Mode string `json:"mode"`
+	// KeyColumns is the "primary key" used for ordering/chunking the backfill scan.
+	KeyColumns []string `json:"key_columns,omitempty"`
+	// Scanned is a FoundationDB-serialized tuple representing the KeyColumns
+	// values of the last row which has been backfilled. Replication events will
+	// only be emitted for rows <= this value while backfilling is in progress.
+	// In a polling capture it's retained once the backfill completes, as the
+	// position from which the next poll of the table resumes.
+	Scanned []byte `json:"scanned,omitempty"`
+	// SnapshotCursor is the replication cursor at which the snapshot from which
+	// the table was backfilled is consistent, when using snapshot backfills. Changes
+	// from transactions committed prior to this point are already reflected in the
+	// backfilled rows and will not be emitted again.
+	SnapshotCursor string `json:"snapshot_cursor,omitempty"`
+	// BackfillFilter is a SQL predicate restricting the rows scanned by the current
+	// backfill, when a backfill of only some rows was requested via the signal table.
+	BackfillFilter string `json:"backfill_filter,omitempty"`
+	// Columns maps the column names of the table to their types, as of the most
+	// recent schema change observed in the replication stream. It is only set once
+	// such a change has been observed, and otherwise the catalog schema is used.
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+const (
+	tableModeBackfill = "Backfill"
+	tableModeActive   = "Active"
+	tableModeIgnore   = "Ignore"
+)
+
+// The possible backfill methods. The watermarks method requires write access to
+// the watermarks table, while the snapshot method requires a SnapshotDatabase.
+const (
+	BackfillMethodWatermarks = "watermarks"
+	BackfillMethodSnapshot   = "snapshot"
+)
+
+// The possible policies for columns whose unchanged values are omitted from the
+// replication stream. Fetching them requires a ValueFetcher.
+const (
+	UnchangedColumnsOmit  = "omit"  // Leave the column out of the record.
+	UnchangedColumnsFetch = "fetch" // Query the current value of the column.
+)
+
+// Options controls the optional behaviors of the capture.
+type Options struct {
+	BackfillMethod        string        // How preexisting table contents are backfilled
+	SchemaChangePolicy    string        // What happens when the columns of a table change
+	UnchangedColumnPolicy string        // What happens to unchanged values omitted from updates
+	SignalTable           string        // The stream ID of the signal table, or empty if disabled
+	HeartbeatInterval     time.Duration // How often to write heartbeats, or zero if disabled
+	BackfillMemoryLimit   int           // Bytes of buffered backfill rows held in memory before spilling to disk, or zero for no limit
+
+	BackfillFilters map[string]string // The configured predicate restricting every backfill of each stream, if any
+
+	CaptureMode       string            // Whether changes are captured by replication (the default) or by polling
+	PollInterval      time.Duration     // How often tables are polled for changes, in the polling capture mode
+	PollCursorColumns map[string]string // The cursor column of each stream, in the polling capture mode
+
+	MessagesStream      string // The stream ID of messages emitted into the replication stream, or empty if disabled
+	SchemaChangesStream string // The stream ID of the records emitted by the event schema change policy
+}
+
+// capture encapsulates the entire process of capturing data from a database with a particular
+// configuration/catalog/state and emitting records and state updates to some MessageOutput.
+type capture struct {
+	state   *PersistentState           // State read from `state.json` and emitted as updates
+	opts    *Options                   // The capture options, derived from `config.json`
+	catalog *airbyte.ConfiguredCatalog // The catalog read from `catalog.json`
+	encoder MessageOutput              // The encoder to which records and state updates are written
+
+	db         Database          // The database-specific portion of the capture
+	replStream ReplicationStream // The high-level replication stream abstraction
+
+	relations         map[string]map[string]string // The most recently observed columns (and their types) of each stream
+	backfillRequested bool                         // Set when some table needs to be backfilled again
+	heartbeats        <-chan time.Time             // Ticks whenever a heartbeat should be written, or nil if disabled
+}
+
+// MessageOutput represents "the thing to which Capture writes records and state checkpoints".
+// A json.Encoder satisfies this interface in normal usage, but during tests a custom MessageOutput
+// is used which collects output in memory.
+type MessageOutput interface {
+	Encode(v interface{}) error
+}
+
+// RunCapture is the top level of the database capture process. It is responsible for starting
+// replication, scanning tables, and then streaming replication events until shutdown conditions
+// (if any) are met. The database connections are managed by the Database implementation.
+func RunCapture(ctx context.Context, db Database, opts *Options, catalog *airbyte.ConfiguredCatalog, state *PersistentState, dest MessageOutput) error {
+	if _, ok := db.(SnapshotDatabase); !ok && opts.BackfillMethod == BackfillMethodSnapshot {
+		return fmt.Errorf("backfill method %q is not supported by this database", opts.BackfillMethod)
+	}
+	if _, ok := db.(ValueFetcher); !ok && opts.UnchangedColumnPolicy == UnchangedColumnsFetch {
+		return fmt.Errorf("unchanged column policy %q is not supported by this database", opts.UnchangedColumnPolicy)
+	}
+	if _, ok := db.(HeartbeatDatabase); !ok && opts.HeartbeatInterval > 0 {
+		return fmt.Errorf("heartbeats are not supported by this database")
+	}
+	if opts.CaptureMode == CaptureModePolling {
+		return runPolling(ctx, db, opts, catalog, state, dest)
+	}
+
+	var replStream, err = db.StartReplication(ctx, state.Cursor)
+	if err != nil {
+		return fmt.Errorf("unable to start replication stream: %w", err)
+	}
+	defer replStream.Close(ctx)
+
+	// Without a previous cursor replication begins from the current position of the
+	// database, and that position must be recorded so that a restart will resume from
+	// it rather than from wherever the database happens to be at that later time.
+	if state.Cursor == "" {
+		state.Cursor = replStream.StartCursor()
+	}
+
+	var c = &capture{
+		state:      state,
+		opts:       opts,
+		catalog:    catalog,
+		encoder:    dest,
+		db:         db,
+		replStream: replStream,
+		relations:  make(map[string]map[string]string),
+	}
+	if opts.HeartbeatInterval > 0 {
+		var ticker = time.NewTicker(opts.HeartbeatInterval)
+		defer ticker.Stop()
+		c.heartbeats = ticker.C
+	}
+
+	if err := c.updateState(ctx); err != nil {
+		return fmt.Errorf("error updating capture state: %w", err)
+	}
+
+	return c.streamChanges(ctx)
+}
+
+// runPolling is the equivalent of RunCapture for the polling capture mode, in which
+// no replication stream is used.
+func runPolling(ctx context.Context, db Database, opts *Options, catalog *airbyte.ConfiguredCatalog, state *PersistentState, dest MessageOutput) error {
+	if opts.SignalTable != "" || opts.HeartbeatInterval > 0 || opts.MessagesStream != "" {
+		return fmt.Errorf("signal tables, heartbeats, and messages are not supported in the polling capture mode")
+	}
+	var c = &capture{
+		state:     state,
+		opts:      opts,
+		catalog:   catalog,
+		encoder:   dest,
+		db:        db,
+		relations: make(map[string]map[string]string),
+	}
+	if err := c.updateState(ctx); err != nil {
+		return fmt.Errorf("error updating capture state: %w", err)
+	}
+	return c.pollChanges(ctx)
+}
+
+func (c *capture) updateState(ctx context.Context) error {
+	var stateDirty = false
+
+	// Create the Streams map if nil
+	if c.state.Streams == nil {
+		c.state.Streams = make(map[string]*TableState)
+		stateDirty = true
+	}
+
+	// Streams may be added to the catalog at various times. We need to
+	// initialize new state entries for these streams, and while we're at
+	// it this is a good time to sanity-check the primary key configuration.
+	var dbPrimaryKeys, err = c.db.DiscoverPrimaryKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("error querying database about primary keys: %w", err)
+	}
+
+	for _, catalogStream := range c.catalog.Streams {
+		var streamID = JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
+
+		// In the catalog a primary key is an array of arrays of strings, but in the
+		// case of a SQL database each of those sub-arrays must be length-1 because
+		// we're just naming a column and can't descend into individual fields.
+		var catalogPrimaryKey []string
+		for _, col := range catalogStream.PrimaryKey {
+			if len(col) != 1 {
+				return fmt.Errorf("stream %q: primary key element %q invalid", streamID, col)
+			}
+			catalogPrimaryKey = append(catalogPrimaryKey, col[0])
+		}
+
+		// The synthetic change ID which discovery suggests as the key of a keyless
+		// table isn't a real column, so it's equivalent to no key at all.
+		if len(catalogPrimaryKey) == 1 && catalogPrimaryKey[0] == ChangeIDProperty {
+			catalogPrimaryKey = nil
+		}
+
+		if streamID == c.opts.SchemaChangesStream {
+			// Schema change records are emitted as changes are observed, and aren't
+			// the rows of any table, so there's nothing to track about them.
+			continue
+		}
+		if streamID == c.opts.MessagesStream {
+			// Messages only exist in the replication stream, so there's nothing to
+			// backfill and the stream is active from the start. Its key must come
+			// from the catalog, as there's no table to discover it from.
+			if len(catalogPrimaryKey) == 0 {
+				return fmt.Errorf("stream %q: primary key must be specified", streamID)
+			}
+			if _, ok := c.state.Streams[streamID]; !ok {
+				c.state.Streams[streamID] = &TableState{Mode: tableModeActive, KeyColumns: catalogPrimaryKey}
+				stateDirty = true
+			} else if strings.Join(c.state.Streams[streamID].KeyColumns, ",") != strings.Join(catalogPrimaryKey, ",") {
+				return fmt.Errorf("stream %q: primary key %q doesn't match initialized scan key %q", streamID, catalogPrimaryKey, c.state.Streams[streamID].KeyColumns)
+			}
+			continue
+		}
+
+		// If the `PrimaryKey` property is specified in the catalog then use that,
+		// otherwise use the "native" primary key of this table in the database.
+		// Print a warning if the two are not the same.
+		var primaryKey = dbPrimaryKeys[streamID]
+		if len(primaryKey) != 0 {
+			logrus.WithFields(logrus.Fields{
+				"table": streamID,
+				"key":   primaryKey,
+			}).Debug("queried primary key")
+		}
+		if len(catalogPrimaryKey) != 0 {
+			if strings.Join(primaryKey, ",") != strings.Join(catalogPrimaryKey, ",") {
+				logrus.WithFields(logrus.Fields{
+					"stream":      streamID,
+					"catalogKey":  catalogPrimaryKey,
+					"databaseKey": primaryKey,
+				}).Warn("primary key in catalog differs from database table")
+			}
+			primaryKey = catalogPrimaryKey
+		}
+		if c.opts.CaptureMode == CaptureModePolling {
+			// Polled tables are scanned in order of their cursor column, with the
+			// primary key breaking ties between rows having the same cursor value.
+			var cursorColumn = c.opts.PollCursorColumns[streamID]
+			if cursorColumn == "" {
+				return fmt.Errorf("stream %q: a cursor column must be configured for polling", streamID)
+			}
+			if len(primaryKey) == 0 {
+				return fmt.Errorf("stream %q: a primary key is required for polling", streamID)
+			}
+			primaryKey = append([]string{cursorColumn}, primaryKey...)
+		}
+		if len(primaryKey) == 0 {
+			// Tables without any key are backfilled in the order of the row locator
+			// column, and their records are distinguished by a synthetic change ID.
+			var keylessDB, ok = c.db.(KeylessDatabase)
+			if !ok {
+				return fmt.Errorf("stream %q: primary key must be specified", streamID)
+			}
+			logrus.WithField("stream", streamID).Warn("no primary key found, capturing as a keyless table")
+			primaryKey = []string{keylessDB.RowLocatorColumn()}
+		}
+
+		// See if the stream is already initialized. If it's not, then create it.
+		var streamState, ok = c.state.Streams[streamID]
+		if !ok {
+			c.state.Streams[streamID] = &TableState{Mode: tableModeBackfill, KeyColumns: primaryKey}
+			stateDirty = true
+			continue
+		}
+
+		if strings.Join(streamState.KeyColumns, ",") != strings.Join(primaryKey, ",") {
+			return fmt.Errorf("stream %q: primary key %q doesn't match initialized scan key %q", streamID, primaryKey, streamState.KeyColumns)
+		}
+	}
+
+	// Likewise streams may be removed from the catalog, and we need to forget
+	// the corresponding state information.
+	for streamID := range c.state.Streams {
+		// List membership checks are always a pain in Go, but that's all this loop is
+		var streamExistsInCatalog = false
+		for _, catalogStream := range c.catalog.Streams {
+			var catalogStreamID = JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
+			if streamID == catalogStreamID {
+				streamExistsInCatalog = true
+			}
+		}
+
+		if !streamExistsInCatalog {
+			logrus.WithField("stream", streamID).Info("stream removed from catalog")
+			delete(c.state.Streams, streamID)
+			stateDirty = true
+		}
+	}
+
+	// If we've altered the state, emit it to stdout. This isn't strictly necessary
+	// but it helps to make the emitted sequence of state updates a lot more readable.
+	if stateDirty {
+		c.emitState(c.state)
+	}
+	return nil
+}
+
+// This is the main loop of the capture process, which interleaves replication event
+// streaming with backfill scan results as necessary.
+func (c *capture) streamChanges(ctx context.Context) error {
+	for {
+		if err := c.backfillPending(ctx); err != nil {
+			return err
+		}
+
+		// Once there is no more backfilling to do, just stream changes forever and emit
+		// state updates on every transaction commit. Streaming only stops early if some
+		// table needs to be backfilled again, in which case we go back to doing that.
+		if err := c.streamActive(ctx); err != nil {
+			return err
+		}
+		if ctx.Err() != nil || c.state.pendingStreams() == nil {
+			return nil
+		}
+	}
+}
+
+// backfillPending performs backfills of all pending streams, interleaved with
+// replication event streaming, until no pending streams remain.
+func (c *capture) backfillPending(ctx context.Context) error {
+	c.backfillRequested = false
+
+	// When backfilling from a snapshot, all pending streams are scanned up-front
+	// and become active before replication event processing begins.
+	if c.opts.BackfillMethod == BackfillMethodSnapshot {
+		if pending := c.state.pendingStreams(); pending != nil {
+			if err := c.backfillFromSnapshot(ctx, pending); err != nil {
+				return fmt.Errorf("error performing snapshot backfill: %w", err)
+			}
+		}
+	}
+
+	var results *resultSet
+	defer func() { results.Close() }()
+	for c.state.pendingStreams() != nil {
+		watermark, err := c.writeWatermark(ctx)
+		if err != nil {
+			return fmt.Errorf("error writing next watermark: %w", err)
+		}
+		if err := c.streamToWatermark(ctx, watermark, results); err != nil {
+			return fmt.Errorf("error streaming until watermark: %w", err)
+		} else if err := c.emitBuffered(results); err != nil {
+			return fmt.Errorf("error emitting buffered results: %w", err)
+		} else if err := results.Close(); err != nil {
+			return fmt.Errorf("error closing buffered results: %w", err)
+		}
+		results, err = c.backfillStreams(ctx, c.state.pendingStreams())
+		if err != nil {
+			return fmt.Errorf("error performing backfill: %w", err)
+		}
+	}
+	return nil
+}
+
+// streamActive streams replication events once all backfills are complete. In tail
+// mode this continues indefinitely, and otherwise it continues until the present
+// end of the replication stream has been reached.
+func (c *capture) streamActive(ctx context.Context) error {
+	if !c.catalog.Tail && c.opts.BackfillMethod == BackfillMethodSnapshot {
+		var target, err = c.db.(SnapshotDatabase).CurrentCursor(ctx)
+		if err != nil {
+			return fmt.Errorf("error querying current replication position: %w", err)
+		}
+		logrus.WithField("target", target).Info("streaming until replication position")
+		return c.streamToPosition(ctx, target, nil)
+	}
+
+	var targetWatermark = "nonexistent-watermark"
+	if !c.catalog.Tail {
+		var watermark, err = c.writeWatermark(ctx)
+		if err != nil {
+			return fmt.Errorf("error writing poll watermark: %w", err)
+		}
+		targetWatermark = watermark
+	}
+	logrus.WithFields(logrus.Fields{
+		"tail":      c.catalog.Tail,
+		"watermark": targetWatermark,
+	}).Info("streaming until watermark")
+	return c.streamToWatermark(ctx, targetWatermark, nil)
+}
+
+// writeWatermark writes a new random UUID into the watermarks table and returns it.
+func (c *capture) writeWatermark(ctx context.Context) (string, error) {
+	var watermark = uuid.New().String()
+	if err := c.db.WriteWatermark(ctx, watermark); err != nil {
+		return "", err
+	}
+	logrus.WithField("watermark", watermark).Debug("wrote watermark")
+	return watermark, nil
+}
+
+func (c *capture) streamToWatermark(ctx context.Context, watermark string, results *resultSet) error {
+	var watermarkReached = false
+	var backfilling = c.state.pendingStreams() != nil
+	for {
+		var event, err = c.nextEvent(ctx)
+		if err != nil {
+			return err
+		} else if event == nil {
+			return nil
+		}
+
+		// Commit events update the current cursor and trigger a state update. If this
+		// is the commit after the target watermark, it also ends the loop. Outside of
+		// the backfill process the loop also ends early if a backfill has been requested.
+		if event.Type == "Commit" {
+			c.state.Cursor = event.Cursor
+			if err := c.emitState(c.state); err != nil {
+				return fmt.Errorf("error emitting state update: %w", err)
+			}
+			if watermarkReached || (c.backfillRequested && !backfilling) {
+				return nil
+			}
+			continue
+		}
+
+		// Note when the expected watermark is finally observed. The subsequent Commit will exit the loop.
+		var streamID = JoinStreamID(event.Namespace, event.Table)
+		if streamID == c.db.WatermarksTable() {
+			logrus.WithFields(logrus.Fields{"expected": watermark, "written": event.Fields["watermark"]}).Debug("watermark write")
+			if event.Fields["watermark"] == watermark {
+				watermarkReached = true
+			}
+		}
+
+		if err := c.handleReplicationEvent(ctx, event, results); err != nil {
+			return err
+		}
+	}
+}
+
+// nextEvent returns the next event from the replication stream, or nil once the
+// stream has ended. While waiting for an event any heartbeats which come due are
+// written, so that the replication stream will observe their commits even when
+// nothing else is changing. Heartbeats are written from here rather than in the
+// background because they share a database connection with table scanning.
+func (c *capture) nextEvent(ctx context.Context) (*ChangeEvent, error) {
+	for {
+		select {
+		case event, ok := <-c.replStream.Events():
+			if !ok {
+				return nil, nil
+			}
+			return event, nil
+		case <-c.heartbeats:
+			logrus.Debug("writing heartbeat")
+			if err := c.db.(HeartbeatDatabase).WriteHeartbeat(ctx); err != nil {
+				return nil, fmt.Errorf("error writing heartbeat: %w", err)
+			}
+		}
+	}
+}
+
+// streamToPosition streams replication events until the replication stream has
+// progressed past the target cursor, either by a transaction commit at or beyond
+// that point or by a keepalive reporting that the database has no further changes
+// for us prior to it. Unlike streamToWatermark this requires no writes to the
+// database, and is used when the capture must not write to the database.
+func (c *capture) streamToPosition(ctx context.Context, target string, results *resultSet) error {
+	var snapshotDB = c.db.(SnapshotDatabase)
+	for {
+		var event, err = c.nextEvent(ctx)
+		if err != nil {
+			return err
+		} else if event == nil {
+			return nil
+		}
+
+		if event.Type == "Commit" || event.Type == "KeepAlive" {
+			cmp, err := snapshotDB.CompareCursors(event.Cursor, target)
+			if err != nil {
+				return fmt.Errorf("error comparing replication cursors: %w", err)
+			}
+			if event.Type == "KeepAlive" {
+				if cmp >= 0 {
+					return nil
+				}
+				continue
+			}
+			c.state.Cursor = event.Cursor
+			if err := c.emitState(c.state); err != nil {
+				return fmt.Errorf("error emitting state update: %w", err)
+			}
+			if cmp >= 0 || c.backfillRequested {
+				return nil
+			}
+			continue
+		}
+		if err := c.handleReplicationEvent(ctx, event, results); err != nil {
+			return err
+		}
+	}
+}
+
+// handleReplicationEvent processes a single Insert/Update/Delete event from the
+// replication stream according to the current state of the table it belongs to.
+func (c *capture) handleReplicationEvent(ctx context.Context, event *ChangeEvent, results *resultSet) error {
+	// Keepalives are only of interest when streaming to a specific position.
+	if event.Type == "KeepAlive" {
+		return nil
+	}
+
+	// Rows inserted into the signal table request backfills of other tables.
+	var streamID = JoinStreamID(event.Namespace, event.Table)
+	if c.opts.SignalTable != "" && streamID == c.opts.SignalTable {
+		if event.Type == "Insert" {
+			return c.handleSignal(event, results)
+		}
+		return nil
+	}
+
+	// Handle the easy cases: Events on ignored or fully-active tables.
+	var tableState = c.state.Streams[streamID]
+	if tableState == nil || tableState.Mode == tableModeIgnore {
+		logrus.WithFields(logrus.Fields{"stream": streamID, "type": event.Type}).Debug("ignoring stream")
+		return nil
+	}
+
+	// Relation events describe the current columns of a table, and are checked
+	// for differences from what we previously knew about the table.
+	if event.Type == "Relation" {
+		return c.handleRelation(streamID, event, results)
+	}
+
+	// Changes to rows outside the key range of this capture shard are handled by
+	// another shard, and have no bearing on what this one emits or buffers.
+	if owned, err := c.ownsRow(streamID, tableState.KeyColumns, event.Fields); err != nil {
+		return err
+	} else if !owned {
+		return nil
+	}
+
+	// Unchanged values may be omitted from the replication stream, so they have
+	// to be filled in from elsewhere if possible.
+	if len(event.Unchanged) > 0 {
+		if err := c.fillUnchanged(ctx, streamID, event); err != nil {
+			return fmt.Errorf("error filling unchanged values: %w", err)
+		}
+	}
+	if tableState.Mode == tableModeActive {
+		// Changes committed prior to the snapshot from which a table was backfilled
+		// are already reflected in the backfilled rows.
+		if tableState.SnapshotCursor != "" {
+			var cmp, err = c.db.(SnapshotDatabase).CompareCursors(event.Cursor, tableState.SnapshotCursor)
+			if err != nil {
+				return fmt.Errorf("error comparing replication cursors: %w", err)
+			}
+			if cmp < 0 {
+				logrus.WithFields(logrus.Fields{"stream": streamID, "cursor": event.Cursor, "snapshot": tableState.SnapshotCursor}).Debug("filtering change prior to snapshot")
+				return nil
+			}
+		}
+		if err := c.handleChangeEvent(event); err != nil {
+			return fmt.Errorf("error handling replication event: %w", err)
+		}
+		return nil
+	}
+	if tableState.Mode != tableModeBackfill {
+		return fmt.Errorf("table %q in invalid mode %q", streamID, tableState.Mode)
+	}
+
+	// Changes to a keyless table can't be related to the scan point at all, so they're
+	// always emitted. Consequently a row modified while the backfill is in progress may
+	// be emitted by both the scan and replication.
+	if c.isKeyless(tableState.KeyColumns) {
+		if err := c.handleChangeEvent(event); err != nil {
+			return fmt.Errorf("error handling replication event: %w", err)
+		}
+		return nil
+	}
+
+	// A filtered backfill only scans some rows of the table, so changes to rows after
+	// the scan point can't be left for the scan to observe. They're emitted as soon as
+	// they occur, and patched into the buffered resultSet as well so that rows of the
+	// current chunk aren't subsequently emitted with stale values. Consequently a row
+	// modified while the backfill is in progress may be emitted by both.
+	if c.filteredBackfill(streamID) {
+		if err := results.Patch(streamID, copyEvent(event)); err != nil {
+			return fmt.Errorf("error patching resultset: %w", err)
+		}
+		if err := c.handleChangeEvent(event); err != nil {
+			return fmt.Errorf("error handling replication event: %w", err)
+		}
+		return nil
+	}
+
+	// While a table is being backfilled, events occurring *before* the current scan point
+	// will be emitted, while events *after* that point will be patched (or ignored) into
+	// the buffered resultSet.
+	var rowKey, err = encodeRowKey(tableState.KeyColumns, event.Fields)
+	if err != nil {
+		return fmt.Errorf("error encoding row key: %w", err)
+	}
+	if compareTuples(rowKey, tableState.Scanned) <= 0 {
+		if err := c.handleChangeEvent(event); err != nil {
+			return fmt.Errorf("error handling replication event: %w", err)
+		}
+	} else if err := results.Patch(streamID, event); err != nil {
+		return fmt.Errorf("error patching resultset: %w", err)
+	}
+	return nil
+}
+
+// filteredBackfill returns true if the current backfill of a table only scans the
+// rows matching some predicate, either from a re-backfill signal or configured for
+// every backfill of the table.
+func (c *capture) filteredBackfill(streamID string) bool {
+	return c.state.Streams[streamID].BackfillFilter != "" || c.opts.BackfillFilters[streamID] != ""
+}
+
+func (c *capture) emitBuffered(results *resultSet) error {
+	// Emit any buffered results and update table states accordingly.
+	for _, streamID := range results.Streams() {
+		var events, err = results.Changes(streamID)
+		if err != nil {
+			return fmt.Errorf("error reading buffered results: %w", err)
+		}
+		for _, event := range events {
+			if err := c.handleBackfillEvent(event); err != nil {
+				return fmt.Errorf("error handling backfill change: %w", err)
+			}
+		}
+
+		if results.Complete(streamID) {
+			c.state.Streams[streamID].Mode = tableModeActive
+			c.state.Streams[streamID].Scanned = nil
+			c.state.Streams[streamID].BackfillFilter = ""
+		} else {
+			c.state.Streams[streamID].Scanned = results.Scanned(streamID)
+		}
+	}
+
+	// Emit a new state update. The global `Cursor` has been advanced by the
+	// watermark commit event, and the individual stream `Scanned` tracking for
+	// each stream has been advanced just above.
+	return c.emitState(c.state)
+}
+
+func (c *capture) backfillStreams(ctx context.Context, streams []string) (*resultSet, error) {
+	var results = newResultSet()
+	results.SetMemoryLimit(c.opts.BackfillMemoryLimit, c.db.TranslateRecordField)
+	if !IsFullRange(c.catalog.Range) {
+		results.SetFilter(c.ownsRow)
+	}
+
+	// TODO(wgd): We can dispatch these table reads concurrently with a WaitGroup
+	// for synchronization.
+	for _, streamID := range streams {
+		var streamState = c.state.Streams[streamID]
+
+		// Fetch a chunk of entries from the specified stream
+		var resumeKey, err = decodeResumeKey(streamState.KeyColumns, streamState.Scanned)
+		if err != nil {
+			return nil, err
+		}
+		events, err := c.db.ScanTableChunk(ctx, streamID, streamState.KeyColumns, resumeKey, streamState.BackfillFilter)
+		if err != nil {
+			results.Close()
+			return nil, fmt.Errorf("error scanning table: %w", err)
+		}
+
+		// Translate the resulting list of entries into a backfillChunk
+		if err := results.Buffer(streamID, streamState.KeyColumns, events); err != nil {
+			results.Close()
+			return nil, fmt.Errorf("error buffering scan results: %w", err)
+		}
+	}
+	return results, nil
+}
+
+// decodeResumeKey unpacks the serialized key of the last row scanned from a table
+// into the list of key column values from which the next scan should resume, or
+// nil if the scan should start from the beginning.
+func decodeResumeKey(keyColumns []string, scanned []byte) ([]interface{}, error) {
+	if scanned == nil {
+		return nil, nil
+	}
+	var resumeKey, err = unpackTuple(scanned)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking encoded tuple: %w", err)
+	}
+	if len(resumeKey) != len(keyColumns) {
+		return nil, fmt.Errorf("expected %d primary-key values but got %d", len(keyColumns), len(resumeKey))
+	}
+	return resumeKey, nil
+}
+
+// isKeyless returns true if the specified key columns denote a keyless table,
+// which is backfilled in the order of the database's row locator column.
+func (c *capture) isKeyless(keyColumns []string) bool {
+	var keylessDB, ok = c.db.(KeylessDatabase)
+	return ok && len(keyColumns) == 1 && keyColumns[0] == keylessDB.RowLocatorColumn()
+}
+
+// fillUnchanged fills in the unchanged columns of an update event where possible.
+// Values in the before-image of the event (which is complete when the database
+// logs entire rows) are always used, since they're exactly the values the row had
+// at the time. Otherwise, if the policy is to fetch them, the current values are
+// queried from the table, and these may reflect changes made after the one being
+// captured. Any columns which still can't be filled in are left in the `Unchanged`
+// list of the event.
+func (c *capture) fillUnchanged(ctx context.Context, streamID string, event *ChangeEvent) error {
+	var remaining []string
+	for _, col := range event.Unchanged {
+		if val, ok := event.Before[col]; ok {
+			event.Fields[col] = val
+		} else {
+			remaining = append(remaining, col)
+		}
+	}
+	event.Unchanged = remaining
+	if len(remaining) == 0 || c.opts.UnchangedColumnPolicy != UnchangedColumnsFetch {
+		return nil
+	}
+
+	var keyColumns = c.state.Streams[streamID].KeyColumns
+	if c.isKeyless(keyColumns) {
+		logrus.WithFields(logrus.Fields{"stream": streamID, "columns": remaining}).Debug("cannot fetch unchanged values of keyless table")
+		return nil
+	}
+	var key []interface{}
+	for _, col := range keyColumns {
+		key = append(key, event.Fields[col])
+	}
+
+	var values, err = c.db.(ValueFetcher).FetchColumns(ctx, streamID, keyColumns, key, remaining)
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		// The row has since been deleted, so its values are gone for good.
+		logrus.WithFields(logrus.Fields{"stream": streamID, "columns": remaining}).Debug("row deleted before unchanged values could be fetched")
+		return nil
+	}
+	for _, col := range remaining {
+		event.Fields[col] = values[col]
+	}
+	event.Unchanged = nil
+	return nil
+}
+
+// backfillFromSnapshot scans the entirety of each of the specified streams from a
+// single snapshot, emitting every row and then marking the streams active. Because
+// the snapshot can't outlive this process, a table whose scan is interrupted will
+// be scanned again from the beginning after a restart.
+func (c *capture) backfillFromSnapshot(ctx context.Context, streams []string) error {
+	var snapshot, err = c.db.(SnapshotDatabase).ExportSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close(ctx)
+
+	for _, streamID := range streams {
+		var streamState = c.state.Streams[streamID]
+		logrus.WithFields(logrus.Fields{
+			"stream": streamID,
+			"cursor": snapshot.Cursor(),
+		}).Info("backfilling stream from snapshot")
+
+		var scanned []byte
+		for {
+			var resumeKey, err = decodeResumeKey(streamState.KeyColumns, scanned)
+			if err != nil {
+				return err
+			}
+			events, err := snapshot.ScanTableChunk(ctx, streamID, streamState.KeyColumns, resumeKey, streamState.BackfillFilter)
+			if err != nil {
+				return fmt.Errorf("error scanning table: %w", err)
+			}
+			if len(events) == 0 {
+				break
+			}
+
+			// The resume key must be computed before the events are emitted, since
+			// emitting an event translates its field values in place.
+			nextKey, err := encodeRowKey(streamState.KeyColumns, events[len(events)-1].Fields)
+			if err != nil {
+				return fmt.Errorf("error encoding row key: %w", err)
+			}
+			if scanned != nil && compareTuples(scanned, nextKey) >= 0 {
+				return fmt.Errorf("primary key ordering failure: prev=%q, next=%q", scanned, nextKey)
+			}
+			if events, err = c.ownedRows(streamID, streamState.KeyColumns, events); err != nil {
+				return err
+			}
+			for _, event := range events {
+				if err := c.handleBackfillEvent(event); err != nil {
+					return fmt.Errorf("error handling backfill change: %w", err)
+				}
+			}
+			scanned = nextKey
+		}
+
+		// Changes prior to the snapshot are normally skipped, but those to rows which
+		// a filtered backfill didn't scan have to be emitted from replication, so in
+		// that case all of them are emitted. A row matching the filter which changed
+		// in the meantime may therefore be emitted with older values after the scan,
+		// though its final values are always emitted last.
+		streamState.SnapshotCursor = snapshot.Cursor()
+		if c.filteredBackfill(streamID) {
+			streamState.SnapshotCursor = ""
+		}
+		streamState.Mode = tableModeActive
+		streamState.Scanned = nil
+		streamState.BackfillFilter = ""
+		if err := c.emitState(c.state); err != nil {
+			return fmt.Errorf("error emitting state update: %w", err)
+		}
+	}
+	return nil
+}
+
+// handleChangeEvent emits a change event received via replication.
+func (c *capture) handleChangeEvent(event *ChangeEvent) error {
+	return c.emitChange(event, event.Type)
+}
+
+// handleBackfillEvent emits a row observed by a backfill scan, or the result of
+// patching such a row with replicated changes.
+func (c *capture) handleBackfillEvent(event *ChangeEvent) error {
+	return c.emitChange(event, operationBackfill)
+}
+
+// MetadataProperty is the name of the property holding the change metadata of
+// each record, which describes the source and nature of the change.
+const MetadataProperty = "_meta"
+
+// ChangeIDProperty is the name of the synthetic property which uniquely identifies
+// each change event captured from a keyless table.
+const ChangeIDProperty = "_change_id"
+
+// operationBackfill is the `_meta.op` value of records produced by a backfill.
+// Replicated changes use the event type (Insert, Update, or Delete) instead.
+const operationBackfill = "Backfill"
+
+func (c *capture) emitChange(event *ChangeEvent, op string) error {
+	event.Fields["_change_type"] = event.Type
+
+	var streamID = JoinStreamID(event.Namespace, event.Table)
+	if tableState, ok := c.state.Streams[streamID]; ok && c.isKeyless(tableState.KeyColumns) {
+		delete(event.Fields, tableState.KeyColumns[0])
+		event.Fields[ChangeIDProperty] = event.ChangeID
+	}
+
+	for id, val := range event.Fields {
+		var translated, err = c.db.TranslateRecordField(val)
+		if err != nil {
+			logrus.WithField("val", val).Error("value translation error")
+			return fmt.Errorf("error translating field %q value: %w", id, err)
+		}
+		event.Fields[id] = translated
+	}
+
+	// The metadata of replicated changes includes database-specific details of
+	// their origin, which backfilled rows lack. The list of unchanged columns is
+	// named after PostgreSQL TOAST, the source of such omissions.
+	var meta = map[string]interface{}{
+		"op":     op,
+		"schema": event.Namespace,
+		"table":  event.Table,
+	}
+	for key, val := range event.Source {
+		meta[key] = val
+	}
+	if event.Before != nil {
+		var before = make(map[string]interface{}, len(event.Before))
+		for id, val := range event.Before {
+			var translated, err = c.db.TranslateRecordField(val)
+			if err != nil {
+				return fmt.Errorf("error translating field %q previous value: %w", id, err)
+			}
+			before[id] = translated
+		}
+		meta["before"] = before
+	}
+	if len(event.Unchanged) > 0 {
+		meta["unchanged_toast"] = event.Unchanged
+	}
+	event.Fields[MetadataProperty] = meta
+	return c.emitRecord(event.Namespace, event.Table, event.Fields)
+}
+
+func (c *capture) emitRecord(ns, stream string, data interface{}) error {
+	var rawData, err = json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("error encoding record data: %w", err)
+	}
+	return c.emit(airbyte.Message{
+		Type: airbyte.MessageTypeRecord,
+		Record: &airbyte.Record{
+			Namespace: ns,
+			Stream:    stream,
+			EmittedAt: time.Now().UnixNano() / int64(time.Millisecond),
+			Data:      json.RawMessage(rawData),
+		},
+	})
+}
+
+func (c *capture) emitState(state interface{}) error {
+	var rawState, err = json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding state message: %w", err)
+	}
+	return c.emit(airbyte.Message{
+		Type:  airbyte.MessageTypeState,
+		State: &airbyte.State{Data: json.RawMessage(rawState)},
+	})
+}
+
+func (c *capture) emit(msg interface{}) error {
+	return c.encoder.Encode(msg)
+}
+
+// JoinStreamID combines a namespace and a stream name into a fully-qualified
+// stream (or table) identifier. Identifiers are case-sensitive and are carried
+// in their exact catalog form.
+func JoinStreamID(namespace, stream string) string {
+	return namespace + "." + stream
+}
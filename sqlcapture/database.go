@@ -0,0 +1,124 @@
+package sqlcapture
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ChangeEvent represents an Insert/Update/Delete operation on a source
+// table, or one of the other events ("Commit", "KeepAlive", and "Relation")
+// which a ReplicationStream uses to tell the capture about its progress.
+type ChangeEvent struct {
+	Type string
+
+	// Cursor is the position of the event in the replication stream. For Commit
+	// events this is a position from which replication may resume, and for
+	// KeepAlive events it's the position up to which the database has no further
+	// changes for us. Change events may use the cursor of their transaction.
+	Cursor string
+
+	// ChangeID uniquely identifies a change, and is used as the key of records
+	// from tables without any primary key. Within the rows of a single backfill
+	// or the changes of a single transaction the IDs must sort in order.
+	ChangeID string
+
+	// Source holds database-specific information about the origin of a change,
+	// which is included in the `_meta` property of the resulting record.
+	Source map[string]interface{}
+
+	Namespace string
+	Table     string
+	Fields    map[string]interface{}
+	Before    map[string]interface{} // The previous values of the row, for updates and deletes when available
+	Unchanged []string               // Columns whose unchanged values are omitted from Fields
+	Columns   map[string]string      // Only set for "Relation" events, mapping column names to type names
+}
+
+// Database represents the operations which must be performed on a specific database
+// during the capture process.
+type Database interface {
+	// StartReplication opens a stream of change events from the specified cursor, or
+	// from the current position of the database if the cursor is empty.
+	StartReplication(ctx context.Context, startCursor string) (ReplicationStream, error)
+	// WriteWatermark writes the specified string into the watermarks table, so that
+	// it will later be observed in the replication stream.
+	WriteWatermark(ctx context.Context, watermark string) error
+	// WatermarksTable returns the stream ID of the watermarks table.
+	WatermarksTable() string
+	// ScanTableChunk fetches a chunk of rows from the specified table, ordered by the
+	// key columns, and resuming after the specified key values if non-nil. If the
+	// filter is non-empty only rows matching that SQL predicate are returned.
+	ScanTableChunk(ctx context.Context, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*ChangeEvent, error)
+	// DiscoverPrimaryKeys returns the primary key columns of each table, by stream ID.
+	DiscoverPrimaryKeys(ctx context.Context) (map[string][]string, error)
+	// TranslateRecordField converts a value obtained from the database into an
+	// appropriate JSON-encodable output format.
+	TranslateRecordField(val interface{}) (interface{}, error)
+	// ColumnJSONSchema returns the JSON schema of a non-null value of the named
+	// column type, or nil if the type is unknown.
+	ColumnJSONSchema(typeName string) json.RawMessage
+}
+
+// ReplicationStream represents the process of receiving change events from a
+// database, in the order in which they were committed.
+type ReplicationStream interface {
+	// Events returns the channel of change events, which is closed when the
+	// stream ends.
+	Events() <-chan *ChangeEvent
+	// StartCursor returns the cursor from which the stream began.
+	StartCursor() string
+	// Close stops the replication stream.
+	Close(ctx context.Context) error
+}
+
+// A KeylessDatabase can capture tables which have no primary key, by scanning them
+// in the order of a system column which locates each row.
+type KeylessDatabase interface {
+	// RowLocatorColumn returns the name of the system column used as the scan key
+	// of keyless tables. Scanned rows include it as a field, but it's omitted from
+	// the resulting records.
+	RowLocatorColumn() string
+}
+
+// A SnapshotDatabase can backfill tables from a consistent snapshot of the database,
+// without any writes, as an alternative to the watermark process.
+type SnapshotDatabase interface {
+	// ExportSnapshot captures a snapshot of the database, which remains readable
+	// until it's closed.
+	ExportSnapshot(ctx context.Context) (Snapshot, error)
+	// CurrentCursor returns the current position of the database in the replication
+	// stream, without writing anything.
+	CurrentCursor(ctx context.Context) (string, error)
+	// CompareCursors returns an integer comparing the positions of two cursors,
+	// in the manner of `strings.Compare`.
+	CompareCursors(a, b string) (int, error)
+}
+
+// A Snapshot is a consistent view of a database as of a particular point in the
+// replication stream.
+type Snapshot interface {
+	// Cursor returns the position in the replication stream at which the snapshot
+	// is consistent. Changes committed before this point are reflected in it.
+	Cursor() string
+	// ScanTableChunk behaves like Database.ScanTableChunk, but reads from the snapshot.
+	ScanTableChunk(ctx context.Context, streamID string, keyColumns []string, resumeKey []interface{}, filter string) ([]*ChangeEvent, error)
+	// Close releases the snapshot.
+	Close(ctx context.Context) error
+}
+
+// A HeartbeatDatabase can write heartbeats, which are transactions whose commits
+// appear in the replication stream. Since the replication cursor only advances on
+// commits, this allows it to advance even when none of the captured tables change.
+type HeartbeatDatabase interface {
+	// WriteHeartbeat performs a write which will produce a commit event in the
+	// replication stream.
+	WriteHeartbeat(ctx context.Context) error
+}
+
+// A ValueFetcher can query the current values of specific columns of a row, which
+// is used to fill in values omitted from the replication stream.
+type ValueFetcher interface {
+	// FetchColumns returns the current values of the named columns of the row with
+	// the specified key, or nil if no such row exists.
+	FetchColumns(ctx context.Context, streamID string, keyColumns []string, key []interface{}, columns []string) (map[string]interface{}, error)
+}
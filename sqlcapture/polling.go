@@ -0,0 +1,115 @@
+package sqlcapture
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The possible capture modes. The replication mode backfills tables and then
+// streams changes from the database's replication log, while the polling mode
+// periodically queries each table for rows whose cursor column has advanced.
+const (
+	CaptureModeReplication = "replication"
+	CaptureModePolling     = "polling"
+)
+
+// operationPoll is the `_meta.op` value of records produced by polling a table
+// after its initial backfill. Polling can't distinguish inserts from updates, and
+// can't observe deletions at all.
+const operationPoll = "Poll"
+
+// pollChanges is the main loop of a polling capture. The key columns of each stream
+// are its cursor column followed by its primary key, so that scanning a table in key
+// order visits rows in the order they were last modified, and the `Scanned` key of
+// the stream (which unlike a replication capture persists once the stream is active)
+// is the position from which the next poll resumes.
+func (c *capture) pollChanges(ctx context.Context) error {
+	for {
+		for _, streamID := range c.state.pollableStreams() {
+			if err := c.pollStream(ctx, streamID); err != nil {
+				return fmt.Errorf("error polling stream %q: %w", streamID, err)
+			}
+		}
+		if !c.catalog.Tail {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.opts.PollInterval):
+		}
+	}
+}
+
+// pollStream emits every row of the table beyond the stream's current position,
+// emitting a state update after each chunk of rows.
+func (c *capture) pollStream(ctx context.Context, streamID string) error {
+	var streamState = c.state.Streams[streamID]
+	var op = operationPoll
+	if streamState.Mode == tableModeBackfill {
+		op = operationBackfill
+	}
+	logrus.WithFields(logrus.Fields{
+		"stream": streamID,
+		"mode":   streamState.Mode,
+	}).Debug("polling stream")
+
+	for {
+		var resumeKey, err = decodeResumeKey(streamState.KeyColumns, streamState.Scanned)
+		if err != nil {
+			return err
+		}
+		events, err := c.db.ScanTableChunk(ctx, streamID, streamState.KeyColumns, resumeKey, "")
+		if err != nil {
+			return fmt.Errorf("error scanning table: %w", err)
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		// The resume key must be computed before the events are emitted, since
+		// emitting an event translates its field values in place.
+		nextKey, err := encodeRowKey(streamState.KeyColumns, events[len(events)-1].Fields)
+		if err != nil {
+			return fmt.Errorf("error encoding row key: %w", err)
+		}
+		if streamState.Scanned != nil && compareTuples(streamState.Scanned, nextKey) >= 0 {
+			return fmt.Errorf("cursor ordering failure: prev=%q, next=%q", streamState.Scanned, nextKey)
+		}
+		if events, err = c.ownedRows(streamID, streamState.KeyColumns, events); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := c.emitChange(event, op); err != nil {
+				return fmt.Errorf("error emitting polled change: %w", err)
+			}
+		}
+		streamState.Scanned = nextKey
+		if err := c.emitState(c.state); err != nil {
+			return err
+		}
+	}
+
+	if streamState.Mode == tableModeBackfill {
+		streamState.Mode = tableModeActive
+		return c.emitState(c.state)
+	}
+	return nil
+}
+
+// pollableStreams returns the IDs of all streams which are captured by polling,
+// in sorted order for test stability.
+func (ps *PersistentState) pollableStreams() []string {
+	var streams []string
+	for id, tableState := range ps.Streams {
+		if tableState.Mode == tableModeBackfill || tableState.Mode == tableModeActive {
+			streams = append(streams, id)
+		}
+	}
+	sort.Strings(streams)
+	return streams
+}
@@ -1,4 +1,4 @@
-package main
+package sqlcapture
 
 import (
 	"encoding/base64"
@@ -11,12 +11,26 @@ import (
 // A resultSet represents the buffered entries read from zero or more tables
 // being backfilled. The nil resultSet is valid and represents an absence of
 // any buffered data (this is used during startup streaming).
+//
+// If a memory limit is set then rows beyond that limit are spilled to a temporary
+// file, and the resultSet must be closed once it's no longer needed.
 type resultSet struct {
 	streams map[string]*backfillChunk
+
+	memoryLimit int                                    // The approximate size in bytes of buffered rows beyond which rows are spilled, or zero for no limit
+	memoryUsed  int                                    // The approximate size in bytes of the rows currently held in memory
+	translate   func(interface{}) (interface{}, error) // Translates field values into their JSON-encodable form when spilling
+	spill       *spillFile                             // The file to which rows are spilled, created when first needed
+
+	filter rowFilter // If set, only buffered rows for which the filter returns true are retained
 }
 
+// A rowFilter decides whether a scanned row of a stream should be retained.
+type rowFilter func(streamID string, keyColumns []string, fields map[string]interface{}) (bool, error)
+
 type backfillChunk struct {
-	rows       map[string]*changeEvent // A map from the encoded primary key of a row to the 'Insert' event for that row
+	rows       map[string]*ChangeEvent // A map from the encoded primary key of a row to the 'Insert' event for that row
+	spilled    map[string]spillEntry   // Like `rows`, but for rows which have been spilled to disk
 	keyColumns []string                // The names of the primary key columns used for this table, in order
 	scanned    []byte                  // The encoded primary key of the greatest row in the chunk (or nil when complete=true)
 	complete   bool                    // When true, indicates that this chunk *completes* the table, and thus has no precise endpoint
@@ -29,10 +43,14 @@ func newResultSet() *resultSet {
 // Buffer appends new "Insert" events to the buffered range for the specified stream. An
 // empty list of events indicates that the stream is completed, and thus the *range* of the
 // buffer now extends to infinity.
-func (r *resultSet) Buffer(streamID string, keyColumns []string, events []*changeEvent) error {
+func (r *resultSet) Buffer(streamID string, keyColumns []string, events []*ChangeEvent) error {
 	var chunk, ok = r.streams[streamID]
 	if !ok {
-		chunk = &backfillChunk{keyColumns: keyColumns, rows: make(map[string]*changeEvent)}
+		chunk = &backfillChunk{
+			keyColumns: keyColumns,
+			rows:       make(map[string]*ChangeEvent),
+			spilled:    make(map[string]spillEntry),
+		}
 		r.streams[streamID] = chunk
 	}
 
@@ -52,12 +70,19 @@ func (r *resultSet) Buffer(streamID string, keyColumns []string, events []*chang
 		}
 		if chunk.scanned != nil && compareTuples(chunk.scanned, bs) >= 0 {
 			// It's important for correctness that the ordering of serialized primary keys matches
-			// the ordering that PostgreSQL uses. Since we're already serializing every result row's
+			// the ordering that the database uses. Since we're already serializing every result row's
 			// key this is a good place to opportunistically check that invariant.
 			return fmt.Errorf("primary key ordering failure: prev=%q, next=%q", chunk.scanned, bs)
 		}
-		chunk.rows[string(bs)] = event
 		chunk.scanned = bs
+		if r.filter != nil {
+			if ok, err := r.filter(streamID, chunk.keyColumns, event.Fields); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+		}
+		r.putRow(chunk, string(bs), event)
 		if logrus.IsLevelEnabled(logrus.DebugLevel) {
 			logrus.WithFields(logrus.Fields{
 				"stream":   streamID,
@@ -67,7 +92,14 @@ func (r *resultSet) Buffer(streamID string, keyColumns []string, events []*chang
 			}).Debug("buffered scan result")
 		}
 	}
-	return nil
+	return r.spillIfNeeded(streamID, chunk)
+}
+
+// SetFilter restricts which of the rows subsequently buffered are retained. Rows which
+// are filtered out still advance the scanned range of the chunk, as they would have
+// if they were retained.
+func (r *resultSet) SetFilter(filter rowFilter) {
+	r.filter = filter
 }
 
 // Streams returns a list of all streams tracked by the resultSet.
@@ -100,9 +132,9 @@ func (r *resultSet) Scanned(streamID string) []byte {
 }
 
 // Patch modifies the buffered results to include the effect of the provided
-// changeEvent. This may simply mean ignoring the event, if it occured on a
+// ChangeEvent. This may simply mean ignoring the event, if it occured on a
 // table which is not in the resultSet or for a row which is not yet included.
-func (r *resultSet) Patch(streamID string, event *changeEvent) error {
+func (r *resultSet) Patch(streamID string, event *ChangeEvent) error {
 	// If a particular table is not represented in the backfill result-set then
 	// patching its changes is a no-op.
 	if r == nil {
@@ -140,43 +172,85 @@ func (r *resultSet) Patch(streamID string, event *changeEvent) error {
 	// all such inconsistencies by the time the next watermark is reached.
 	switch event.Type {
 	case "Insert":
-		chunk.rows[rowKey] = event
+		r.putRow(chunk, rowKey, event)
 	case "Update":
-		chunk.rows[rowKey] = &changeEvent{
+		// Unchanged TOASTed values omitted from the update are carried over
+		// from the buffered row when possible.
+		var unchanged []string
+		var prevFields map[string]interface{}
+		if len(event.Unchanged) > 0 {
+			var prev, err = r.getRow(chunk, rowKey)
+			if err != nil {
+				return err
+			} else if prev != nil {
+				prevFields = prev.Fields
+			}
+		}
+		for _, col := range event.Unchanged {
+			if val, ok := prevFields[col]; ok {
+				event.Fields[col] = val
+			} else {
+				unchanged = append(unchanged, col)
+			}
+		}
+		r.putRow(chunk, rowKey, &ChangeEvent{
 			Type:      "Insert",
 			Namespace: event.Namespace,
 			Table:     event.Table,
 			Fields:    event.Fields,
-		}
+			Unchanged: unchanged,
+		})
 	case "Delete":
-		delete(chunk.rows, rowKey)
+		r.removeRow(chunk, rowKey)
 	default:
 		return fmt.Errorf("patched invalid change type %q", event.Type)
 	}
-	return nil
+	return r.spillIfNeeded(streamID, chunk)
+}
+
+// Discard removes the specified stream from the resultSet, so that none of its
+// buffered contents will be emitted.
+func (r *resultSet) Discard(streamID string) {
+	if r == nil {
+		return
+	}
+	if chunk, ok := r.streams[streamID]; ok {
+		for key := range chunk.rows {
+			r.removeRow(chunk, key)
+		}
+	}
+	delete(r.streams, streamID)
 }
 
 // Changes returns the buffered contents of the resultSet for the specified stream,
 // including any Patch()ed mutations to that buffer.
-func (r *resultSet) Changes(streamID string) []*changeEvent {
+func (r *resultSet) Changes(streamID string) ([]*ChangeEvent, error) {
 	if r == nil {
-		return nil
+		return nil, nil
 	}
+	var chunk = r.streams[streamID]
 
 	// Sort row keys in order so that rows within a chunk will be
 	// emitted deterministically. This only really matters for testing,
 	// and it's in a relatively hot path here, so this might be low-
 	// hanging fruit for optimization if profiling suggests it's needed.
 	var keys []string
-	for key := range r.streams[streamID].rows {
+	for key := range chunk.rows {
+		keys = append(keys, key)
+	}
+	for key := range chunk.spilled {
 		keys = append(keys, key)
 	}
 	sort.Strings(keys)
 
 	// Make a list of events in the sorted order
-	var events []*changeEvent
+	var events []*ChangeEvent
 	for _, key := range keys {
-		events = append(events, r.streams[streamID].rows[key])
+		var event, err = r.getRow(chunk, key)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
 	}
-	return events
+	return events, nil
 }
@@ -0,0 +1,212 @@
+package sqlcapture
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResultSetPatch(t *testing.T) {
+	// The same sequence of patches should produce the same results whether the
+	// buffered rows are held in memory or spilled to disk.
+	for _, limit := range []int{0, 1} {
+		var results = newResultSet()
+		results.SetMemoryLimit(limit, nil)
+		var actual = patchResultSet(t, results)
+		const expect = `[{"data":"one","id":1},{"data":"two","id":2},{"data":"THREE","id":3}]`
+		if actual != expect {
+			t.Errorf("result mismatch (limit %d): expected %s, got %s", limit, expect, actual)
+		}
+		if results.Complete("test.foo") {
+			t.Errorf("result set should not complete the stream")
+		}
+		if err := results.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func patchResultSet(t *testing.T, results *resultSet) string {
+	t.Helper()
+	var row = func(changeType string, id int, data string) *ChangeEvent {
+		return &ChangeEvent{Type: changeType, Namespace: "test", Table: "foo", Fields: map[string]interface{}{"id": id, "data": data}}
+	}
+
+	if err := results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{
+		row("Insert", 1, "one"),
+		row("Insert", 3, "three"),
+		row("Insert", 5, "five"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []*ChangeEvent{
+		row("Insert", 2, "two"),
+		row("Update", 3, "THREE"),
+		row("Delete", 5, ""),
+		row("Insert", 7, "seven"), // Beyond the end of the chunk, so ignored
+	} {
+		if err := results.Patch("test.foo", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var events, err = results.Changes("test.foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []map[string]interface{}
+	for _, event := range events {
+		actual = append(actual, event.Fields)
+	}
+	bs, err := json.Marshal(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
+
+func TestResultSetFilter(t *testing.T) {
+	var results = newResultSet()
+	results.SetFilter(func(streamID string, keyColumns []string, fields map[string]interface{}) (bool, error) {
+		return fields["id"].(int)%2 == 0, nil
+	})
+	var row = func(id int) *ChangeEvent {
+		return &ChangeEvent{Type: "Insert", Namespace: "test", Table: "foo", Fields: map[string]interface{}{"id": id}}
+	}
+	if err := results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{row(1), row(2), row(3)}); err != nil {
+		t.Fatal(err)
+	}
+	var events, err = results.Changes("test.foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Fields["id"] != 2 {
+		t.Errorf("expected only row 2 to be retained, got %d rows", len(events))
+	}
+	// The scanned range still extends to the last row, even though it was filtered out.
+	expectKey, err := encodeRowKey([]string{"id"}, row(3).Fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(results.Scanned("test.foo")) != string(expectKey) {
+		t.Errorf("expected scanned key %q, got %q", expectKey, results.Scanned("test.foo"))
+	}
+}
+
+func TestResultSetSpill(t *testing.T) {
+	var results = newResultSet()
+	results.SetMemoryLimit(1, nil)
+	if err := results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{
+		{Type: "Insert", Namespace: "test", Table: "foo", Fields: map[string]interface{}{"id": 1, "big": "one", "data": "a"}},
+		{Type: "Insert", Namespace: "test", Table: "foo", Fields: map[string]interface{}{"id": 2, "big": "two", "data": "b"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if results.spill == nil || len(results.streams["test.foo"].spilled) != 2 {
+		t.Fatalf("expected buffered rows to be spilled")
+	}
+
+	// An update omitting an unchanged value must carry it over from the spilled row.
+	if err := results.Patch("test.foo", &ChangeEvent{
+		Type:      "Update",
+		Namespace: "test",
+		Table:     "foo",
+		Fields:    map[string]interface{}{"id": 2, "data": "B"},
+		Unchanged: []string{"big"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events, err = results.Changes("test.foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []map[string]interface{}
+	for _, event := range events {
+		actual = append(actual, event.Fields)
+	}
+	bs, err := json.Marshal(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = `[{"big":"one","data":"a","id":1},{"big":"two","data":"B","id":2}]`
+	if string(bs) != expect {
+		t.Errorf("result mismatch: expected %s, got %s", expect, bs)
+	}
+
+	var name = results.spill.file.Name()
+	if err := results.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spill file %q should have been removed", name)
+	}
+}
+
+func TestResultSetOrdering(t *testing.T) {
+	var results = newResultSet()
+	var err = results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{
+		{Type: "Insert", Fields: map[string]interface{}{"id": 2}},
+		{Type: "Insert", Fields: map[string]interface{}{"id": 1}},
+	})
+	if err == nil {
+		t.Fatalf("expected primary key ordering failure")
+	}
+}
+
+func TestResultSetSpillValues(t *testing.T) {
+	// Byte slices and non-finite floats must be read back from the spill file
+	// exactly as they were buffered.
+	var results = newResultSet()
+	results.SetMemoryLimit(1, nil)
+	defer results.Close()
+	if err := results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{
+		{Type: "Insert", Namespace: "test", Table: "foo", Fields: map[string]interface{}{
+			"id":    1,
+			"bytes": []byte{0, 1, 0xff},
+			"nan":   math.NaN(),
+			"inf":   float32(math.Inf(1)),
+			"ninf":  math.Inf(-1),
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(results.streams["test.foo"].spilled) != 1 {
+		t.Fatalf("expected buffered row to be spilled")
+	}
+
+	var events, err = results.Changes("test.foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected a single row, got %d", len(events))
+	}
+	var fields = events[0].Fields
+	if bs, ok := fields["bytes"].([]byte); !ok || !bytes.Equal(bs, []byte{0, 1, 0xff}) {
+		t.Errorf("expected byte slice, got %#v", fields["bytes"])
+	}
+	if f, ok := fields["nan"].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("expected NaN, got %#v", fields["nan"])
+	}
+	if f, ok := fields["inf"].(float32); !ok || !math.IsInf(float64(f), 1) {
+		t.Errorf("expected float32 +Inf, got %#v", fields["inf"])
+	}
+	if f, ok := fields["ninf"].(float64); !ok || !math.IsInf(f, -1) {
+		t.Errorf("expected -Inf, got %#v", fields["ninf"])
+	}
+
+	// Values which still can't be serialized are reported along with their column.
+	results = newResultSet()
+	results.SetMemoryLimit(1, nil)
+	defer results.Close()
+	err = results.Buffer("test.foo", []string{"id"}, []*ChangeEvent{
+		{Type: "Insert", Namespace: "test", Table: "foo", Fields: map[string]interface{}{"id": 1, "readings": []float64{1, math.NaN()}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), `field "readings"`) {
+		t.Errorf("expected an error naming the readings column, got %v", err)
+	}
+}
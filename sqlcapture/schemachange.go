@@ -0,0 +1,285 @@
+package sqlcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/estuary/protocols/airbyte"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// The possible schema change policies, which determine what happens when the columns
+// of a table change during the capture.
+const (
+	SchemaChangeLog      = "log"      // Log a warning and continue capturing
+	SchemaChangeEvent    = "event"    // Emit a record describing the change and continue capturing
+	SchemaChangeFail     = "fail"     // Fail the capture
+	SchemaChangeBackfill = "backfill" // Backfill the table again from scratch
+)
+
+// A schemaChange describes the differences between two sets of table columns.
+type schemaChange struct {
+	Added   []string        `json:"added,omitempty"`
+	Dropped []string        `json:"dropped,omitempty"`
+	Renamed []columnRename  `json:"renamed,omitempty"`
+	Retyped []columnRetyped `json:"retyped,omitempty"`
+}
+
+type columnRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type columnRetyped struct {
+	Column string `json:"column"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+func (sc *schemaChange) String() string {
+	var bs, err = json.Marshal(sc)
+	if err != nil {
+		return fmt.Sprintf("%#v", sc)
+	}
+	return string(bs)
+}
+
+// diffColumns compares two mappings from column names to types, returning nil if
+// they're equivalent. An empty type is unknown, and compatible with any other type.
+//
+// Columns aren't identified by anything but their name, so a rename is detected
+// only when a single column has been dropped and a single column of the same type
+// has been added.
+func diffColumns(prev, next map[string]string) *schemaChange {
+	var change = new(schemaChange)
+	for name, nextType := range next {
+		var prevType, ok = prev[name]
+		if !ok {
+			change.Added = append(change.Added, name)
+		} else if prevType != nextType && prevType != "" && nextType != "" {
+			change.Retyped = append(change.Retyped, columnRetyped{Column: name, From: prevType, To: nextType})
+		}
+	}
+	for name := range prev {
+		if _, ok := next[name]; !ok {
+			change.Dropped = append(change.Dropped, name)
+		}
+	}
+	if len(change.Added) == 1 && len(change.Dropped) == 1 && prev[change.Dropped[0]] == next[change.Added[0]] {
+		change.Renamed = []columnRename{{From: change.Dropped[0], To: change.Added[0]}}
+		change.Added, change.Dropped = nil, nil
+	}
+	if len(change.Added)+len(change.Dropped)+len(change.Renamed)+len(change.Retyped) == 0 {
+		return nil
+	}
+
+	// Sorted for test stability
+	sort.Strings(change.Added)
+	sort.Strings(change.Dropped)
+	sort.Slice(change.Retyped, func(i, j int) bool { return change.Retyped[i].Column < change.Retyped[j].Column })
+	return change
+}
+
+// catalogColumns extracts the columns of a stream, translated into JSON types,
+// from the JSON schema of the stream in the catalog. It returns nil if the stream
+// doesn't have a JSON schema with properties to compare against.
+func (c *capture) catalogColumns(streamID string) (map[string]string, error) {
+	for _, catalogStream := range c.catalog.Streams {
+		if JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name) != streamID {
+			continue
+		}
+		if len(catalogStream.Stream.JSONSchema) == 0 {
+			return nil, nil
+		}
+		var schema struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal(catalogStream.Stream.JSONSchema, &schema); err != nil {
+			return nil, fmt.Errorf("error parsing JSON schema of stream %q: %w", streamID, err)
+		}
+		if schema.Properties == nil {
+			return nil, nil
+		}
+		var columns = make(map[string]string)
+		for name, propertySchema := range schema.Properties {
+			if isSyntheticProperty(name) {
+				continue
+			}
+			columns[name] = nonNullableJSONType(propertySchema)
+		}
+		return columns, nil
+	}
+	return nil, nil
+}
+
+// isSyntheticProperty returns true for document properties which are added by
+// the connector rather than corresponding to a column of the table.
+func isSyntheticProperty(name string) bool {
+	return name == "_change_type" || name == ChangeIDProperty || name == MetadataProperty
+}
+
+// jsonColumns translates a mapping from column names to database type names into
+// the corresponding JSON types, so that it can be compared with the columns
+// described by a JSON schema.
+func (c *capture) jsonColumns(columns map[string]string) map[string]string {
+	var translated = make(map[string]string)
+	for name, typeName := range columns {
+		translated[name] = nonNullableJSONType(c.db.ColumnJSONSchema(typeName))
+	}
+	return translated
+}
+
+// nonNullableJSONType summarizes the JSON schema of a column as its JSON type and
+// format, so that it can be compared with other columns. The nullability of the
+// column is discarded since that isn't something we can observe in the replication
+// stream, and unknown or unrestricted types are represented by the empty string.
+func nonNullableJSONType(schema json.RawMessage) string {
+	var parsed struct {
+		Type   string            `json:"type"`
+		Format string            `json:"format"`
+		AnyOf  []json.RawMessage `json:"anyOf"`
+	}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return ""
+	}
+	if parsed.Type == "" && len(parsed.AnyOf) > 0 {
+		// Nullable columns are described as `{"anyOf":[<type>,{"type":"null"}]}`
+		for _, option := range parsed.AnyOf {
+			if optionType := nonNullableJSONType(option); optionType != "null" {
+				return optionType
+			}
+		}
+		return ""
+	}
+	if parsed.Format != "" {
+		return parsed.Type + "/" + parsed.Format
+	}
+	return parsed.Type
+}
+
+// handleRelation compares the columns of a table, as described by a Relation event,
+// with what was previously known about that table. On the first Relation event for
+// a table this is the columns recorded in the table's state when a schema change was
+// previously observed, or else the JSON schema of the stream in the catalog. Any
+// differences are handled according to the configured schema change policy.
+func (c *capture) handleRelation(streamID string, event *ChangeEvent, results *resultSet) error {
+	var tableState = c.state.Streams[streamID]
+	var change *schemaChange
+	if prev, ok := c.relations[streamID]; ok {
+		change = diffColumns(prev, event.Columns)
+	} else if tableState.Columns != nil {
+		change = diffColumns(tableState.Columns, event.Columns)
+	} else {
+		var catalogColumns, err = c.catalogColumns(streamID)
+		if err != nil {
+			return err
+		}
+		if catalogColumns != nil {
+			change = diffColumns(catalogColumns, c.jsonColumns(event.Columns))
+		}
+	}
+	c.relations[streamID] = event.Columns
+	if change == nil {
+		return nil
+	}
+
+	// Remember the new columns across restarts, since otherwise the same change
+	// would be detected again relative to the catalog.
+	tableState.Columns = event.Columns
+
+	var log = logrus.WithFields(logrus.Fields{
+		"stream": streamID,
+		"change": change.String(),
+		"policy": c.opts.SchemaChangePolicy,
+	})
+	switch c.opts.SchemaChangePolicy {
+	case SchemaChangeLog:
+		log.Warn("table schema changed")
+		return nil
+	case SchemaChangeEvent:
+		log.Warn("table schema changed")
+		return c.emitSchemaChange(event, change)
+	case SchemaChangeFail:
+		return fmt.Errorf("schema of table %q changed: %s", streamID, change)
+	case SchemaChangeBackfill:
+		log.Warn("table schema changed, backfilling table again")
+		c.requestBackfill(streamID, results)
+		return nil
+	}
+	return fmt.Errorf("invalid schema change policy %q", c.opts.SchemaChangePolicy)
+}
+
+// schemaChangesStreamSchema is the JSON schema of the records of the schema changes
+// stream, each of which describes a change to the columns of some captured table.
+const schemaChangesStreamSchema = `{"type":"object",` +
+	`"required":["_change_id","_change_type","schema","table","_schema_change"],` +
+	`"properties":{` +
+	`"_change_id":{"type":"string","description":"Unique identifier of this schema change"},` +
+	`"_change_type":{"type":"string","enum":["SchemaChange"]},` +
+	`"schema":{"type":"string","description":"The schema of the changed table"},` +
+	`"table":{"type":"string","description":"The name of the changed table"},` +
+	`"_schema_change":{"type":"object","description":"The columns of the table which were added, dropped, renamed, or retyped","properties":{` +
+	`"added":{"type":"array","items":{"type":"string"}},` +
+	`"dropped":{"type":"array","items":{"type":"string"}},` +
+	`"renamed":{"type":"array","items":{"type":"object","required":["from","to"],"properties":{"from":{"type":"string"},"to":{"type":"string"}}}},` +
+	`"retyped":{"type":"array","items":{"type":"object","required":["column","from","to"],"properties":{"column":{"type":"string"},"from":{"type":"string"},"to":{"type":"string"}}}}` +
+	`}}}}`
+
+// SchemaChangesStream returns the catalog stream of the records emitted by the event
+// schema change policy, given its stream ID of the form "<namespace>.<name>". The
+// records don't belong to any row, so each is keyed by a unique change ID.
+func SchemaChangesStream(streamID string) airbyte.Stream {
+	var parts = strings.SplitN(streamID, ".", 2)
+	return airbyte.Stream{
+		Name:                    parts[1],
+		Namespace:               parts[0],
+		JSONSchema:              json.RawMessage(schemaChangesStreamSchema),
+		SupportedSyncModes:      airbyte.AllSyncModes,
+		SourceDefinedCursor:     true,
+		SourceDefinedPrimaryKey: [][]string{{ChangeIDProperty}},
+	}
+}
+
+// emitSchemaChange emits a record describing a change to the columns of a table into
+// the schema changes stream. Nothing is emitted if that stream isn't in the catalog.
+func (c *capture) emitSchemaChange(event *ChangeEvent, change *schemaChange) error {
+	var parts = strings.SplitN(c.opts.SchemaChangesStream, ".", 2)
+	if len(parts) != 2 || !c.inCatalog(c.opts.SchemaChangesStream) {
+		logrus.WithField("stream", c.opts.SchemaChangesStream).Warn("schema changes stream isn't in the catalog, so the change won't be emitted")
+		return nil
+	}
+	return c.emitRecord(parts[0], parts[1], map[string]interface{}{
+		ChangeIDProperty: uuid.New().String(),
+		"_change_type":   "SchemaChange",
+		"schema":         event.Namespace,
+		"table":          event.Table,
+		"_schema_change": change,
+	})
+}
+
+// inCatalog returns true if the catalog includes the stream.
+func (c *capture) inCatalog(streamID string) bool {
+	for _, catalogStream := range c.catalog.Streams {
+		if JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name) == streamID {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBackfill transitions a table back into the backfill state, so that its
+// contents will be scanned again from the beginning while replication continues
+// for all other tables. Any partially buffered backfill results for the table are
+// discarded, since the scan will be restarting anyway.
+func (c *capture) requestBackfill(streamID string, results *resultSet) {
+	var tableState = c.state.Streams[streamID]
+	tableState.Mode = tableModeBackfill
+	tableState.Scanned = nil
+	tableState.SnapshotCursor = ""
+	tableState.BackfillFilter = ""
+	results.Discard(streamID)
+	c.backfillRequested = true
+}
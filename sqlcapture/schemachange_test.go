@@ -0,0 +1,174 @@
+package sqlcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/estuary/protocols/airbyte"
+)
+
+func TestDiffColumns(t *testing.T) {
+	var prev = map[string]string{"id": "int4", "name": "text", "age": "int4"}
+	for _, tc := range []struct {
+		name   string
+		next   map[string]string
+		expect string
+	}{
+		{"unchanged", map[string]string{"id": "int4", "name": "text", "age": "int4"}, `null`},
+		{"added", map[string]string{"id": "int4", "name": "text", "age": "int4", "email": "text"}, `{"added":["email"]}`},
+		{"dropped", map[string]string{"id": "int4", "age": "int4"}, `{"dropped":["name"]}`},
+		{"renamed", map[string]string{"id": "int4", "fullname": "text", "age": "int4"}, `{"renamed":[{"from":"name","to":"fullname"}]}`},
+		{"retyped", map[string]string{"id": "int8", "name": "text", "age": "int4"}, `{"retyped":[{"column":"id","from":"int4","to":"int8"}]}`},
+		{"replaced", map[string]string{"id": "int4", "name": "text", "birthday": "date"}, `{"added":["birthday"],"dropped":["age"]}`},
+		{"unknown", map[string]string{"id": "", "name": "text", "age": "int4"}, `null`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var bs, err = json.Marshal(diffColumns(prev, tc.next))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(bs) != tc.expect {
+				t.Errorf("diff mismatch: expected %s, got %s", tc.expect, bs)
+			}
+		})
+	}
+}
+
+func TestNonNullableJSONType(t *testing.T) {
+	for schema, expect := range map[string]string{
+		`{"type":"integer"}`: "integer",
+		`{"anyOf":[{"type":"string","format":"date-time"},{"type":"null"}]}`: "string/date-time",
+		`{"type":"string","contentEncoding":"base64","description":"foo"}`:   "string",
+		`{}`: "",
+	} {
+		if actual := nonNullableJSONType(json.RawMessage(schema)); actual != expect {
+			t.Errorf("schema %s: expected %q, got %q", schema, expect, actual)
+		}
+	}
+}
+
+// messageBuffer is a MessageOutput which collects the messages written to it.
+type messageBuffer struct {
+	messages []airbyte.Message
+}
+
+func (buf *messageBuffer) Encode(v interface{}) error {
+	buf.messages = append(buf.messages, v.(airbyte.Message))
+	return nil
+}
+
+func TestSchemaChangeEvent(t *testing.T) {
+	var stream = SchemaChangesStream("test.schema_changes")
+	var output = new(messageBuffer)
+	var c = &capture{
+		state: &PersistentState{Streams: map[string]*TableState{"test.foo": {Mode: tableModeActive, KeyColumns: []string{"id"}}}},
+		opts:  &Options{SchemaChangePolicy: SchemaChangeEvent, SchemaChangesStream: "test.schema_changes"},
+		catalog: &airbyte.ConfiguredCatalog{Streams: []airbyte.ConfiguredStream{
+			{Stream: airbyte.Stream{Namespace: "test", Name: "foo"}},
+			{Stream: stream},
+		}},
+		encoder:   output,
+		relations: map[string]map[string]string{"test.foo": {"id": "int4", "name": "text", "age": "int4"}},
+	}
+	var event = &ChangeEvent{Type: "Relation", Namespace: "test", Table: "foo", Columns: map[string]string{"id": "int8", "fullname": "text", "email": "text"}}
+	if err := c.handleRelation("test.foo", event, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.messages) != 1 || output.messages[0].Record == nil {
+		t.Fatalf("expected a single record, got %#v", output.messages)
+	}
+	var record = output.messages[0].Record
+	if record.Namespace != "test" || record.Stream != "schema_changes" {
+		t.Errorf("expected a record of the schema changes stream, got %s.%s", record.Namespace, record.Stream)
+	}
+
+	// The record must be valid according to the discovered schema of the stream.
+	var schema, doc interface{}
+	if err := json.Unmarshal(stream.JSONSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(record.Data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateDocument(schema.(map[string]interface{}), doc, ""); err != nil {
+		t.Errorf("record %s doesn't match the schema: %v", record.Data, err)
+	}
+	for _, key := range stream.SourceDefinedPrimaryKey {
+		if value, _ := doc.(map[string]interface{})[key[0]].(string); value == "" {
+			t.Errorf("record %s lacks key %q", record.Data, key[0])
+		}
+	}
+
+	// Without the schema changes stream in the catalog, nothing is emitted.
+	c.catalog.Streams = c.catalog.Streams[:1]
+	output.messages = nil
+	event = &ChangeEvent{Type: "Relation", Namespace: "test", Table: "foo", Columns: map[string]string{"id": "int8"}}
+	if err := c.handleRelation("test.foo", event, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.messages) != 0 {
+		t.Errorf("expected no records, got %#v", output.messages)
+	}
+}
+
+// validateDocument checks a document against the subset of JSON schema used by the
+// schemas of this package: types, enums, properties, required properties, and items.
+// Properties which the schema doesn't describe are rejected as well.
+func validateDocument(schema map[string]interface{}, doc interface{}, path string) error {
+	var actualType string
+	switch doc.(type) {
+	case map[string]interface{}:
+		actualType = "object"
+	case []interface{}:
+		actualType = "array"
+	case string:
+		actualType = "string"
+	case float64:
+		actualType = "number"
+	case bool:
+		actualType = "boolean"
+	case nil:
+		actualType = "null"
+	}
+	if expected, ok := schema["type"].(string); ok && expected != actualType {
+		return fmt.Errorf("%s: expected %s, got %s", path, expected, actualType)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		var found bool
+		for _, value := range enum {
+			found = found || value == doc
+		}
+		if !found {
+			return fmt.Errorf("%s: %v isn't one of %v", path, doc, enum)
+		}
+	}
+	switch x := doc.(type) {
+	case map[string]interface{}:
+		var properties, _ = schema["properties"].(map[string]interface{})
+		var required, _ = schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := x[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, value := range x {
+			var propertySchema, ok = properties[name].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s: undeclared property %q", path, name)
+			}
+			if err := validateDocument(propertySchema, value, path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for idx, item := range x {
+				if err := validateDocument(items, item, fmt.Sprintf("%s/%d", path, idx)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
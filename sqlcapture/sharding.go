@@ -0,0 +1,60 @@
+package sqlcapture
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/estuary/protocols/airbyte"
+)
+
+// IsFullRange returns true if the key range covers every row, as it does when a
+// capture isn't split into multiple shards. The zero range is treated as full,
+// since that's what an unsharded catalog specifies.
+func IsFullRange(r airbyte.Range) bool {
+	return r.IsZero() || (r.Begin == 0 && r.End == math.MaxUint32)
+}
+
+// ownsRow returns true if the row with the given field values belongs to the key
+// range of this capture shard, and so should be emitted by it. Rows are assigned
+// to shards by hashing their encoded primary key, so that the backfilled row and
+// every replicated change to it are handled by the same shard. Rows of keyless
+// tables can't be related across backfill and replication, so each keyless table
+// is instead assigned in its entirety to a single shard by hashing its stream ID.
+func (c *capture) ownsRow(streamID string, keyColumns []string, fields map[string]interface{}) (bool, error) {
+	if IsFullRange(c.catalog.Range) {
+		return true, nil
+	}
+	if c.isKeyless(keyColumns) {
+		return c.catalog.Range.IncludesHwHash([]byte(streamID)), nil
+	}
+
+	// In the polling capture mode the cursor column precedes the primary key of
+	// the table, but isn't part of the row's identity since it changes over time.
+	if c.opts.CaptureMode == CaptureModePolling && c.opts.PollCursorColumns[streamID] != "" {
+		keyColumns = keyColumns[1:]
+	}
+	var rowKey, err = encodeRowKey(keyColumns, fields)
+	if err != nil {
+		return false, fmt.Errorf("error encoding row key: %w", err)
+	}
+	return c.catalog.Range.IncludesHwHash(rowKey), nil
+}
+
+// ownedRows returns the subset of a list of scanned rows which belong to the key
+// range of this capture shard.
+func (c *capture) ownedRows(streamID string, keyColumns []string, events []*ChangeEvent) ([]*ChangeEvent, error) {
+	if IsFullRange(c.catalog.Range) {
+		return events, nil
+	}
+	var owned []*ChangeEvent
+	for _, event := range events {
+		var ok, err = c.ownsRow(streamID, keyColumns, event.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			owned = append(owned, event)
+		}
+	}
+	return owned, nil
+}
@@ -0,0 +1,45 @@
+package sqlcapture
+
+import (
+	"testing"
+
+	"github.com/estuary/protocols/airbyte"
+)
+
+func TestShardOwnership(t *testing.T) {
+	var newShard = func(begin, end uint32) *capture {
+		return &capture{
+			opts:    &Options{},
+			catalog: &airbyte.ConfiguredCatalog{Range: airbyte.Range{Begin: begin, End: end}},
+		}
+	}
+	var shards = []*capture{newShard(0, 0x7FFFFFFF), newShard(0x80000000, 0xFFFFFFFF)}
+	var full = newShard(0, 0)
+
+	var counts = make([]int, len(shards))
+	for id := 0; id < 100; id++ {
+		var fields = map[string]interface{}{"id": id, "data": "foo"}
+		var owners int
+		for idx, shard := range shards {
+			var ok, err = shard.ownsRow("test.foo", []string{"id"}, fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				owners++
+				counts[idx]++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("row %d owned by %d shards, expected exactly one", id, owners)
+		}
+		if ok, err := full.ownsRow("test.foo", []string{"id"}, fields); err != nil || !ok {
+			t.Errorf("row %d not owned by unsharded capture (err: %v)", id, err)
+		}
+	}
+	for idx, count := range counts {
+		if count == 0 {
+			t.Errorf("shard %d owns no rows", idx)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sqlcapture
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The columns of the signal table which are read by the capture. Other columns
+// (such as an ID or timestamp) may be present and are ignored.
+const (
+	signalColumnStream = "stream" // The "<namespace>.<table>" name of the table to backfill
+	signalColumnFilter = "filter" // An optional SQL predicate restricting which rows are backfilled
+)
+
+// handleSignal processes a row inserted into the signal table, which requests that
+// a particular table be backfilled again while replication continues for all other
+// tables. Invalid signals are logged and otherwise ignored, since failing the capture
+// wouldn't make the offending row go away.
+func (c *capture) handleSignal(event *ChangeEvent, results *resultSet) error {
+	var name, _ = event.Fields[signalColumnStream].(string)
+	var filter, _ = event.Fields[signalColumnFilter].(string)
+	var log = logrus.WithFields(logrus.Fields{
+		signalColumnStream: name,
+		signalColumnFilter: filter,
+	})
+
+	var parts = strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		log.Warn("ignoring signal: stream must be of the form <namespace>.<table>")
+		return nil
+	}
+	var streamID = JoinStreamID(parts[0], parts[1])
+	var tableState = c.state.Streams[streamID]
+	if tableState == nil || tableState.Mode == tableModeIgnore {
+		log.Warn("ignoring signal: stream is not being captured")
+		return nil
+	}
+	if streamID == c.opts.MessagesStream {
+		log.Warn("ignoring signal: messages can't be backfilled")
+		return nil
+	}
+
+	log.Info("backfill requested by signal")
+	c.requestBackfill(streamID, results)
+	tableState.BackfillFilter = strings.TrimSpace(filter)
+	return nil
+}
+
+// copyEvent returns a copy of a change event whose fields may be modified without
+// affecting the original.
+func copyEvent(event *ChangeEvent) *ChangeEvent {
+	var copied = *event
+	copied.Fields = make(map[string]interface{}, len(event.Fields))
+	for id, val := range event.Fields {
+		copied.Fields[id] = val
+	}
+	return &copied
+}
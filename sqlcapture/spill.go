@@ -0,0 +1,241 @@
+package sqlcapture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A spillFile is an append-only temporary file holding buffered rows which
+// didn't fit within the memory limit of a resultSet.
+type spillFile struct {
+	file *os.File
+	size int64
+}
+
+// A spillEntry locates a single serialized row within the spill file.
+type spillEntry struct {
+	offset int64
+	length int
+}
+
+// spilledRow is the serialized form of a buffered row. Field values have already
+// been translated by the database into their JSON-encodable representation, so
+// reading them back and translating them again is harmless. Byte slices and
+// non-finite floats are held separately, since the former would be read back as
+// base64 strings and JSON can't represent the latter at all.
+type spilledRow struct {
+	Type      string                  `json:"type"`
+	ChangeID  string                  `json:"changeID,omitempty"`
+	Namespace string                  `json:"namespace"`
+	Table     string                  `json:"table"`
+	Fields    map[string]interface{}  `json:"fields"`
+	Special   map[string]spilledValue `json:"special,omitempty"`
+	Unchanged []string                `json:"unchanged,omitempty"`
+}
+
+// A spilledValue is a field value which can't be spilled as plain JSON, along
+// with its type so that it can be restored exactly.
+type spilledValue struct {
+	Type  string `json:"type"`  // One of "bytes", "float32", or "float64"
+	Value string `json:"value"` // The base64 encoding of bytes, or the formatted float
+}
+
+// encodeSpilledValue returns the spilledValue of a byte slice or non-finite float,
+// or false if the value can be spilled as plain JSON.
+func encodeSpilledValue(val interface{}) (spilledValue, bool) {
+	switch x := val.(type) {
+	case []byte:
+		return spilledValue{Type: "bytes", Value: base64.StdEncoding.EncodeToString(x)}, true
+	case float32:
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return spilledValue{Type: "float32", Value: strconv.FormatFloat(float64(x), 'g', -1, 32)}, true
+		}
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return spilledValue{Type: "float64", Value: strconv.FormatFloat(x, 'g', -1, 64)}, true
+		}
+	}
+	return spilledValue{}, false
+}
+
+// decode restores the original field value of a spilledValue.
+func (v spilledValue) decode() (interface{}, error) {
+	switch v.Type {
+	case "bytes":
+		return base64.StdEncoding.DecodeString(v.Value)
+	case "float32":
+		var f, err = strconv.ParseFloat(v.Value, 32)
+		return float32(f), err
+	case "float64":
+		return strconv.ParseFloat(v.Value, 64)
+	}
+	return nil, fmt.Errorf("unknown spilled value type %q", v.Type)
+}
+
+// SetMemoryLimit configures the approximate number of bytes of row data which may
+// be held in memory before buffered rows are spilled to disk. The translate function
+// converts field values into a JSON-encodable form for serialization.
+func (r *resultSet) SetMemoryLimit(limit int, translate func(interface{}) (interface{}, error)) {
+	r.memoryLimit = limit
+	r.translate = translate
+}
+
+// Close releases the spill file, if any. It's safe to call on a nil resultSet.
+func (r *resultSet) Close() error {
+	if r == nil || r.spill == nil {
+		return nil
+	}
+	var name = r.spill.file.Name()
+	var err = r.spill.file.Close()
+	if rmErr := os.Remove(name); err == nil {
+		err = rmErr
+	}
+	r.spill = nil
+	return err
+}
+
+// putRow stores a row in memory, replacing any previous version of it.
+func (r *resultSet) putRow(chunk *backfillChunk, key string, event *ChangeEvent) {
+	r.removeRow(chunk, key)
+	chunk.rows[key] = event
+	r.memoryUsed += estimateRowSize(event)
+}
+
+// removeRow deletes a row from memory or from the spill index. Spilled row
+// data remains in the file until the resultSet is closed.
+func (r *resultSet) removeRow(chunk *backfillChunk, key string) {
+	if prev, ok := chunk.rows[key]; ok {
+		r.memoryUsed -= estimateRowSize(prev)
+		delete(chunk.rows, key)
+	}
+	delete(chunk.spilled, key)
+}
+
+// getRow returns the current version of a row, reading it back from the spill
+// file if necessary, or nil if no such row is buffered.
+func (r *resultSet) getRow(chunk *backfillChunk, key string) (*ChangeEvent, error) {
+	if event, ok := chunk.rows[key]; ok {
+		return event, nil
+	}
+	var entry, ok = chunk.spilled[key]
+	if !ok {
+		return nil, nil
+	}
+	var buf = make([]byte, entry.length)
+	if _, err := r.spill.file.ReadAt(buf, entry.offset); err != nil {
+		return nil, fmt.Errorf("error reading spilled row: %w", err)
+	}
+	var row spilledRow
+	var dec = json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&row); err != nil {
+		return nil, fmt.Errorf("error decoding spilled row: %w", err)
+	}
+	for id, special := range row.Special {
+		var val, err = special.decode()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding spilled field %q value: %w", id, err)
+		}
+		row.Fields[id] = val
+	}
+	return &ChangeEvent{
+		Type:      row.Type,
+		ChangeID:  row.ChangeID,
+		Namespace: row.Namespace,
+		Table:     row.Table,
+		Fields:    row.Fields,
+		Unchanged: row.Unchanged,
+	}, nil
+}
+
+// spillIfNeeded writes all in-memory rows of the chunk to disk once the memory
+// limit of the resultSet has been exceeded.
+func (r *resultSet) spillIfNeeded(streamID string, chunk *backfillChunk) error {
+	if r.memoryLimit <= 0 || r.memoryUsed <= r.memoryLimit || len(chunk.rows) == 0 {
+		return nil
+	}
+	if r.spill == nil {
+		var file, err = os.CreateTemp("", "backfill-spill-*")
+		if err != nil {
+			return fmt.Errorf("error creating spill file: %w", err)
+		}
+		r.spill = &spillFile{file: file}
+	}
+	logrus.WithFields(logrus.Fields{
+		"stream":     streamID,
+		"rows":       len(chunk.rows),
+		"memoryUsed": r.memoryUsed,
+		"limit":      r.memoryLimit,
+	}).Debug("spilling buffered rows to disk")
+
+	for key, event := range chunk.rows {
+		var row = spilledRow{
+			Type:      event.Type,
+			ChangeID:  event.ChangeID,
+			Namespace: event.Namespace,
+			Table:     event.Table,
+			Fields:    make(map[string]interface{}, len(event.Fields)),
+			Unchanged: event.Unchanged,
+		}
+		for id, val := range event.Fields {
+			if r.translate != nil {
+				var translated, err = r.translate(val)
+				if err != nil {
+					return fmt.Errorf("error translating field %q value: %w", id, err)
+				}
+				val = translated
+			}
+			if special, ok := encodeSpilledValue(val); ok {
+				if row.Special == nil {
+					row.Special = make(map[string]spilledValue)
+				}
+				row.Special[id] = special
+				continue
+			}
+			row.Fields[id] = val
+		}
+		var bs, err = json.Marshal(row)
+		if err != nil {
+			// Identify the offending field, such as one holding an array of floats
+			// with a non-finite element, so that the error is actionable.
+			for id, val := range row.Fields {
+				if _, fieldErr := json.Marshal(val); fieldErr != nil {
+					return fmt.Errorf("error serializing spilled field %q value: %w", id, fieldErr)
+				}
+			}
+			return fmt.Errorf("error serializing spilled row: %w", err)
+		}
+		if _, err := r.spill.file.WriteAt(bs, r.spill.size); err != nil {
+			return fmt.Errorf("error writing spill file: %w", err)
+		}
+		r.removeRow(chunk, key)
+		chunk.spilled[key] = spillEntry{offset: r.spill.size, length: len(bs)}
+		r.spill.size += int64(len(bs))
+	}
+	return nil
+}
+
+// estimateRowSize approximates the number of bytes of memory occupied by a row,
+// counting the contents of strings and byte slices and a fixed cost otherwise.
+func estimateRowSize(event *ChangeEvent) int {
+	var size = 0
+	for id, val := range event.Fields {
+		size += len(id)
+		switch x := val.(type) {
+		case string:
+			size += len(x)
+		case []byte:
+			size += len(x)
+		default:
+			size += 16
+		}
+	}
+	return size
+}
@@ -1,12 +1,16 @@
-package main
+package sqlcapture
 
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"github.com/estuary/protocols/fdb/tuple"
 )
 
+// sortableTimeFormat is the format of timestamps within row keys.
+const sortableTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
 // encodeRowKey extracts the appropriate key-fields by name from a map and encodes
 // them as a FoundationDB serialized tuple.
 func encodeRowKey(key []string, fields map[string]interface{}) ([]byte, error) {
@@ -31,8 +35,23 @@ func packTuple(xs []interface{}) (bs []byte, err error) {
 		// Values not natively supported by the FoundationDB tuple encoding code
 		// must be converted into ones that are.
 		switch x := x.(type) {
+		case int8:
+			t = append(t, int64(x))
+		case int16:
+			t = append(t, int64(x))
 		case int32:
-			t = append(t, int(x))
+			t = append(t, int64(x))
+		case uint8:
+			t = append(t, int64(x))
+		case uint16:
+			t = append(t, int64(x))
+		case uint32:
+			t = append(t, int64(x))
+		case time.Time:
+			// Timestamps (such as the cursor columns of polled tables) are encoded
+			// as fixed-width UTC strings, which sort in chronological order and can
+			// be passed back to the database as query arguments.
+			t = append(t, x.UTC().Format(sortableTimeFormat))
 		default:
 			t = append(t, x)
 		}
@@ -53,6 +72,8 @@ func packTuple(xs []interface{}) (bs []byte, err error) {
 	return tuple.Tuple(t).Pack(), nil
 }
 
+// unpackTuple decodes a FoundationDB serialized tuple into a list of values.
+// Integers are decoded as int64, and any nested tuples as tuple.Tuple values.
 func unpackTuple(bs []byte) ([]interface{}, error) {
 	var t, err = tuple.Unpack(bs)
 	if err != nil {
@@ -0,0 +1,36 @@
+package sqlcapture
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampKeyOrdering(t *testing.T) {
+	var est = time.FixedZone("EST", -5*60*60)
+	var times = []time.Time{
+		time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+		time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2000, 1, 1, 0, 0, 0, 1000, time.UTC),
+		time.Date(1999, 12, 31, 20, 0, 0, 10000, est), // 2000-01-01T01:00:00.00001Z
+		time.Date(2000, 1, 1, 1, 0, 0, 100000, time.UTC),
+	}
+	var prev []byte
+	for _, ts := range times {
+		var key, err = encodeRowKey([]string{"ts", "id"}, map[string]interface{}{"ts": ts, "id": 1})
+		if err != nil {
+			t.Fatalf("error encoding %v: %v", ts, err)
+		}
+		if prev != nil && compareTuples(prev, key) >= 0 {
+			t.Errorf("key of %v doesn't sort after its predecessor", ts)
+		}
+		prev = key
+	}
+
+	var resumeKey, err = decodeResumeKey([]string{"ts", "id"}, prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumeKey[0] != "2000-01-01T01:00:00.000100000Z" {
+		t.Errorf("unexpected timestamp resume value %q", resumeKey[0])
+	}
+}